@@ -21,8 +21,9 @@ type Job interface {
 }
 
 type Async struct {
-	config *config.Config
-	pubsub *pubsub.Client
+	config   *config.Config
+	pubsub   *pubsub.Client
+	dispatch Dispatch
 }
 
 type Message struct {
@@ -31,6 +32,10 @@ type Message struct {
 }
 
 func New(cfg *config.Config) (*Async, error) {
+	if cfg.IsLocal() {
+		return &Async{config: cfg}, nil
+	}
+
 	pubsubClient, err := pubsub.NewClient(context.Background(), cfg.ProjectID)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create pubsub client")
@@ -54,6 +59,11 @@ func (a *Async) Do(ctx context.Context, job Job) error {
 	}
 
 	if a.config.IsLocal() {
+		if os.Getenv("JOB_SERVER_URL") == "" && a.dispatch != nil {
+			go a.dispatch(context.Background(), Message{Name: job.Name(), Payload: payload})
+			return nil
+		}
+
 		if err := a.sendToLocalJobServer(job.Name(), data); err != nil {
 			return errors.Wrap(err, "failed to publish message to local job server")
 		}
@@ -68,6 +78,16 @@ func (a *Async) Do(ctx context.Context, job Job) error {
 	return nil
 }
 
+// PubSub is the client jobs are published with, for others to share. It's nil when running locally.
+func (a *Async) PubSub() *pubsub.Client {
+	return a.pubsub
+}
+
+// DispatchLocally runs jobs in process when running locally without a job server.
+func (a *Async) DispatchLocally(dispatch Dispatch) {
+	a.dispatch = dispatch
+}
+
 func (a *Async) sendToLocalJobServer(name string, data []byte) error {
 	jobServerURL := os.Getenv("JOB_SERVER_URL")
 	if jobServerURL == "" {
@@ -0,0 +1,112 @@
+package job
+
+import (
+	"context"
+	"time"
+
+	"github.com/broothie/slink.chat/db"
+	"github.com/broothie/slink.chat/model"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// AutoReplyJob answers a message sent in a private chat with the away message of each member who's away, like AIM
+// did.
+type AutoReplyJob struct {
+	MessageID string
+}
+
+func (j AutoReplyJob) Name() string {
+	return typeName(j)
+}
+
+func (s *Server) AutoReplyJob(ctx context.Context, payload AutoReplyJob) error {
+	logger := ctxzap.Extract(ctx).With(zap.String("message_id", payload.MessageID))
+
+	message, err := db.NewFetcher[model.Message](s.DB).Fetch(ctx, payload.MessageID)
+	if err != nil {
+		return errors.Wrap(err, "failed to fetch message")
+	}
+
+	// Two away users shouldn't auto-reply to each other forever
+	if message.AutoReply {
+		return nil
+	}
+
+	channel, err := db.NewFetcher[model.Channel](s.DB).Fetch(ctx, message.ChannelID)
+	if err != nil {
+		return errors.Wrap(err, "failed to fetch channel")
+	}
+
+	if !channel.Private {
+		return nil
+	}
+
+	subscriptions, err := db.NewFetcher[model.Subscription](s.DB).Query(ctx, func(query db.Query) db.Query {
+		return query.Where("channel_id", "==", channel.ID)
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to fetch subscriptions")
+	}
+
+	userIDs := make([]string, 0, len(subscriptions))
+	for _, subscription := range subscriptions {
+		userIDs = append(userIDs, subscription.UserID)
+	}
+
+	users, err := db.NewFetcher[model.User](s.DB).FetchMany(ctx, userIDs...)
+	if err != nil {
+		return errors.Wrap(err, "failed to fetch users")
+	}
+
+	var sender model.User
+	for _, user := range users {
+		if user.ID == message.UserID {
+			sender = user
+		}
+	}
+
+	for _, user := range users {
+		if user.ID == message.UserID || user.AwayMessage == "" {
+			continue
+		}
+
+		presences, err := db.NewFetcher[model.Presence](s.DB).Query(ctx, func(query db.Query) db.Query {
+			return query.Where("user_id", "==", user.ID)
+		})
+		if err != nil {
+			return errors.Wrap(err, "failed to fetch presences")
+		}
+
+		now := time.Now()
+		if model.Status(user, presences, now) != model.StatusAway {
+			continue
+		}
+
+		body := user.ExpandAwayMessage(sender.Screenname, now)
+		reply := model.Message{
+			ID:        model.AutoReplyID(channel.ID, user.ID, user.AwaySince),
+			CreatedAt: now,
+			UpdatedAt: now,
+			UserID:    user.ID,
+			ChannelID: channel.ID,
+			Body:      body,
+			HTML:      model.RenderBody(body),
+			AutoReply: true,
+		}
+
+		if _, _, err := db.CreateMessage(ctx, s.DB, reply); err != nil {
+			if err == db.AlreadyExists {
+				logger.Debug("already auto-replied this away session", zap.String("user_id", user.ID))
+				continue
+			}
+
+			return errors.Wrap(err, "failed to create auto-reply")
+		}
+
+		logger.Info("sent auto-reply", zap.String("user_id", user.ID))
+	}
+
+	return nil
+}
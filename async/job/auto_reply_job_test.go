@@ -0,0 +1,151 @@
+package job
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/broothie/slink.chat/config"
+	"github.com/broothie/slink.chat/core"
+	"github.com/broothie/slink.chat/db"
+	"github.com/broothie/slink.chat/model"
+	"github.com/rs/xid"
+	"go.uber.org/zap"
+)
+
+// autoReplyTest is a private chat between alice, who's away, and bob, who's online. Both have a live socket.
+type autoReplyTest struct {
+	server  *Server
+	channel model.Channel
+	alice   model.User
+	bob     model.User
+}
+
+func newAutoReplyTest(t *testing.T) *autoReplyTest {
+	t.Helper()
+
+	ctx := context.Background()
+	store, err := db.New(&config.Config{Environment: "test", Database: config.DatabaseMemory})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	test := &autoReplyTest{
+		server:  &Server{Core: core.Core{DB: store, Logger: zap.NewNop()}},
+		channel: model.Channel{ID: xid.New().String(), Name: "alice, bob", Private: true},
+		alice:   model.User{ID: "alice", Screenname: "alice", AwayMessage: "sorry %n, brb", AwaySince: time.Now().Add(-time.Hour)},
+		bob:     model.User{ID: "bob", Screenname: "bob"},
+	}
+
+	batch := store.Batch()
+	batch.Create(store.CollectionFor(test.channel.Type()).Doc(test.channel.ID), test.channel)
+	for _, user := range []model.User{test.alice, test.bob} {
+		subscription := model.NewSubscription(user.ID, test.channel.ID, model.RoleMember, time.Now())
+		presence := model.Presence{ID: xid.New().String(), UserID: user.ID, ExpiresAt: time.Now().Add(time.Hour)}
+
+		batch.Create(store.CollectionFor(user.Type()).Doc(user.ID), user)
+		batch.Create(store.CollectionFor(subscription.Type()).Doc(subscription.ID), subscription)
+		batch.Create(store.CollectionFor(presence.Type()).Doc(presence.ID), presence)
+	}
+
+	if err := batch.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	return test
+}
+
+// send posts a message from user, then runs the auto-reply job for it as sending would.
+func (a *autoReplyTest) send(t *testing.T, user model.User) {
+	t.Helper()
+
+	ctx := context.Background()
+	message := model.Message{ID: xid.New().String(), CreatedAt: time.Now(), UserID: user.ID, ChannelID: a.channel.ID, Body: "hi"}
+	message, _, err := db.CreateMessage(ctx, a.server.DB, message)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.server.AutoReplyJob(ctx, AutoReplyJob{MessageID: message.ID}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (a *autoReplyTest) autoReplies(t *testing.T) []model.Message {
+	t.Helper()
+
+	messages, err := db.NewFetcher[model.Message](a.server.DB).Query(context.Background(), func(query db.Query) db.Query {
+		return query.Where("channel_id", "==", a.channel.ID).OrderBy("seq", db.Asc)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var autoReplies []model.Message
+	for _, message := range messages {
+		if message.AutoReply {
+			autoReplies = append(autoReplies, message)
+		}
+	}
+
+	return autoReplies
+}
+
+func (a *autoReplyTest) setAway(t *testing.T, user model.User, awayMessage string, awaySince time.Time) {
+	t.Helper()
+
+	if err := a.server.DB.CollectionFor(user.Type()).Doc(user.ID).Update(context.Background(), []db.Update{
+		{Path: "away_message", Value: awayMessage},
+		{Path: "away_since", Value: awaySince},
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServer_AutoReplyJob(t *testing.T) {
+	t.Run("once per away session", func(t *testing.T) {
+		test := newAutoReplyTest(t)
+		test.send(t, test.bob)
+		test.send(t, test.bob)
+
+		autoReplies := test.autoReplies(t)
+		if len(autoReplies) != 1 || autoReplies[0].UserID != test.alice.ID || autoReplies[0].Body != "sorry bob, brb" {
+			t.Fatalf("got %+v, want alice's away message once", autoReplies)
+		}
+
+		// Going away again starts a new session, which replies again
+		test.setAway(t, test.alice, "back soon", time.Now())
+		test.send(t, test.bob)
+		if autoReplies := test.autoReplies(t); len(autoReplies) != 2 || autoReplies[1].Body != "back soon" {
+			t.Errorf("got %+v, want a reply for the new away session", autoReplies)
+		}
+	})
+
+	t.Run("not to own messages", func(t *testing.T) {
+		test := newAutoReplyTest(t)
+		test.send(t, test.alice)
+		if autoReplies := test.autoReplies(t); len(autoReplies) != 0 {
+			t.Errorf("got %+v, want no replies to alice's own message", autoReplies)
+		}
+	})
+
+	t.Run("not to each other", func(t *testing.T) {
+		test := newAutoReplyTest(t)
+		test.setAway(t, test.bob, "out", time.Now())
+
+		// Alice's auto-reply to bob is itself an auto-reply, which bob's away message doesn't answer
+		test.send(t, test.bob)
+		autoReplies := test.autoReplies(t)
+		if len(autoReplies) != 1 || autoReplies[0].UserID != test.alice.ID {
+			t.Fatalf("got %+v, want only alice's reply", autoReplies)
+		}
+
+		if err := test.server.AutoReplyJob(context.Background(), AutoReplyJob{MessageID: autoReplies[0].ID}); err != nil {
+			t.Fatal(err)
+		}
+
+		if autoReplies := test.autoReplies(t); len(autoReplies) != 1 {
+			t.Errorf("got %+v, want no reply to the auto-reply", autoReplies)
+		}
+	})
+}
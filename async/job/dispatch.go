@@ -29,6 +29,38 @@ func (s *Server) dispatch(ctx context.Context, message async.Message) error {
 		}
 
 		return s.NewChannelJob(ctx, payload)
+
+	case AutoReplyJob{}.Name():
+		var payload AutoReplyJob
+		if err := json.Unmarshal(message.Payload, &payload); err != nil {
+			return errors.Wrap(err, "failed to unmarshal payload")
+		}
+
+		return s.AutoReplyJob(ctx, payload)
+
+	case MentionJob{}.Name():
+		var payload MentionJob
+		if err := json.Unmarshal(message.Payload, &payload); err != nil {
+			return errors.Wrap(err, "failed to unmarshal payload")
+		}
+
+		return s.MentionJob(ctx, payload)
+
+	case ThumbnailJob{}.Name():
+		var payload ThumbnailJob
+		if err := json.Unmarshal(message.Payload, &payload); err != nil {
+			return errors.Wrap(err, "failed to unmarshal payload")
+		}
+
+		return s.ThumbnailJob(ctx, payload)
+
+	case UnfurlJob{}.Name():
+		var payload UnfurlJob
+		if err := json.Unmarshal(message.Payload, &payload); err != nil {
+			return errors.Wrap(err, "failed to unmarshal payload")
+		}
+
+		return s.UnfurlJob(ctx, payload)
 	}
 
 	return nil
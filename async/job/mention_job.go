@@ -0,0 +1,51 @@
+package job
+
+import (
+	"context"
+	"time"
+
+	"github.com/broothie/slink.chat/db"
+	"github.com/broothie/slink.chat/model"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// MentionJob notifies each user a message mentions, other than its author.
+type MentionJob struct {
+	MessageID string
+}
+
+func (j MentionJob) Name() string {
+	return typeName(j)
+}
+
+func (s *Server) MentionJob(ctx context.Context, payload MentionJob) error {
+	logger := ctxzap.Extract(ctx).With(zap.String("message_id", payload.MessageID))
+
+	message, err := db.NewFetcher[model.Message](s.DB).Fetch(ctx, payload.MessageID)
+	if err != nil {
+		return errors.Wrap(err, "failed to fetch message")
+	}
+
+	now := time.Now()
+	for _, userID := range message.Mentions {
+		if userID == message.UserID {
+			continue
+		}
+
+		notification := model.NewMentionNotification(userID, message, now)
+		if err := s.DB.CollectionFor(notification.Type()).Doc(notification.ID).Create(ctx, notification); err != nil {
+			if err == db.AlreadyExists {
+				logger.Debug("already notified", zap.String("user_id", userID))
+				continue
+			}
+
+			return errors.Wrap(err, "failed to create notification")
+		}
+
+		logger.Info("notified mention", zap.String("user_id", userID))
+	}
+
+	return nil
+}
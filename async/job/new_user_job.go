@@ -7,7 +7,6 @@ import (
 	"strings"
 	"time"
 
-	"cloud.google.com/go/firestore"
 	"github.com/broothie/slink.chat/db"
 	"github.com/broothie/slink.chat/model"
 	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
@@ -41,21 +40,21 @@ func (s *Server) NewUserJob(ctx context.Context, payload NewUserJob) error {
 	logger.Info("indexed user")
 
 	channelFetcher := db.NewFetcher[model.Channel](s.DB)
-	worldChat, err := channelFetcher.FetchFirst(ctx, func(query firestore.Query) firestore.Query {
-		return query.Where("name", "==", model.ChannelNameWorldChat).OrderBy("created_at", firestore.Asc)
+	worldChat, err := channelFetcher.FetchFirst(ctx, func(query db.Query) db.Query {
+		return query.Where("name", "==", model.ChannelNameWorldChat).OrderBy("created_at", db.Asc)
 	})
 	if err != nil {
 		return errors.Wrap(err, "failed to get world chat")
 	}
 
-	updates := []firestore.Update{{Path: "user_ids", Value: firestore.ArrayUnion(payload.UserID)}}
-	if _, err = s.DB.CollectionFor(worldChat.Type()).Doc(worldChat.ID).Update(ctx, updates); err != nil {
+	worldChatSubscription := model.NewSubscription(user.ID, worldChat.ID, model.RoleMember, time.Now())
+	if err := s.DB.CollectionFor(model.TypeSubscription).Doc(worldChatSubscription.ID).Create(ctx, worldChatSubscription); err != nil && err != db.AlreadyExists {
 		return errors.Wrap(err, "failed to create world chat subscription")
 	}
 
 	logger.Info("added user to World Chat")
 
-	smarterChild, err := userFetcher.FetchFirst(ctx, func(query firestore.Query) firestore.Query {
+	smarterChild, err := userFetcher.FetchFirst(ctx, func(query db.Query) db.Query {
 		return query.Where("screenname", "==", model.ScreennameSmarterChild)
 	})
 	if err != nil {
@@ -64,6 +63,7 @@ func (s *Server) NewUserJob(ctx context.Context, payload NewUserJob) error {
 
 	users := []model.User{user, smarterChild}
 	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	userIDs := lo.Map(users, func(user model.User, _ int) string { return user.ID })
 
 	now := time.Now()
 	smarterChildChat := model.Channel{
@@ -72,11 +72,18 @@ func (s *Server) NewUserJob(ctx context.Context, payload NewUserJob) error {
 		UpdatedAt: now,
 		Name:      strings.Join(lo.Map(users, func(user model.User, _ int) string { return user.Screenname }), ", "),
 		UserID:    smarterChild.ID,
-		UserIDs:   lo.Map(users, func(user model.User, _ int) string { return user.ID }),
+		ChatKey:   model.ChatKey(userIDs),
 		Private:   true,
 	}
 
-	if _, err := s.DB.CollectionFor(model.TypeChannel).Doc(smarterChildChat.ID).Create(ctx, smarterChildChat); err != nil {
+	batch := s.DB.Batch()
+	batch.Create(s.DB.CollectionFor(model.TypeChannel).Doc(smarterChildChat.ID), smarterChildChat)
+	for _, member := range users {
+		subscription := model.NewSubscription(member.ID, smarterChildChat.ID, lo.Ternary(member.ID == smarterChild.ID, model.RoleOwner, model.RoleMember), now)
+		batch.Create(s.DB.CollectionFor(subscription.Type()).Doc(subscription.ID), subscription)
+	}
+
+	if err := batch.Commit(ctx); err != nil {
 		return errors.Wrapf(err, "failed to create %s chat", model.ScreennameSmarterChild)
 	}
 
@@ -8,7 +8,6 @@ import (
 	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
 	"github.com/pkg/errors"
 	"golang.org/x/sync/errgroup"
-	"google.golang.org/api/iterator"
 )
 
 type ResetDatabase struct{}
@@ -20,7 +19,7 @@ func (j ResetDatabase) Name() string {
 func (s *Server) ResetDatabase(ctx context.Context) error {
 	ctxzap.Info(ctx, "resetting database")
 
-	for _, f := range []func(context.Context) error{s.deleteMessages, s.deleteChannels, s.deleteUsers} {
+	for _, f := range []func(context.Context) error{s.deleteMessages, s.deleteAttachments, s.deleteNotifications, s.deleteChannels, s.deleteUsers} {
 		if err := f(ctx); err != nil {
 			return err
 		}
@@ -32,21 +31,18 @@ func (s *Server) ResetDatabase(ctx context.Context) error {
 
 func (s *Server) deleteMessages(ctx context.Context) error {
 	ctxzap.Info(ctx, "deleting all messages")
-	docs := s.DB.CollectionFor(model.TypeMessage).
+	docs, err := s.DB.CollectionFor(model.TypeMessage).
 		Where("created_at", "<", time.Now().Add(-time.Hour)).
 		Documents(ctx)
+	if err != nil {
+		return errors.Wrap(err, "querying message refs")
+	}
 
 	group, ctx := errgroup.WithContext(ctx)
-	for {
-		doc, err := docs.Next()
-		if errors.Is(err, iterator.Done) {
-			break
-		} else if err != nil {
-			return errors.Wrap(err, "iterating over message refs")
-		}
-
+	for _, doc := range docs {
+		doc := doc
 		group.Go(func() error {
-			if _, err := doc.Ref.Delete(ctx); err != nil {
+			if err := s.DB.CollectionFor(model.TypeMessage).Doc(doc.ID()).Delete(ctx); err != nil {
 				return errors.Wrap(err, "deleting message ref")
 			}
 
@@ -57,32 +53,83 @@ func (s *Server) deleteMessages(ctx context.Context) error {
 	return group.Wait()
 }
 
+func (s *Server) deleteAttachments(ctx context.Context) error {
+	ctxzap.Info(ctx, "deleting all attachments")
+	docs, err := s.DB.CollectionFor(model.TypeAttachment).
+		Where("created_at", "<", time.Now().Add(-time.Hour)).
+		Documents(ctx)
+	if err != nil {
+		return errors.Wrap(err, "querying attachment refs")
+	}
+
+	group, ctx := errgroup.WithContext(ctx)
+	for _, doc := range docs {
+		attachment := model.Attachment{ID: doc.ID()}
+		group.Go(func() error {
+			for _, key := range []string{attachment.Key(), attachment.ThumbnailKey()} {
+				if err := s.Blobs.Delete(ctx, key); err != nil {
+					return errors.Wrap(err, "deleting attachment blob")
+				}
+			}
+
+			if err := s.DB.CollectionFor(model.TypeAttachment).Doc(attachment.ID).Delete(ctx); err != nil {
+				return errors.Wrap(err, "deleting attachment ref")
+			}
+
+			return nil
+		})
+	}
+
+	return group.Wait()
+}
+
+func (s *Server) deleteNotifications(ctx context.Context) error {
+	ctxzap.Info(ctx, "deleting all notifications")
+	docs, err := s.DB.CollectionFor(model.TypeNotification).
+		Where("created_at", "<", time.Now().Add(-time.Hour)).
+		Documents(ctx)
+	if err != nil {
+		return errors.Wrap(err, "querying notification refs")
+	}
+
+	group, ctx := errgroup.WithContext(ctx)
+	for _, doc := range docs {
+		doc := doc
+		group.Go(func() error {
+			if err := s.DB.CollectionFor(model.TypeNotification).Doc(doc.ID()).Delete(ctx); err != nil {
+				return errors.Wrap(err, "deleting notification ref")
+			}
+
+			return nil
+		})
+	}
+
+	return group.Wait()
+}
+
 func (s *Server) deleteChannels(ctx context.Context) error {
 	ctxzap.Info(ctx, "deleting all channels")
-	docs := s.DB.CollectionFor(model.TypeChannel).
+	docs, err := s.DB.CollectionFor(model.TypeChannel).
 		Where("name", "!=", model.ChannelNameWorldChat).
 		Where("created_at", "<", time.Now().Add(-time.Hour)).
 		Documents(ctx)
+	if err != nil {
+		return errors.Wrap(err, "querying channel docs")
+	}
 
 	group, ctx := errgroup.WithContext(ctx)
-	for {
-		doc, err := docs.Next()
-		if errors.Is(err, iterator.Done) {
-			break
-		} else if err != nil {
-			return errors.Wrap(err, "iterating over channel docs")
-		}
-
+	for _, doc := range docs {
+		doc := doc
 		group.Go(func() error {
-			if err := s.Search.DeleteChannel(doc.Ref.ID); err != nil {
+			if err := s.Search.DeleteChannel(doc.ID()); err != nil {
 				return errors.Wrap(err, "un-indexing channel")
 			}
 
-			if _, err := doc.Ref.Delete(ctx); err != nil {
+			if err := s.DB.CollectionFor(model.TypeChannel).Doc(doc.ID()).Delete(ctx); err != nil {
 				return errors.Wrap(err, "deleting channel doc")
 			}
 
-			return nil
+			return s.deleteSubscriptions(ctx, "channel_id", doc.ID())
 		})
 	}
 
@@ -91,32 +138,44 @@ func (s *Server) deleteChannels(ctx context.Context) error {
 
 func (s *Server) deleteUsers(ctx context.Context) error {
 	ctxzap.Info(ctx, "deleting all users")
-	docs := s.DB.CollectionFor(model.TypeUser).
+	docs, err := s.DB.CollectionFor(model.TypeUser).
 		Where("screenname", "!=", model.ScreennameSmarterChild).
 		Where("created_at", "<", time.Now().Add(-time.Hour)).
 		Documents(ctx)
+	if err != nil {
+		return errors.Wrap(err, "querying user docs")
+	}
 
 	group, ctx := errgroup.WithContext(ctx)
-	for {
-		doc, err := docs.Next()
-		if errors.Is(err, iterator.Done) {
-			break
-		} else if err != nil {
-			return errors.Wrap(err, "iterating over message docs")
-		}
-
+	for _, doc := range docs {
+		doc := doc
 		group.Go(func() error {
-			if err := s.Search.DeleteUser(doc.Ref.ID); err != nil {
+			if err := s.Search.DeleteUser(doc.ID()); err != nil {
 				return errors.Wrap(err, "un-indexing user")
 			}
 
-			if _, err := doc.Ref.Delete(ctx); err != nil {
+			if err := s.DB.CollectionFor(model.TypeUser).Doc(doc.ID()).Delete(ctx); err != nil {
 				return errors.Wrap(err, "deleting message doc")
 			}
 
-			return nil
+			return s.deleteSubscriptions(ctx, "user_id", doc.ID())
 		})
 	}
 
 	return group.Wait()
 }
+
+func (s *Server) deleteSubscriptions(ctx context.Context, path, id string) error {
+	docs, err := s.DB.CollectionFor(model.TypeSubscription).Where(path, "==", id).Documents(ctx)
+	if err != nil {
+		return errors.Wrap(err, "querying subscription docs")
+	}
+
+	for _, doc := range docs {
+		if err := s.DB.CollectionFor(model.TypeSubscription).Doc(doc.ID()).Delete(ctx); err != nil {
+			return errors.Wrap(err, "deleting subscription doc")
+		}
+	}
+
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/broothie/slink.chat/async"
 	"github.com/broothie/slink.chat/core"
+	"github.com/broothie/slink.chat/unfurl"
 	"github.com/broothie/slink.chat/util"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -15,10 +16,11 @@ import (
 
 type Server struct {
 	core.Core
+	Unfurler *unfurl.Unfurler
 }
 
 func NewServer(core core.Core) *Server {
-	return &Server{Core: core}
+	return &Server{Core: core, Unfurler: unfurl.New()}
 }
 
 func (s *Server) Handler() http.Handler {
@@ -0,0 +1,150 @@
+package job
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"time"
+
+	"github.com/broothie/slink.chat/db"
+	"github.com/broothie/slink.chat/model"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// thumbnailSize is the most a thumbnail can be on either side.
+const thumbnailSize = 256
+
+// ThumbnailJob makes a thumbnail of an image attachment. If the attachment's already been sent, its message is
+// updated to say it has one.
+type ThumbnailJob struct {
+	AttachmentID string
+}
+
+func (j ThumbnailJob) Name() string {
+	return typeName(j)
+}
+
+func (s *Server) ThumbnailJob(ctx context.Context, payload ThumbnailJob) error {
+	logger := ctxzap.Extract(ctx).With(zap.String("attachment_id", payload.AttachmentID))
+
+	attachment, err := db.NewFetcher[model.Attachment](s.DB).Fetch(ctx, payload.AttachmentID)
+	if err != nil {
+		return errors.Wrap(err, "failed to fetch attachment")
+	}
+
+	if !attachment.IsImage() || attachment.Thumbnail {
+		return nil
+	}
+
+	blob, err := s.Blobs.Get(ctx, attachment.Key())
+	if err != nil {
+		return errors.Wrap(err, "failed to get attachment blob")
+	}
+	defer blob.Close()
+
+	src, _, err := image.Decode(blob)
+	if err != nil {
+		return errors.Wrap(err, "failed to decode image")
+	}
+
+	var thumbnail bytes.Buffer
+	if err := jpeg.Encode(&thumbnail, resize(src, thumbnailSize), &jpeg.Options{Quality: 85}); err != nil {
+		return errors.Wrap(err, "failed to encode thumbnail")
+	}
+
+	if err := s.Blobs.Put(ctx, attachment.ThumbnailKey(), "image/jpeg", &thumbnail); err != nil {
+		return errors.Wrap(err, "failed to put thumbnail blob")
+	}
+
+	err = s.DB.RunTransaction(ctx, func(ctx context.Context, tx db.Transaction) error {
+		attachment, err := db.NewFetcher[model.Attachment](s.DB).FetchIn(tx, payload.AttachmentID)
+		if err != nil {
+			return err
+		}
+
+		var message model.Message
+		if attachment.Linked() {
+			if message, err = db.NewFetcher[model.Message](s.DB).FetchIn(tx, attachment.MessageID); err != nil && err != db.NotFound {
+				return err
+			}
+		}
+
+		attachment.Thumbnail = true
+		tx.Update(s.DB.CollectionFor(attachment.Type()).Doc(attachment.ID), []db.Update{{Path: "thumbnail", Value: true}})
+
+		for i := range message.Attachments {
+			if message.Attachments[i].ID == attachment.ID {
+				message.Attachments[i].Thumbnail = true
+				message.UpdatedAt = time.Now()
+				tx.Set(s.DB.CollectionFor(message.Type()).Doc(message.ID), message)
+				break
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to mark thumbnail")
+	}
+
+	logger.Info("made thumbnail")
+	return nil
+}
+
+// resize scales src down to fit within size by size, averaging the pixels each one covers, on a white background
+// since JPEG has no transparency. Images that already fit are only flattened.
+func resize(src image.Image, size int) image.Image {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > size || height > size {
+		if width >= height {
+			width, height = size, max(1, height*size/bounds.Dx())
+		} else {
+			width, height = max(1, width*size/bounds.Dy()), size
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*bounds.Dy()/height
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*bounds.Dy()/height)
+
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*bounds.Dx()/width
+			x1 := max(x0+1, bounds.Min.X+(x+1)*bounds.Dx()/width)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					sr, sg, sb, sa := src.At(sx, sy).RGBA()
+					r, g, b, a, n = r+uint64(sr), g+uint64(sg), b+uint64(sb), a+uint64(sa), n+1
+				}
+			}
+
+			// Colors are premultiplied, so white shows through in proportion to transparency
+			white := 0xffff*n - a
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8((r + white) / n >> 8),
+				G: uint8((g + white) / n >> 8),
+				B: uint8((b + white) / n >> 8),
+				A: 0xff,
+			})
+		}
+	}
+
+	return dst
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+
+	return b
+}
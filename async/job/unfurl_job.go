@@ -0,0 +1,91 @@
+package job
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	"github.com/broothie/slink.chat/db"
+	"github.com/broothie/slink.chat/model"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// maxPreviews is how many of a message's links get previews.
+const maxPreviews = 3
+
+// UnfurlJob previews the links in a message. Links that can't be previewed, because they're private, slow, too
+// big or not web pages, are skipped.
+type UnfurlJob struct {
+	MessageID string
+}
+
+func (j UnfurlJob) Name() string {
+	return typeName(j)
+}
+
+func (s *Server) UnfurlJob(ctx context.Context, payload UnfurlJob) error {
+	logger := ctxzap.Extract(ctx).With(zap.String("message_id", payload.MessageID))
+
+	message, err := db.NewFetcher[model.Message](s.DB).Fetch(ctx, payload.MessageID)
+	if err != nil {
+		return errors.Wrap(err, "failed to fetch message")
+	}
+
+	if message.Deleted() || message.AutoReply {
+		return nil
+	}
+
+	links := message.Links()
+	if len(links) > maxPreviews {
+		links = links[:maxPreviews]
+	}
+
+	previews := make([]model.Preview, 0, len(links))
+	for _, link := range links {
+		if preview, ok := findPreview(message.Previews, link); ok {
+			previews = append(previews, preview)
+			continue
+		}
+
+		preview, err := s.Unfurler.Fetch(ctx, link)
+		if err != nil {
+			logger.Info("failed to unfurl link", zap.String("url", link), zap.Error(err))
+			continue
+		}
+
+		previews = append(previews, preview)
+	}
+
+	_, err = db.UpdateMessage(ctx, s.DB, message.ID, func(current *model.Message) (bool, error) {
+		// An edit since the message was fetched queues its own unfurl
+		if current.Deleted() || current.Body != message.Body || reflect.DeepEqual(current.Previews, previews) {
+			return false, nil
+		}
+
+		if len(current.Previews) == 0 && len(previews) == 0 {
+			return false, nil
+		}
+
+		current.Previews = previews
+		current.UpdatedAt = time.Now()
+		return true, nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to update message")
+	}
+
+	logger.Info("unfurled links", zap.Int("previews", len(previews)))
+	return nil
+}
+
+func findPreview(previews []model.Preview, url string) (model.Preview, bool) {
+	for _, preview := range previews {
+		if preview.URL == url {
+			return preview, true
+		}
+	}
+
+	return model.Preview{}, false
+}
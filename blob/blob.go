@@ -0,0 +1,38 @@
+package blob
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/broothie/slink.chat/config"
+	"github.com/pkg/errors"
+)
+
+// NotFound is returned for keys with nothing stored under them.
+var NotFound = errors.New("blob not found")
+
+// Store keeps files, like uploaded attachments, under slash-separated keys.
+type Store interface {
+	Put(ctx context.Context, key, contentType string, r io.Reader) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// New builds the store cfg asks for.
+func New(cfg *config.Config) (Store, error) {
+	switch cfg.BlobStore {
+	case config.BlobStoreLocal:
+		return NewLocal(cfg.BlobDir)
+
+	case config.BlobStoreGCS:
+		if cfg.BlobBucket == "" {
+			return nil, errors.New("gcs blob store needs a bucket")
+		}
+
+		return NewGCS(context.Background(), cfg.BlobBucket)
+
+	default:
+		return nil, fmt.Errorf("unknown blob store %q", cfg.BlobStore)
+	}
+}
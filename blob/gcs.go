@@ -0,0 +1,61 @@
+package blob
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/storage/v1"
+)
+
+// GCS keeps blobs as objects in a Cloud Storage bucket.
+type GCS struct {
+	bucket  string
+	service *storage.Service
+}
+
+func NewGCS(ctx context.Context, bucket string) (*GCS, error) {
+	service, err := storage.NewService(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create storage service")
+	}
+
+	return &GCS{bucket: bucket, service: service}, nil
+}
+
+func (g *GCS) Put(ctx context.Context, key, contentType string, r io.Reader) error {
+	object := &storage.Object{Name: key, ContentType: contentType}
+	if _, err := g.service.Objects.Insert(g.bucket, object).Media(r, googleapi.ContentType(contentType)).Context(ctx).Do(); err != nil {
+		return errors.Wrap(err, "failed to upload object")
+	}
+
+	return nil
+}
+
+func (g *GCS) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	response, err := g.service.Objects.Get(g.bucket, key).Context(ctx).Download()
+	if err != nil {
+		if isNotFound(err) {
+			return nil, NotFound
+		}
+
+		return nil, errors.Wrap(err, "failed to download object")
+	}
+
+	return response.Body, nil
+}
+
+func (g *GCS) Delete(ctx context.Context, key string) error {
+	if err := g.service.Objects.Delete(g.bucket, key).Context(ctx).Do(); err != nil && !isNotFound(err) {
+		return errors.Wrap(err, "failed to delete object")
+	}
+
+	return nil
+}
+
+func isNotFound(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound
+}
@@ -0,0 +1,99 @@
+package blob
+
+import (
+	"context"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Local keeps blobs as files under a directory, for running locally.
+type Local struct {
+	dir string
+}
+
+func NewLocal(dir string) (*Local, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, errors.Wrap(err, "failed to create blob directory")
+	}
+
+	return &Local{dir: dir}, nil
+}
+
+// Put writes to a temporary file first, so readers never see half a blob.
+func (l *Local) Put(_ context.Context, key, _ string, r io.Reader) error {
+	filename, err := l.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+		return errors.Wrap(err, "failed to create blob directory")
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(filename), ".upload-*")
+	if err != nil {
+		return errors.Wrap(err, "failed to create blob file")
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		return errors.Wrap(err, "failed to write blob")
+	}
+
+	if err := file.Close(); err != nil {
+		return errors.Wrap(err, "failed to close blob file")
+	}
+
+	if err := os.Rename(file.Name(), filename); err != nil {
+		return errors.Wrap(err, "failed to move blob into place")
+	}
+
+	return nil
+}
+
+func (l *Local) Get(_ context.Context, key string) (io.ReadCloser, error) {
+	filename, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, NotFound
+		}
+
+		return nil, errors.Wrap(err, "failed to open blob")
+	}
+
+	return file, nil
+}
+
+func (l *Local) Delete(_ context.Context, key string) error {
+	filename, err := l.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to delete blob")
+	}
+
+	return nil
+}
+
+// path is where key lives, refusing keys that would leave the directory.
+func (l *Local) path(key string) (string, error) {
+	cleaned := path.Clean("/" + key)
+	if cleaned == "/" || cleaned != "/"+key || strings.Contains(key, "\\") {
+		return "", errors.Errorf("invalid blob key %q", key)
+	}
+
+	return filepath.Join(l.dir, filepath.FromSlash(cleaned)), nil
+}
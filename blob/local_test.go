@@ -0,0 +1,89 @@
+package blob
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocal_Path(t *testing.T) {
+	dir := t.TempDir()
+	local := &Local{dir: dir}
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"attachments/abc", filepath.Join(dir, "attachments", "abc")},
+		{"thumbnails/abc.jpg", filepath.Join(dir, "thumbnails", "abc.jpg")},
+		{"../secret", ""},
+		{"attachments/../../secret", ""},
+		{"attachments/../thumbnails/abc.jpg", ""},
+		{"./attachments/abc", ""},
+		{"/etc/passwd", ""},
+		{"attachments//abc", ""},
+		{"attachments/", ""},
+		{`attachments\..\..\secret`, ""},
+		{"..", ""},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.key, func(t *testing.T) {
+			got, err := local.path(test.key)
+			if test.want == "" {
+				if err == nil {
+					t.Errorf("got %q, want the key refused", got)
+				}
+
+				return
+			}
+
+			if err != nil || got != test.want {
+				t.Errorf("got %q, %v, want %q", got, err, test.want)
+			}
+		})
+	}
+}
+
+func TestLocal(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	local, err := NewLocal(filepath.Join(dir, "blobs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := local.Put(ctx, "attachments/abc", "text/plain", strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := local.Get(ctx, "attachments/abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := io.ReadAll(body)
+	body.Close()
+	if err != nil || string(data) != "hello" {
+		t.Errorf("got %q, %v, want what was put", data, err)
+	}
+
+	if err := local.Put(ctx, "../escaped", "text/plain", strings.NewReader("nope")); err == nil {
+		t.Error("put outside the directory")
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "escaped")); !os.IsNotExist(err) {
+		t.Errorf("got %v, want nothing written outside the directory", err)
+	}
+
+	if err := local.Delete(ctx, "attachments/abc"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := local.Get(ctx, "attachments/abc"); err != NotFound {
+		t.Errorf("got %v, want NotFound once deleted", err)
+	}
+}
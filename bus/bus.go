@@ -0,0 +1,91 @@
+package bus
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/broothie/slink.chat/config"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// Bus broadcasts messages between server instances. Handlers see what other instances publish, never what their
+// own instance does; delivering to itself is up to the publisher, which usually already has what it published.
+// Delivery is best effort and unordered.
+type Bus interface {
+	Publish(ctx context.Context, topic string, data []byte) error
+	Subscribe(topic string, handler Handler) (cancel func())
+	Close() error
+}
+
+type Handler func(data []byte)
+
+// New builds the bus cfg asks for. The Pub/Sub bus needs client, which local environments don't have.
+func New(cfg *config.Config, logger *zap.Logger, client *pubsub.Client) (Bus, error) {
+	switch cfg.Broadcast {
+	case config.BroadcastMemory:
+		return NewMemory(), nil
+
+	case config.BroadcastPubSub:
+		if client == nil {
+			return nil, errors.New("pubsub broadcast needs a pubsub client")
+		}
+
+		return NewPubSub(context.Background(), logger, client, cfg.BroadcastTopic)
+
+	case config.BroadcastTCP:
+		return NewTCP(logger, cfg.BroadcastAddr), nil
+
+	default:
+		return nil, fmt.Errorf("unknown broadcast %q", cfg.Broadcast)
+	}
+}
+
+// handlers keeps an instance's subscriptions, for buses to hand what arrives from other instances to.
+type handlers struct {
+	mu     sync.Mutex
+	nextID int
+	topics map[string]map[int]Handler
+}
+
+func newHandlers() *handlers {
+	return &handlers{topics: make(map[string]map[int]Handler)}
+}
+
+func (h *handlers) add(topic string, handler Handler) func() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	id := h.nextID
+	h.nextID++
+	if h.topics[topic] == nil {
+		h.topics[topic] = make(map[int]Handler)
+	}
+
+	h.topics[topic][id] = handler
+	return func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		delete(h.topics[topic], id)
+		if len(h.topics[topic]) == 0 {
+			delete(h.topics, topic)
+		}
+	}
+}
+
+// dispatch calls each handler for topic with data, outside the lock so handlers can subscribe and unsubscribe.
+func (h *handlers) dispatch(topic string, data []byte) {
+	h.mu.Lock()
+	subscribed := make([]Handler, 0, len(h.topics[topic]))
+	for _, handler := range h.topics[topic] {
+		subscribed = append(subscribed, handler)
+	}
+	h.mu.Unlock()
+
+	for _, handler := range subscribed {
+		handler(data)
+	}
+}
@@ -0,0 +1,67 @@
+package bus
+
+import (
+	"context"
+	"sync"
+)
+
+// Memory connects instances running in the same process. A lone Memory bus has no one to broadcast to, which is
+// all a single instance needs; Peer adds more.
+type Memory struct {
+	network  *memoryNetwork
+	handlers *handlers
+}
+
+type memoryNetwork struct {
+	mu    sync.Mutex
+	peers map[*Memory]struct{}
+}
+
+func NewMemory() *Memory {
+	return (&memoryNetwork{peers: make(map[*Memory]struct{})}).join()
+}
+
+// Peer returns a bus for another instance on m's network.
+func (m *Memory) Peer() *Memory {
+	return m.network.join()
+}
+
+func (n *memoryNetwork) join() *Memory {
+	m := &Memory{network: n, handlers: newHandlers()}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.peers[m] = struct{}{}
+	return m
+}
+
+func (m *Memory) Publish(_ context.Context, topic string, data []byte) error {
+	m.network.mu.Lock()
+	peers := make([]*Memory, 0, len(m.network.peers))
+	for peer := range m.network.peers {
+		if peer != m {
+			peers = append(peers, peer)
+		}
+	}
+	m.network.mu.Unlock()
+
+	for _, peer := range peers {
+		peer.handlers.dispatch(topic, append([]byte(nil), data...))
+	}
+
+	return nil
+}
+
+func (m *Memory) Subscribe(topic string, handler Handler) func() {
+	return m.handlers.add(topic, handler)
+}
+
+// Close leaves the network.
+func (m *Memory) Close() error {
+	m.network.mu.Lock()
+	defer m.network.mu.Unlock()
+
+	delete(m.network.peers, m)
+	return nil
+}
@@ -0,0 +1,106 @@
+package bus
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/pkg/errors"
+	"github.com/rs/xid"
+	"go.uber.org/zap"
+)
+
+const (
+	topicAttribute  = "topic"
+	originAttribute = "origin"
+
+	// Instance subscriptions left behind by a crash are cleaned up after a day without use, the least Pub/Sub
+	// allows.
+	subscriptionExpiry = 24 * time.Hour
+)
+
+// PubSub broadcasts over a single Pub/Sub topic. Each instance gets its own subscription to it, so every instance
+// sees every message, and messages carry the bus topic as an attribute.
+type PubSub struct {
+	logger       *zap.Logger
+	origin       string
+	topic        *pubsub.Topic
+	subscription *pubsub.Subscription
+	handlers     *handlers
+	cancel       context.CancelFunc
+	done         chan struct{}
+}
+
+func NewPubSub(ctx context.Context, logger *zap.Logger, client *pubsub.Client, topicID string) (*PubSub, error) {
+	origin := xid.New().String()
+	topic := client.Topic(topicID)
+
+	subscription, err := client.CreateSubscription(ctx, topicID+"-"+origin, pubsub.SubscriptionConfig{
+		Topic:            topic,
+		AckDeadline:      10 * time.Second,
+		ExpirationPolicy: subscriptionExpiry,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create broadcast subscription")
+	}
+
+	receiveCtx, cancel := context.WithCancel(context.Background())
+	p := &PubSub{
+		logger:       logger.With(zap.String("broadcast_origin", origin)),
+		origin:       origin,
+		topic:        topic,
+		subscription: subscription,
+		handlers:     newHandlers(),
+		cancel:       cancel,
+		done:         make(chan struct{}),
+	}
+
+	go p.receive(receiveCtx)
+	return p, nil
+}
+
+func (p *PubSub) Publish(ctx context.Context, topic string, data []byte) error {
+	message := &pubsub.Message{
+		Data:       data,
+		Attributes: map[string]string{topicAttribute: topic, originAttribute: p.origin},
+	}
+
+	if _, err := p.topic.Publish(ctx, message).Get(ctx); err != nil {
+		return errors.Wrap(err, "failed to publish broadcast")
+	}
+
+	return nil
+}
+
+func (p *PubSub) Subscribe(topic string, handler Handler) func() {
+	return p.handlers.add(topic, handler)
+}
+
+// Close stops receiving and deletes the instance's subscription.
+func (p *PubSub) Close() error {
+	p.cancel()
+	<-p.done
+	p.topic.Stop()
+
+	if err := p.subscription.Delete(context.Background()); err != nil {
+		return errors.Wrap(err, "failed to delete broadcast subscription")
+	}
+
+	return nil
+}
+
+func (p *PubSub) receive(ctx context.Context) {
+	defer close(p.done)
+
+	err := p.subscription.Receive(ctx, func(_ context.Context, message *pubsub.Message) {
+		message.Ack()
+		if message.Attributes[originAttribute] == p.origin {
+			return
+		}
+
+		p.handlers.dispatch(message.Attributes[topicAttribute], message.Data)
+	})
+	if err != nil {
+		p.logger.Error("broadcast subscription failed", zap.Error(err))
+	}
+}
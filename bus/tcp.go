@@ -0,0 +1,199 @@
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	tcpRetryInterval = time.Second
+	tcpWriteTimeout  = time.Second
+)
+
+// TCP connects instances on one machine, for running several locally. Whichever instance binds addr first relays
+// for the rest, which dial it; if the relay goes away, the others race to take its place.
+type TCP struct {
+	logger   *zap.Logger
+	addr     string
+	handlers *handlers
+	ctx      context.Context
+	cancel   context.CancelFunc
+	done     chan struct{}
+
+	mu    sync.Mutex
+	peers map[*tcpPeer]struct{}
+}
+
+type tcpPeer struct {
+	conn net.Conn
+
+	mu      sync.Mutex
+	encoder *json.Encoder
+}
+
+type tcpFrame struct {
+	Topic string `json:"topic"`
+	Data  []byte `json:"data"`
+}
+
+func NewTCP(logger *zap.Logger, addr string) *TCP {
+	ctx, cancel := context.WithCancel(context.Background())
+	t := &TCP{
+		logger:   logger.With(zap.String("broadcast_addr", addr)),
+		addr:     addr,
+		handlers: newHandlers(),
+		ctx:      ctx,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+		peers:    make(map[*tcpPeer]struct{}),
+	}
+
+	go t.run()
+	return t
+}
+
+func (t *TCP) Publish(_ context.Context, topic string, data []byte) error {
+	t.forward(tcpFrame{Topic: topic, Data: data}, nil)
+	return nil
+}
+
+func (t *TCP) Subscribe(topic string, handler Handler) func() {
+	return t.handlers.add(topic, handler)
+}
+
+// Close disconnects from the other instances, handing off the relay if this instance had it.
+func (t *TCP) Close() error {
+	t.cancel()
+
+	t.mu.Lock()
+	for peer := range t.peers {
+		peer.conn.Close()
+	}
+	t.mu.Unlock()
+
+	<-t.done
+	return nil
+}
+
+func (t *TCP) run() {
+	defer close(t.done)
+
+	for {
+		if listener, err := net.Listen("tcp", t.addr); err == nil {
+			t.logger.Info("relaying broadcasts")
+			t.relay(listener)
+		} else if conn, err := net.Dial("tcp", t.addr); err == nil {
+			t.logger.Info("following broadcast relay")
+			if peer := t.add(conn); peer != nil {
+				t.read(peer, false)
+			}
+		} else {
+			t.logger.Debug("failed to reach broadcast relay", zap.Error(err))
+		}
+
+		select {
+		case <-t.ctx.Done():
+			return
+		case <-time.After(tcpRetryInterval):
+		}
+	}
+}
+
+// relay accepts the other instances until Close.
+func (t *TCP) relay(listener net.Listener) {
+	go func() {
+		<-t.ctx.Done()
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		if peer := t.add(conn); peer != nil {
+			go t.read(peer, true)
+		}
+	}
+}
+
+// read hands peer's frames to the instance's handlers until the connection ends. A relay forwards them to every
+// other peer as well.
+func (t *TCP) read(peer *tcpPeer, relay bool) {
+	defer t.remove(peer)
+
+	decoder := json.NewDecoder(peer.conn)
+	for {
+		var frame tcpFrame
+		if err := decoder.Decode(&frame); err != nil {
+			if t.ctx.Err() == nil {
+				t.logger.Info("broadcast peer disconnected", zap.Error(err))
+			}
+
+			return
+		}
+
+		t.handlers.dispatch(frame.Topic, frame.Data)
+		if relay {
+			t.forward(frame, peer)
+		}
+	}
+}
+
+// forward writes frame to every peer but from.
+func (t *TCP) forward(frame tcpFrame, from *tcpPeer) {
+	t.mu.Lock()
+	peers := make([]*tcpPeer, 0, len(t.peers))
+	for peer := range t.peers {
+		if peer != from {
+			peers = append(peers, peer)
+		}
+	}
+	t.mu.Unlock()
+
+	for _, peer := range peers {
+		if err := peer.write(frame); err != nil {
+			t.logger.Info("failed to write broadcast", zap.Error(err))
+			t.remove(peer)
+		}
+	}
+}
+
+func (t *TCP) add(conn net.Conn) *tcpPeer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.ctx.Err() != nil {
+		conn.Close()
+		return nil
+	}
+
+	peer := &tcpPeer{conn: conn, encoder: json.NewEncoder(conn)}
+	t.peers[peer] = struct{}{}
+	return peer
+}
+
+func (t *TCP) remove(peer *tcpPeer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.peers, peer)
+	peer.conn.Close()
+}
+
+func (peer *tcpPeer) write(frame tcpFrame) error {
+	peer.mu.Lock()
+	defer peer.mu.Unlock()
+
+	if err := peer.conn.SetWriteDeadline(time.Now().Add(tcpWriteTimeout)); err != nil {
+		return err
+	}
+
+	return peer.encoder.Encode(frame)
+}
@@ -5,15 +5,11 @@ import (
 	"flag"
 	"fmt"
 	"os"
-	"time"
 
 	"github.com/broothie/slink.chat/config"
-	"github.com/broothie/slink.chat/db"
-	"github.com/broothie/slink.chat/model"
+	pkgdb "github.com/broothie/slink.chat/db"
 	"github.com/broothie/slink.chat/search"
-	"github.com/gorilla/securecookie"
 	_ "github.com/joho/godotenv/autoload"
-	"github.com/rs/xid"
 )
 
 func main() {
@@ -31,41 +27,21 @@ func main() {
 		os.Exit(1)
 	}
 
-	db, err := db.New(cfg)
+	db, err := pkgdb.New(cfg)
 	if err != nil {
 		fmt.Println("failed to get new db", err)
 		os.Exit(1)
 	}
 
-	src := search.NewAlgolia(cfg)
-
-	now := time.Now()
-	smarterChild := model.User{
-		ID:         xid.New().String(),
-		CreatedAt:  now,
-		UpdatedAt:  now,
-		Screenname: model.ScreennameSmarterChild,
-	}
-
-	if err := smarterChild.UpdatePassword(string(securecookie.GenerateRandomKey(32))); err != nil {
-		fmt.Println("failed to generate password", err)
+	src, err := search.New(cfg, db)
+	if err != nil {
+		fmt.Println("failed to get new search", err)
 		os.Exit(1)
 	}
 
-	// Create World Chat
-	worldChat := model.Channel{
-		ID:        xid.New().String(),
-		CreatedAt: now,
-		UpdatedAt: now,
-		UserID:    smarterChild.ID,
-		UserIDs:   []string{smarterChild.ID},
-		Name:      model.ChannelNameWorldChat,
-	}
-
-	batch := db.Batch()
-	batch.Create(db.CollectionFor(smarterChild.Type()).Doc(smarterChild.ID), smarterChild)
-	batch.Create(db.CollectionFor(worldChat.Type()).Doc(worldChat.ID), worldChat)
-	if _, err := batch.Commit(context.Background()); err != nil {
+	// Create SmarterChild and World Chat
+	smarterChild, worldChat, err := pkgdb.Seed(context.Background(), db)
+	if err != nil {
 		fmt.Println("failed to init db defaults", err)
 		os.Exit(1)
 	}
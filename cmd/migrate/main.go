@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/broothie/slink.chat/config"
+	pkgdb "github.com/broothie/slink.chat/db"
+	"github.com/broothie/slink.chat/migration"
+	_ "github.com/joho/godotenv/autoload"
+)
+
+func main() {
+	environment := flag.String("e", "development", "environment to run in")
+	dryRun := flag.Bool("dry-run", false, "report what pending migrations would change without writing")
+	flag.Parse()
+
+	if err := os.Setenv("ENVIRONMENT", *environment); err != nil {
+		fmt.Println("failed to set environment", err)
+		os.Exit(1)
+	}
+
+	cfg, err := config.New()
+	if err != nil {
+		fmt.Println("failed to get new config", err)
+		os.Exit(1)
+	}
+
+	db, err := pkgdb.New(cfg)
+	if err != nil {
+		fmt.Println("failed to get new db", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	if err := migration.New(db, os.Stdout).Run(context.Background(), *dryRun); err != nil {
+		fmt.Println("failed to migrate", err)
+		os.Exit(1)
+	}
+}
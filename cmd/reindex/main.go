@@ -7,7 +7,6 @@ import (
 	"log"
 	"os"
 
-	"cloud.google.com/go/firestore"
 	"github.com/algolia/algoliasearch-client-go/v3/algolia/search"
 	"github.com/broothie/slink.chat/config"
 	pkgdb "github.com/broothie/slink.chat/db"
@@ -29,6 +28,10 @@ func main() {
 		log.Fatalln("failed to get new config", err)
 	}
 
+	if cfg.SearchBackend() != config.SearchAlgolia {
+		log.Fatalln("only algolia search has an index to rebuild")
+	}
+
 	db, err := pkgdb.New(cfg)
 	if err != nil {
 		log.Fatalln("failed to get new db", err)
@@ -38,7 +41,7 @@ func main() {
 
 	log.Println("fetching users")
 	userFetcher := pkgdb.NewFetcher[model.User](db)
-	users, err := userFetcher.Query(context.Background(), func(query firestore.Query) firestore.Query { return query })
+	users, err := userFetcher.Query(context.Background(), func(query pkgdb.Query) pkgdb.Query { return query })
 	if err != nil {
 		log.Fatalln("failed to fetch users", err)
 	}
@@ -59,7 +62,7 @@ func main() {
 
 	log.Println("fetching channels")
 	channelFetcher := pkgdb.NewFetcher[model.Channel](db)
-	channels, err := channelFetcher.Query(context.Background(), func(query firestore.Query) firestore.Query { return query })
+	channels, err := channelFetcher.Query(context.Background(), func(query pkgdb.Query) pkgdb.Query { return query })
 	if err != nil {
 		log.Fatalln("failed to fetch channels", err)
 	}
@@ -1,11 +1,13 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 
+	"github.com/broothie/slink.chat/async/job"
 	"github.com/broothie/slink.chat/config"
 	"github.com/broothie/slink.chat/core"
 	"github.com/broothie/slink.chat/server"
@@ -24,12 +26,24 @@ func main() {
 		log.Fatalln("failed to get new core", err)
 	}
 
+	core.Async.DispatchLocally(job.NewServer(core).Dispatch)
+
 	server, err := server.New(core)
 	if err != nil {
 		core.Logger.Error("failed to get new server", zap.Error(err))
 		os.Exit(1)
 	}
 
+	go server.SweepPresences(context.Background())
+
+	if cfg.DebugAddr != "" {
+		go func() {
+			if err := http.ListenAndServe(cfg.DebugAddr, server.DebugHandler()); err != nil {
+				core.Logger.Error("debug server error", zap.Error(err))
+			}
+		}()
+	}
+
 	core.Logger.Info("server running on port", zap.Any("config", cfg))
 	if err := http.ListenAndServe(fmt.Sprintf(":%d", cfg.Port), server.Handler()); err != nil {
 		core.Logger.Error("server error", zap.Error(err))
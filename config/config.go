@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/kelseyhightower/envconfig"
 	"github.com/pkg/errors"
@@ -11,22 +12,113 @@ import (
 
 const AppName = "slink"
 
+const (
+	DatabaseFirestore = "firestore"
+	DatabaseMemory    = "memory"
+	DatabaseSQLite    = "sqlite"
+	DatabasePostgres  = "postgres"
+)
+
+const (
+	BroadcastMemory = "memory"
+	BroadcastPubSub = "pubsub"
+	BroadcastTCP    = "tcp"
+)
+
+const (
+	BlobStoreLocal = "local"
+	BlobStoreGCS   = "gcs"
+)
+
+const (
+	SearchAlgolia = "algolia"
+	SearchDB      = "db"
+)
+
 type Config struct {
-	Environment   string `envconfig:"ENVIRONMENT" required:"true" json:"environment"`
-	ProjectID     string `envconfig:"PROJECT_ID" required:"true" json:"project_id"`
-	Port          int    `envconfig:"PORT" required:"true" json:"port"`
-	Secret        string `envconfig:"SECRET" required:"true" json:"-"`
-	AlgoliaAppID  string `envconfig:"ALGOLIA_APP_ID" required:"true" json:"-"`
-	AlgoliaAPIKey string `envconfig:"ALGOLIA_API_KEY" required:"true" json:"-"`
-	AsyncTopic    string `envconfig:"ASYNC_TOPIC" json:"async_topic"`
+	Environment string `envconfig:"ENVIRONMENT" required:"true" json:"environment"`
+	Port        int    `envconfig:"PORT" required:"true" json:"port"`
+	Secret      string `envconfig:"SECRET" required:"true" json:"-"`
+	AsyncTopic  string `envconfig:"ASYNC_TOPIC" json:"async_topic"`
+	Database    string `envconfig:"DATABASE" default:"firestore" json:"database"`
+	DatabaseURL string `envconfig:"DATABASE_URL" json:"-"`
+
+	// ProjectID is the Google Cloud project, required only by Firestore and Pub/Sub: the firestore database,
+	// pubsub broadcast, and jobs outside local environments.
+	ProjectID string `envconfig:"PROJECT_ID" json:"project_id"`
+
+	// Search is how users and channels are searched: algolia, which needs the Algolia credentials, or db for the
+	// store's own search. Empty picks Algolia when hosted and the store otherwise.
+	Search        string `envconfig:"SEARCH" json:"search"`
+	AlgoliaAppID  string `envconfig:"ALGOLIA_APP_ID" json:"-"`
+	AlgoliaAPIKey string `envconfig:"ALGOLIA_API_KEY" json:"-"`
+
+	// Broadcast is how instances tell each other about realtime events: memory for a single instance, pubsub over
+	// BroadcastTopic, or tcp over BroadcastAddr to run several instances on one machine.
+	Broadcast      string `envconfig:"BROADCAST" default:"memory" json:"broadcast"`
+	BroadcastTopic string `envconfig:"BROADCAST_TOPIC" default:"broadcast" json:"broadcast_topic"`
+	BroadcastAddr  string `envconfig:"BROADCAST_ADDR" default:"127.0.0.1:7070" json:"broadcast_addr"`
+
+	// BlobStore is where uploaded files are kept: local, in BlobDir, or gcs, in BlobBucket.
+	BlobStore  string `envconfig:"BLOB_STORE" default:"local" json:"blob_store"`
+	BlobDir    string `envconfig:"BLOB_DIR" default:"blobs" json:"blob_dir"`
+	BlobBucket string `envconfig:"BLOB_BUCKET" json:"blob_bucket"`
+
+	// Sockets are pinged every SocketPingInterval and reaped if they don't answer within SocketPongTimeout, so the
+	// interval has to be the shorter of the two. A write that takes longer than SocketWriteTimeout, including
+	// waiting on a full queue of SocketQueueSize frames, reaps the socket too.
+	SocketPingInterval time.Duration `envconfig:"SOCKET_PING_INTERVAL" default:"25s" json:"socket_ping_interval"`
+	SocketPongTimeout  time.Duration `envconfig:"SOCKET_PONG_TIMEOUT" default:"60s" json:"socket_pong_timeout"`
+	SocketWriteTimeout time.Duration `envconfig:"SOCKET_WRITE_TIMEOUT" default:"10s" json:"socket_write_timeout"`
+	SocketQueueSize    int           `envconfig:"SOCKET_QUEUE_SIZE" default:"64" json:"socket_queue_size"`
+
+	// DebugAddr is where expvar's metrics are served, apart from the app so they're never public. Empty turns them
+	// off.
+	DebugAddr string `envconfig:"DEBUG_ADDR" default:"127.0.0.1:6060" json:"debug_addr"`
 }
 
+// The socket settings' defaults, for configs that aren't read from the environment. They match the envconfig tags.
+const (
+	DefaultSocketPingInterval = 25 * time.Second
+	DefaultSocketPongTimeout  = 60 * time.Second
+	DefaultSocketWriteTimeout = 10 * time.Second
+	DefaultSocketQueueSize    = 64
+)
+
 func New() (*Config, error) {
 	var cfg Config
 	if err := envconfig.Process(AppName, &cfg); err != nil {
 		return nil, errors.Wrap(err, "failed to process config")
 	}
 
+	if cfg.UsesGoogleCloud() && cfg.ProjectID == "" {
+		return nil, errors.New("PROJECT_ID is required for firestore and pubsub")
+	}
+
+	switch cfg.SearchBackend() {
+	case SearchAlgolia:
+		if cfg.AlgoliaAppID == "" || cfg.AlgoliaAPIKey == "" {
+			return nil, errors.New("ALGOLIA_APP_ID and ALGOLIA_API_KEY are required for algolia search")
+		}
+
+	case SearchDB:
+
+	default:
+		return nil, fmt.Errorf("unknown search %q", cfg.Search)
+	}
+
+	if cfg.SocketPingInterval <= 0 || cfg.SocketPongTimeout <= 0 || cfg.SocketWriteTimeout <= 0 {
+		return nil, errors.New("socket intervals and timeouts must be positive")
+	}
+
+	if cfg.SocketQueueSize <= 0 {
+		return nil, errors.New("socket queue size must be positive")
+	}
+
+	if cfg.SocketPingInterval >= cfg.SocketPongTimeout {
+		return nil, errors.New("socket ping interval must be shorter than the pong timeout")
+	}
+
 	return &cfg, nil
 }
 
@@ -50,6 +142,25 @@ func (c *Config) IsHosted() bool {
 	return c.IsStaging() || c.IsProduction()
 }
 
+// UsesGoogleCloud reports whether a backend that lives in the Google Cloud project is selected: Firestore, or
+// Pub/Sub for broadcast or, outside local environments, jobs.
+func (c *Config) UsesGoogleCloud() bool {
+	return c.Database == DatabaseFirestore || c.Broadcast == BroadcastPubSub || !c.IsLocal()
+}
+
+// SearchBackend is the search Search picks.
+func (c *Config) SearchBackend() string {
+	if c.Search != "" {
+		return c.Search
+	}
+
+	if c.IsHosted() {
+		return SearchAlgolia
+	}
+
+	return SearchDB
+}
+
 func (c *Config) Collection(name string) string {
 	return fmt.Sprintf("%s.%s", c.Environment, name)
 }
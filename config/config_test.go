@@ -0,0 +1,58 @@
+package config
+
+import "testing"
+
+func TestNew(t *testing.T) {
+	local := map[string]string{"ENVIRONMENT": "development", "PORT": "3000", "SECRET": "secret", "DATABASE": DatabaseSQLite}
+	hosted := map[string]string{"ENVIRONMENT": "production", "PORT": "3000", "SECRET": "secret", "DATABASE": DatabasePostgres, "PROJECT_ID": "slink"}
+	algolia := map[string]string{"ALGOLIA_APP_ID": "app", "ALGOLIA_API_KEY": "key"}
+
+	tests := []struct {
+		name       string
+		env        []map[string]string
+		wantSearch string
+		wantErr    bool
+	}{
+		{name: "local", env: []map[string]string{local}, wantSearch: SearchDB},
+		{name: "local with algolia", env: []map[string]string{local, algolia, {"SEARCH": SearchAlgolia}}, wantSearch: SearchAlgolia},
+		{name: "local algolia without credentials", env: []map[string]string{local, {"SEARCH": SearchAlgolia}}, wantErr: true},
+		{name: "local firestore without project", env: []map[string]string{local, {"DATABASE": DatabaseFirestore}}, wantErr: true},
+		{name: "local pubsub without project", env: []map[string]string{local, {"BROADCAST": BroadcastPubSub}}, wantErr: true},
+		{name: "local firestore", env: []map[string]string{local, {"DATABASE": DatabaseFirestore, "PROJECT_ID": "slink"}}, wantSearch: SearchDB},
+		{name: "hosted", env: []map[string]string{hosted, algolia}, wantSearch: SearchAlgolia},
+		{name: "hosted without algolia", env: []map[string]string{hosted}, wantErr: true},
+		{name: "hosted searching the store", env: []map[string]string{hosted, {"SEARCH": SearchDB}}, wantSearch: SearchDB},
+		{name: "hosted without project", env: []map[string]string{hosted, algolia, {"PROJECT_ID": ""}}, wantErr: true},
+		{name: "unknown search", env: []map[string]string{local, {"SEARCH": "grep"}}, wantErr: true},
+		{name: "no ping interval", env: []map[string]string{local, {"SOCKET_PING_INTERVAL": "0s"}}, wantErr: true},
+		{name: "no queue", env: []map[string]string{local, {"SOCKET_QUEUE_SIZE": "0"}}, wantErr: true},
+		{name: "ping after pong timeout", env: []map[string]string{local, {"SOCKET_PING_INTERVAL": "90s"}}, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, env := range test.env {
+				for key, value := range env {
+					t.Setenv(key, value)
+				}
+			}
+
+			cfg, err := New()
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("got %+v, want an error", cfg)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := cfg.SearchBackend(); got != test.wantSearch {
+				t.Errorf("got search %q, want %q", got, test.wantSearch)
+			}
+		})
+	}
+}
@@ -2,6 +2,8 @@ package core
 
 import (
 	"github.com/broothie/slink.chat/async"
+	"github.com/broothie/slink.chat/blob"
+	"github.com/broothie/slink.chat/bus"
 	"github.com/broothie/slink.chat/config"
 	"github.com/broothie/slink.chat/db"
 	"github.com/broothie/slink.chat/search"
@@ -16,6 +18,8 @@ type Core struct {
 	DB     *db.DB
 	Search search.Search
 	Async  *async.Async
+	Bus    bus.Bus
+	Blobs  blob.Store
 }
 
 func New(cfg *config.Config) (Core, error) {
@@ -42,9 +46,9 @@ func New(cfg *config.Config) (Core, error) {
 		return Core{}, errors.Wrap(err, "failed to create new db")
 	}
 
-	var src search.Search = search.NewAlgolia(cfg)
-	if cfg.IsLocal() {
-		src = search.NewDB(db)
+	src, err := search.New(cfg, db)
+	if err != nil {
+		return Core{}, errors.Wrap(err, "failed to create search")
 	}
 
 	async, err := async.New(cfg)
@@ -52,11 +56,25 @@ func New(cfg *config.Config) (Core, error) {
 		return Core{}, errors.Wrap(err, "failed to create async")
 	}
 
+	bus, err := bus.New(cfg, logger, async.PubSub())
+	if err != nil {
+		return Core{}, errors.Wrap(err, "failed to create bus")
+	}
+
+	db.Broadcast(bus, logger)
+
+	blobs, err := blob.New(cfg)
+	if err != nil {
+		return Core{}, errors.Wrap(err, "failed to create blob store")
+	}
+
 	return Core{
 		Config: cfg,
 		Logger: logger,
 		DB:     db,
 		Search: src,
 		Async:  async,
+		Bus:    bus,
+		Blobs:  blobs,
 	}, err
 }
@@ -0,0 +1,81 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/broothie/slink.chat/bus"
+	"go.uber.org/zap"
+)
+
+// commitsTopic carries the refs each commit wrote, for instances sharing a database to tell their listeners about.
+const commitsTopic = "db.commits"
+
+// broadcaster is a Store whose listeners only see writes made in process.
+type broadcaster interface {
+	broadcast(b bus.Bus, logger *zap.Logger)
+}
+
+// Broadcast shares writes with other instances over b, so that listeners see every instance's writes and not just
+// their own. Only SQL stores need this: Firestore has a change feed of its own, and memory stores aren't shared.
+func (db *DB) Broadcast(b bus.Bus, logger *zap.Logger) {
+	if store, ok := db.Store.(broadcaster); ok {
+		store.broadcast(b, logger)
+	}
+}
+
+func (s *SQL) broadcast(b bus.Bus, logger *zap.Logger) {
+	s.bus = b
+	s.logger = logger.With(zap.String("at", "broadcast"))
+	b.Subscribe(commitsTopic, s.observe)
+}
+
+// announce tells other instances which documents a commit wrote. It doesn't hold up the commit.
+func (s *SQL) announce(staged map[DocumentRef]map[string]any) {
+	if s.bus == nil {
+		return
+	}
+
+	refs := make([]DocumentRef, 0, len(staged))
+	for ref := range staged {
+		refs = append(refs, ref)
+	}
+
+	data, err := json.Marshal(refs)
+	if err != nil {
+		s.logger.Error("failed to marshal commit", zap.Error(err))
+		return
+	}
+
+	go func() {
+		if err := s.bus.Publish(context.Background(), commitsTopic, data); err != nil {
+			s.logger.Error("failed to announce commit", zap.Error(err))
+		}
+	}()
+}
+
+// observe hands another instance's commit to listeners. Documents are read back rather than sent, so commits
+// arriving late or out of order still leave listeners with the latest data.
+func (s *SQL) observe(data []byte) {
+	var refs []DocumentRef
+	if err := json.Unmarshal(data, &refs); err != nil {
+		s.logger.Error("failed to unmarshal commit", zap.Error(err))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	staged := make(map[DocumentRef]map[string]any, len(refs))
+	for _, ref := range refs {
+		data, _, err := s.read(context.Background(), s.db, ref, false)
+		if err != nil {
+			s.logger.Error("failed to read committed document", zap.Error(err), zap.String("collection", ref.Collection), zap.String("id", ref.ID))
+			continue
+		}
+
+		staged[ref] = data
+	}
+
+	s.listeners.publish(staged)
+}
@@ -0,0 +1,323 @@
+package db
+
+import (
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// encode converts a model into the map representation used by the in-process stores, following the same
+// `firestore` struct tags the Firestore client uses. Every map and slice in the result is freshly allocated.
+func encode(data any) (map[string]any, error) {
+	fields, ok := encodeValue(data).(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("cannot encode %T as a document", data)
+	}
+
+	return fields, nil
+}
+
+func encodeValue(value any) any {
+	if value == nil {
+		return nil
+	}
+
+	return encodeReflect(reflect.ValueOf(value))
+}
+
+func encodeReflect(value reflect.Value) any {
+	switch value.Kind() {
+	case reflect.Invalid:
+		return nil
+
+	case reflect.Pointer, reflect.Interface:
+		if value.IsNil() {
+			return nil
+		}
+
+		return encodeReflect(value.Elem())
+
+	case reflect.Bool:
+		return value.Bool()
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return value.Int()
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(value.Uint())
+
+	case reflect.Float32, reflect.Float64:
+		return value.Float()
+
+	case reflect.String:
+		return value.String()
+
+	case reflect.Slice, reflect.Array:
+		if value.Kind() == reflect.Slice && value.IsNil() {
+			return nil
+		}
+
+		if value.Type().Elem().Kind() == reflect.Uint8 {
+			return append([]byte{}, value.Bytes()...)
+		}
+
+		elems := make([]any, value.Len())
+		for i := range elems {
+			elems[i] = encodeReflect(value.Index(i))
+		}
+
+		return elems
+
+	case reflect.Map:
+		if value.IsNil() {
+			return nil
+		}
+
+		fields := make(map[string]any, value.Len())
+		iter := value.MapRange()
+		for iter.Next() {
+			fields[fmt.Sprint(iter.Key().Interface())] = encodeReflect(iter.Value())
+		}
+
+		return fields
+
+	case reflect.Struct:
+		if value.Type() == timeType {
+			return value.Interface().(time.Time)
+		}
+
+		fields := make(map[string]any)
+		for i := 0; i < value.NumField(); i++ {
+			if embedded(value.Type().Field(i)) {
+				embeddedFields, _ := encodeReflect(value.Field(i)).(map[string]any)
+				for name, field := range embeddedFields {
+					fields[name] = field
+				}
+
+				continue
+			}
+
+			name, omitEmpty, ok := fieldName(value.Type().Field(i))
+			if !ok {
+				continue
+			}
+
+			field := value.Field(i)
+			if omitEmpty && field.IsZero() {
+				continue
+			}
+
+			fields[name] = encodeReflect(field)
+		}
+
+		return fields
+	}
+
+	return value.Interface()
+}
+
+// decode reads an encoded value into the model pointed to by dst.
+func decode(src any, dst any) error {
+	value := reflect.ValueOf(dst)
+	if value.Kind() != reflect.Pointer || value.IsNil() {
+		return fmt.Errorf("cannot decode into non-pointer %T", dst)
+	}
+
+	return decodeReflect(src, value.Elem())
+}
+
+func decodeReflect(src any, dst reflect.Value) error {
+	if src == nil {
+		dst.Set(reflect.Zero(dst.Type()))
+		return nil
+	}
+
+	switch dst.Kind() {
+	case reflect.Pointer:
+		elem := reflect.New(dst.Type().Elem())
+		if err := decodeReflect(src, elem.Elem()); err != nil {
+			return err
+		}
+
+		dst.Set(elem)
+		return nil
+
+	case reflect.Interface:
+		dst.Set(reflect.ValueOf(src))
+		return nil
+
+	case reflect.Bool:
+		if src, ok := src.(bool); ok {
+			dst.SetBool(src)
+			return nil
+		}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if number, ok := toFloat(src); ok {
+			dst.SetInt(int64(number))
+			return nil
+		}
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if number, ok := toFloat(src); ok {
+			dst.SetUint(uint64(number))
+			return nil
+		}
+
+	case reflect.Float32, reflect.Float64:
+		if number, ok := toFloat(src); ok {
+			dst.SetFloat(number)
+			return nil
+		}
+
+	case reflect.String:
+		if src, ok := src.(string); ok {
+			dst.SetString(src)
+			return nil
+		}
+
+	case reflect.Slice:
+		if dst.Type().Elem().Kind() == reflect.Uint8 {
+			switch src := src.(type) {
+			case []byte:
+				dst.SetBytes(append([]byte{}, src...))
+				return nil
+
+			case string:
+				bytes, err := base64.StdEncoding.DecodeString(src)
+				if err != nil {
+					return errors.Wrap(err, "failed to decode bytes")
+				}
+
+				dst.SetBytes(bytes)
+				return nil
+			}
+
+			break
+		}
+
+		elems, ok := src.([]any)
+		if !ok {
+			break
+		}
+
+		slice := reflect.MakeSlice(dst.Type(), len(elems), len(elems))
+		for i, elem := range elems {
+			if err := decodeReflect(elem, slice.Index(i)); err != nil {
+				return err
+			}
+		}
+
+		dst.Set(slice)
+		return nil
+
+	case reflect.Map:
+		fields, ok := src.(map[string]any)
+		if !ok || dst.Type().Key().Kind() != reflect.String {
+			break
+		}
+
+		m := reflect.MakeMapWithSize(dst.Type(), len(fields))
+		for key, field := range fields {
+			elem := reflect.New(dst.Type().Elem()).Elem()
+			if err := decodeReflect(field, elem); err != nil {
+				return err
+			}
+
+			m.SetMapIndex(reflect.ValueOf(key).Convert(dst.Type().Key()), elem)
+		}
+
+		dst.Set(m)
+		return nil
+
+	case reflect.Struct:
+		if dst.Type() == timeType {
+			switch src := src.(type) {
+			case time.Time:
+				dst.Set(reflect.ValueOf(src))
+				return nil
+
+			case string:
+				t, err := time.Parse(time.RFC3339Nano, src)
+				if err != nil {
+					return errors.Wrap(err, "failed to parse time")
+				}
+
+				dst.Set(reflect.ValueOf(t))
+				return nil
+			}
+
+			break
+		}
+
+		fields, ok := src.(map[string]any)
+		if !ok {
+			break
+		}
+
+		for i := 0; i < dst.NumField(); i++ {
+			if embedded(dst.Type().Field(i)) {
+				if err := decodeReflect(fields, dst.Field(i)); err != nil {
+					return err
+				}
+
+				continue
+			}
+
+			name, _, ok := fieldName(dst.Type().Field(i))
+			if !ok {
+				continue
+			}
+
+			field, ok := fields[name]
+			if !ok {
+				continue
+			}
+
+			if err := decodeReflect(field, dst.Field(i)); err != nil {
+				return errors.Wrapf(err, "failed to decode field %q", name)
+			}
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("cannot decode %T into %s", src, dst.Type())
+}
+
+func fieldName(field reflect.StructField) (name string, omitEmpty bool, ok bool) {
+	if !field.IsExported() {
+		return "", false, false
+	}
+
+	tag, hasTag := field.Tag.Lookup("firestore")
+	if tag == "-" {
+		return "", false, false
+	}
+
+	parts := strings.Split(tag, ",")
+	name = parts[0]
+	if !hasTag || name == "" {
+		name = field.Name
+	}
+
+	for _, option := range parts[1:] {
+		if option == "omitempty" {
+			omitEmpty = true
+		}
+	}
+
+	return name, omitEmpty, true
+}
+
+// embedded reports whether field is an untagged embedded struct, whose fields are promoted into its parent's.
+func embedded(field reflect.StructField) bool {
+	_, hasTag := field.Tag.Lookup("firestore")
+	return field.Anonymous && !hasTag && field.Type.Kind() == reflect.Struct && field.Type != timeType
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 
-	"cloud.google.com/go/firestore"
 	"github.com/broothie/slink.chat/config"
 	"github.com/broothie/slink.chat/model"
 	"github.com/gertd/go-pluralize"
@@ -12,19 +11,48 @@ import (
 )
 
 type DB struct {
-	*firestore.Client
+	Store
 	cfg *config.Config
 }
 
 func New(cfg *config.Config) (*DB, error) {
-	client, err := firestore.NewClient(context.Background(), cfg.ProjectID)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to create new firestore client")
+	var store Store
+	switch cfg.Database {
+	case config.DatabaseFirestore:
+		firestore, err := NewFirestore(cfg)
+		if err != nil {
+			return nil, err
+		}
+
+		store = firestore
+
+	case config.DatabaseMemory:
+		db := &DB{Store: NewMemory(), cfg: cfg}
+		if _, _, err := Seed(context.Background(), db); err != nil {
+			return nil, errors.Wrap(err, "failed to seed memory db")
+		}
+
+		return db, nil
+
+	case config.DatabaseSQLite, config.DatabasePostgres:
+		sql, err := NewSQL(cfg)
+		if err != nil {
+			return nil, err
+		}
+
+		store = sql
+
+	default:
+		return nil, fmt.Errorf("unknown database %q", cfg.Database)
 	}
 
-	return &DB{Client: client, cfg: cfg}, nil
+	return &DB{Store: store, cfg: cfg}, nil
+}
+
+func (db *DB) CollectionFor(model model.Type) CollectionRef {
+	return db.Collection(pluralize.NewClient().Plural(model.Type().String()))
 }
 
-func (db *DB) CollectionFor(model model.Type) *firestore.CollectionRef {
-	return db.Client.Collection(fmt.Sprintf("%s.%s", db.cfg.Environment, pluralize.NewClient().Plural(model.Type().String())))
+func (db *DB) Collection(name string) CollectionRef {
+	return CollectionRef{Query: Query{store: db.Store, collection: db.cfg.Collection(name)}}
 }
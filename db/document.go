@@ -0,0 +1,281 @@
+package db
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+)
+
+// document is the in-process representation of a stored document, as produced by encode.
+type document struct {
+	id   string
+	data map[string]any
+}
+
+// docSnapshot is the Snapshot of a document held in process.
+type docSnapshot struct {
+	id   string
+	data map[string]any
+}
+
+func (s docSnapshot) ID() string {
+	return s.id
+}
+
+func (s docSnapshot) DataTo(dst any) error {
+	return decode(s.data, dst)
+}
+
+type writeKind int
+
+const (
+	writeCreate writeKind = iota
+	writeSet
+	writeUpdate
+	writeDelete
+
+	// writeCheck asserts that a document still holds the data a transaction read, writing nothing
+	writeCheck
+)
+
+type pendingWrite struct {
+	kind    writeKind
+	ref     DocumentRef
+	data    any
+	updates []Update
+}
+
+// batch is the Batch of stores that stage writes themselves before committing them.
+type batch struct {
+	writes []pendingWrite
+	commit func(context.Context, []pendingWrite) error
+}
+
+func (b *batch) Create(ref DocumentRef, data any) {
+	b.writes = append(b.writes, pendingWrite{kind: writeCreate, ref: ref, data: data})
+}
+
+func (b *batch) Set(ref DocumentRef, data any) {
+	b.writes = append(b.writes, pendingWrite{kind: writeSet, ref: ref, data: data})
+}
+
+func (b *batch) Update(ref DocumentRef, updates []Update) {
+	b.writes = append(b.writes, pendingWrite{kind: writeUpdate, ref: ref, updates: updates})
+}
+
+func (b *batch) Delete(ref DocumentRef) {
+	b.writes = append(b.writes, pendingWrite{kind: writeDelete, ref: ref})
+}
+
+func (b *batch) Commit(ctx context.Context) error {
+	return b.commit(ctx, b.writes)
+}
+
+// stage computes the documents a set of writes leaves behind, keyed by ref. Deleted documents are staged as nil.
+// current reads a document as it was before the writes. Conflicts return the bare AlreadyExists and NotFound
+// sentinels, so callers can compare against them as they do with Firestore, and failed checks return
+// errTransactionConflict.
+func stage(writes []pendingWrite, current func(DocumentRef) (map[string]any, bool, error)) (map[DocumentRef]map[string]any, error) {
+	staged := make(map[DocumentRef]map[string]any)
+	for _, write := range writes {
+		ref := DocumentRef{Collection: write.ref.Collection, ID: write.ref.ID}
+
+		existing, isStaged := staged[ref]
+		exists := existing != nil
+		if !isStaged {
+			var err error
+			if existing, exists, err = current(ref); err != nil {
+				return nil, err
+			}
+		}
+
+		switch write.kind {
+		case writeCreate, writeSet:
+			if write.kind == writeCreate && exists {
+				return nil, AlreadyExists
+			}
+
+			data, err := encode(write.data)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to encode %s/%s", ref.Collection, ref.ID)
+			}
+
+			staged[ref] = data
+
+		case writeUpdate:
+			if !exists {
+				return nil, NotFound
+			}
+
+			data := clone(existing).(map[string]any)
+			for _, update := range write.updates {
+				if err := applyUpdate(data, update); err != nil {
+					return nil, errors.Wrapf(err, "failed to update %s/%s", ref.Collection, ref.ID)
+				}
+			}
+
+			staged[ref] = data
+
+		case writeDelete:
+			staged[ref] = nil
+
+		case writeCheck:
+			expected, _ := write.data.(map[string]any)
+			if exists != (expected != nil) || (exists && !equal(existing, expected)) {
+				return nil, errTransactionConflict
+			}
+		}
+	}
+
+	return staged, nil
+}
+
+// maxTransactionAttempts is how many times a transaction is tried before giving up, the same as Firestore.
+const maxTransactionAttempts = 5
+
+// retryBackoff is the most a first retry of a conflicted commit waits. Each retry after waits up to twice as long
+// as the last, at random, so that commits which conflicted with each other don't just conflict again.
+const retryBackoff = 5 * time.Millisecond
+
+var errTransactionConflict = errors.New("transaction conflict")
+
+// backoff waits before another attempt at a conflicted commit, returning early if ctx is done.
+func backoff(ctx context.Context, attempt int) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(time.Duration(rand.Int63n(int64(retryBackoff << (attempt - 1))))):
+		return nil
+	}
+}
+
+// transaction is the Transaction of stores that stage writes themselves. It remembers what it read, so commit can
+// check that nothing changed underneath it.
+type transaction struct {
+	batch
+	ctx   context.Context
+	read  func(context.Context, DocumentRef) (map[string]any, bool, error)
+	reads map[DocumentRef]map[string]any
+}
+
+func (t *transaction) Get(ref DocumentRef) (Snapshot, error) {
+	key := DocumentRef{Collection: ref.Collection, ID: ref.ID}
+	data, exists, err := t.read(t.ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := t.reads[key]; !ok {
+		t.reads[key] = data
+	}
+
+	if !exists {
+		return nil, NotFound
+	}
+
+	return docSnapshot{id: ref.ID, data: data}, nil
+}
+
+// runTransaction runs f until its writes commit without conflict. read gets a document as currently stored, and
+// commit applies writes atomically.
+func runTransaction(
+	ctx context.Context,
+	read func(context.Context, DocumentRef) (map[string]any, bool, error),
+	commit func(context.Context, []pendingWrite) error,
+	f func(context.Context, Transaction) error,
+) error {
+	for attempt := 0; attempt < maxTransactionAttempts; attempt++ {
+		if attempt > 0 {
+			if err := backoff(ctx, attempt); err != nil {
+				return err
+			}
+		}
+
+		tx := &transaction{ctx: ctx, read: read, reads: make(map[DocumentRef]map[string]any)}
+		if err := f(ctx, tx); err != nil {
+			return err
+		}
+
+		writes := make([]pendingWrite, 0, len(tx.reads)+len(tx.writes))
+		for ref, data := range tx.reads {
+			writes = append(writes, pendingWrite{kind: writeCheck, ref: ref, data: data})
+		}
+
+		// Checks go in a fixed order, so that stores locking what they check always lock in the same order
+		sort.Slice(writes, func(i, j int) bool {
+			a, b := writes[i].ref, writes[j].ref
+			return a.Collection < b.Collection || (a.Collection == b.Collection && a.ID < b.ID)
+		})
+
+		if err := commit(ctx, append(writes, tx.writes...)); err != errTransactionConflict {
+			return err
+		}
+	}
+
+	return errors.Wrapf(errTransactionConflict, "failed after %d attempts", maxTransactionAttempts)
+}
+
+func applyUpdate(data map[string]any, update Update) error {
+	keys := strings.Split(update.Path, ".")
+	parent := data
+	for _, key := range keys[:len(keys)-1] {
+		child, ok := parent[key].(map[string]any)
+		if !ok {
+			child = make(map[string]any)
+			parent[key] = child
+		}
+
+		parent = child
+	}
+
+	key := keys[len(keys)-1]
+	switch value := update.Value.(type) {
+	case arrayUnion:
+		elems, _ := parent[key].([]any)
+		for _, elem := range value {
+			elem = encodeValue(elem)
+			if !lo.ContainsBy(elems, func(existing any) bool { return equal(existing, elem) }) {
+				elems = append(elems, elem)
+			}
+		}
+
+		parent[key] = elems
+
+	case arrayRemove:
+		elems, _ := parent[key].([]any)
+		parent[key] = lo.Reject(elems, func(existing any, _ int) bool {
+			return lo.ContainsBy([]any(value), func(elem any) bool { return equal(existing, encodeValue(elem)) })
+		})
+
+	default:
+		parent[key] = encodeValue(update.Value)
+	}
+
+	return nil
+}
+
+// clone deep copies an encoded value so that stored documents are never mutated in place.
+func clone(value any) any {
+	switch value := value.(type) {
+	case map[string]any:
+		fields := make(map[string]any, len(value))
+		for key, field := range value {
+			fields[key] = clone(field)
+		}
+
+		return fields
+
+	case []any:
+		return lo.Map(value, func(elem any, _ int) any { return clone(elem) })
+
+	case []byte:
+		return append([]byte{}, value...)
+	}
+
+	return value
+}
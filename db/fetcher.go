@@ -3,20 +3,16 @@ package db
 import (
 	"context"
 
-	"cloud.google.com/go/firestore"
 	"github.com/broothie/slink.chat/model"
 	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
 	"github.com/pkg/errors"
 	"github.com/samber/lo"
 	"go.uber.org/zap"
-	"google.golang.org/api/iterator"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
 )
 
 var NotFound = errors.New("not found")
 
-type QueryFunc func(query firestore.Query) firestore.Query
+type QueryFunc func(query Query) Query
 
 type Fetcher[M model.Typer] struct {
 	db *DB
@@ -29,7 +25,26 @@ func NewFetcher[M model.Typer](db *DB) Fetcher[M] {
 func (f Fetcher[Model]) Fetch(ctx context.Context, id string) (Model, error) {
 	snapshot, err := f.db.CollectionFor(f.Type()).Doc(id).Get(ctx)
 	if err != nil {
-		if status.Code(err) == codes.NotFound {
+		if err == NotFound {
+			return f.Zero(), NotFound
+		}
+
+		return f.Zero(), errors.Wrapf(err, "failed to get %q", f.Type())
+	}
+
+	model := f.Zero()
+	if err := snapshot.DataTo(&model); err != nil {
+		return f.Zero(), errors.Wrapf(err, "failed to read %q data", f.Type())
+	}
+
+	return model, nil
+}
+
+// FetchIn is Fetch as part of tx.
+func (f Fetcher[Model]) FetchIn(tx Transaction, id string) (Model, error) {
+	snapshot, err := tx.Get(f.db.CollectionFor(f.Type()).Doc(id))
+	if err != nil {
+		if err == NotFound {
 			return f.Zero(), NotFound
 		}
 
@@ -46,8 +61,11 @@ func (f Fetcher[Model]) Fetch(ctx context.Context, id string) (Model, error) {
 
 func (f Fetcher[Model]) FetchMany(ctx context.Context, ids ...string) ([]Model, error) {
 	logger := ctxzap.Extract(ctx)
+	if len(ids) == 0 {
+		return []Model{}, nil
+	}
 
-	refs := lo.Map(ids, func(id string, _ int) *firestore.DocumentRef { return f.db.CollectionFor(f.Type()).Doc(id) })
+	refs := lo.Map(ids, func(id string, _ int) DocumentRef { return f.db.CollectionFor(f.Type()).Doc(id) })
 	snapshots, err := f.db.GetAll(ctx, refs)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to fetch snapshots for %q", f.Type())
@@ -68,18 +86,16 @@ func (f Fetcher[Model]) FetchMany(ctx context.Context, ids ...string) ([]Model,
 }
 
 func (f Fetcher[Model]) FetchFirst(ctx context.Context, queryFunc QueryFunc) (Model, error) {
-	docs := queryFunc(f.db.CollectionFor(f.Type()).Query).Documents(ctx)
-	defer docs.Stop()
-
-	snapshot, err := docs.Next()
+	snapshots, err := queryFunc(f.db.CollectionFor(f.Type()).Query).Limit(1).Documents(ctx)
 	if err != nil {
-		if err == iterator.Done {
-			return f.Zero(), NotFound
-		}
-
 		return f.Zero(), errors.Wrapf(err, "failed to fetch snapshot for %q", f.Type())
 	}
 
+	if len(snapshots) == 0 {
+		return f.Zero(), NotFound
+	}
+
+	snapshot := snapshots[0]
 	var model Model
 	if err := snapshot.DataTo(&model); err != nil {
 		return f.Zero(), errors.Wrapf(err, "failed to read snapshot for %q", f.Type())
@@ -91,10 +107,7 @@ func (f Fetcher[Model]) FetchFirst(ctx context.Context, queryFunc QueryFunc) (Mo
 func (f Fetcher[Model]) Query(ctx context.Context, queryFunc QueryFunc) ([]Model, error) {
 	logger := ctxzap.Extract(ctx)
 
-	docs := queryFunc(f.db.CollectionFor(f.Type()).Query).Documents(ctx)
-	defer docs.Stop()
-
-	snapshots, err := docs.GetAll()
+	snapshots, err := queryFunc(f.db.CollectionFor(f.Type()).Query).Documents(ctx)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to query snapshots for %q", f.Type())
 	}
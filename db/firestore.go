@@ -0,0 +1,255 @@
+package db
+
+import (
+	"context"
+
+	"cloud.google.com/go/firestore"
+	"github.com/broothie/slink.chat/config"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type Firestore struct {
+	client *firestore.Client
+}
+
+func NewFirestore(cfg *config.Config) (*Firestore, error) {
+	client, err := firestore.NewClient(context.Background(), cfg.ProjectID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create new firestore client")
+	}
+
+	return &Firestore{client: client}, nil
+}
+
+func (f *Firestore) Get(ctx context.Context, ref DocumentRef) (Snapshot, error) {
+	snapshot, err := f.doc(ref).Get(ctx)
+	if err != nil {
+		return nil, firestoreError(err)
+	}
+
+	return firestoreSnapshot{snapshot}, nil
+}
+
+func (f *Firestore) GetAll(ctx context.Context, refs []DocumentRef) ([]Snapshot, error) {
+	snapshots, err := f.client.GetAll(ctx, lo.Map(refs, func(ref DocumentRef, _ int) *firestore.DocumentRef { return f.doc(ref) }))
+	if err != nil {
+		return nil, firestoreError(err)
+	}
+
+	snapshots = lo.Filter(snapshots, func(snapshot *firestore.DocumentSnapshot, _ int) bool { return snapshot.Exists() })
+	return lo.Map(snapshots, func(snapshot *firestore.DocumentSnapshot, _ int) Snapshot { return firestoreSnapshot{snapshot} }), nil
+}
+
+func (f *Firestore) Query(ctx context.Context, query Query) ([]Snapshot, error) {
+	docs := f.query(query).Documents(ctx)
+	defer docs.Stop()
+
+	snapshots, err := docs.GetAll()
+	if err != nil {
+		return nil, firestoreError(err)
+	}
+
+	return lo.Map(snapshots, func(snapshot *firestore.DocumentSnapshot, _ int) Snapshot { return firestoreSnapshot{snapshot} }), nil
+}
+
+func (f *Firestore) Listen(ctx context.Context, query Query) Listener {
+	return firestoreListener{f.query(query).Snapshots(ctx)}
+}
+
+func (f *Firestore) Batch() Batch {
+	return &firestoreBatch{firestore: f, batch: f.client.Batch()}
+}
+
+func (f *Firestore) RunTransaction(ctx context.Context, fn func(ctx context.Context, tx Transaction) error) error {
+	return firestoreError(f.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		transaction := &firestoreTransaction{firestore: f, tx: tx}
+		if err := fn(ctx, transaction); err != nil {
+			return err
+		}
+
+		return transaction.err
+	}))
+}
+
+func (f *Firestore) Close() error {
+	return f.client.Close()
+}
+
+func (f *Firestore) doc(ref DocumentRef) *firestore.DocumentRef {
+	return f.client.Collection(ref.Collection).Doc(ref.ID)
+}
+
+func (f *Firestore) query(query Query) firestore.Query {
+	q := f.client.Collection(query.collection).Query
+	for _, filter := range query.filters {
+		q = q.Where(filter.path, filter.op, filter.value)
+	}
+
+	for _, order := range query.orders {
+		q = q.OrderBy(order.path, lo.Ternary(order.direction == Desc, firestore.Desc, firestore.Asc))
+	}
+
+	if query.startAfter != nil {
+		q = q.StartAfter(query.startAfter...)
+	}
+
+	if query.endBefore != nil {
+		q = q.EndBefore(query.endBefore...)
+	}
+
+	if query.limit > 0 {
+		if query.limitToLast {
+			q = q.LimitToLast(query.limit)
+		} else {
+			q = q.Limit(query.limit)
+		}
+	}
+
+	return q
+}
+
+type firestoreSnapshot struct {
+	*firestore.DocumentSnapshot
+}
+
+func (s firestoreSnapshot) ID() string {
+	return s.Ref.ID
+}
+
+type firestoreBatch struct {
+	firestore *Firestore
+	batch     *firestore.WriteBatch
+}
+
+func (b *firestoreBatch) Create(ref DocumentRef, data any) {
+	b.batch.Create(b.firestore.doc(ref), data)
+}
+
+func (b *firestoreBatch) Set(ref DocumentRef, data any) {
+	b.batch.Set(b.firestore.doc(ref), data)
+}
+
+func (b *firestoreBatch) Update(ref DocumentRef, updates []Update) {
+	b.batch.Update(b.firestore.doc(ref), lo.Map(updates, func(update Update, _ int) firestore.Update {
+		return firestore.Update{Path: update.Path, Value: firestoreValue(update.Value)}
+	}))
+}
+
+func (b *firestoreBatch) Delete(ref DocumentRef) {
+	b.batch.Delete(b.firestore.doc(ref))
+}
+
+func (b *firestoreBatch) Commit(ctx context.Context) error {
+	_, err := b.batch.Commit(ctx)
+	return firestoreError(err)
+}
+
+// firestoreTransaction holds on to the first write error, since Transaction's writes don't return one.
+type firestoreTransaction struct {
+	firestore *Firestore
+	tx        *firestore.Transaction
+	err       error
+}
+
+func (t *firestoreTransaction) Get(ref DocumentRef) (Snapshot, error) {
+	snapshot, err := t.tx.Get(t.firestore.doc(ref))
+	if err != nil {
+		return nil, firestoreError(err)
+	}
+
+	return firestoreSnapshot{snapshot}, nil
+}
+
+func (t *firestoreTransaction) Create(ref DocumentRef, data any) {
+	t.wrote(t.tx.Create(t.firestore.doc(ref), data))
+}
+
+func (t *firestoreTransaction) Set(ref DocumentRef, data any) {
+	t.wrote(t.tx.Set(t.firestore.doc(ref), data))
+}
+
+func (t *firestoreTransaction) Update(ref DocumentRef, updates []Update) {
+	t.wrote(t.tx.Update(t.firestore.doc(ref), lo.Map(updates, func(update Update, _ int) firestore.Update {
+		return firestore.Update{Path: update.Path, Value: firestoreValue(update.Value)}
+	})))
+}
+
+func (t *firestoreTransaction) Delete(ref DocumentRef) {
+	t.wrote(t.tx.Delete(t.firestore.doc(ref)))
+}
+
+func (t *firestoreTransaction) wrote(err error) {
+	if t.err == nil {
+		t.err = err
+	}
+}
+
+type firestoreListener struct {
+	snapshots *firestore.QuerySnapshotIterator
+}
+
+func (l firestoreListener) Next() ([]DocumentChange, error) {
+	snapshot, err := l.snapshots.Next()
+	if err != nil {
+		return nil, firestoreError(err)
+	}
+
+	if snapshot == nil {
+		return nil, nil
+	}
+
+	return lo.Map(snapshot.Changes, func(change firestore.DocumentChange, _ int) DocumentChange {
+		kind := DocumentModified
+		switch change.Kind {
+		case firestore.DocumentAdded:
+			kind = DocumentAdded
+		case firestore.DocumentRemoved:
+			kind = DocumentRemoved
+		}
+
+		return DocumentChange{Kind: kind, Doc: firestoreSnapshot{change.Doc}}
+	}), nil
+}
+
+func (l firestoreListener) Stop() {
+	l.snapshots.Stop()
+}
+
+func firestoreValue(value any) any {
+	switch value := value.(type) {
+	case arrayUnion:
+		return firestore.ArrayUnion(value...)
+	case arrayRemove:
+		return firestore.ArrayRemove(value...)
+	}
+
+	return value
+}
+
+// firestoreError maps Firestore errors onto the errors every Store returns.
+func firestoreError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	switch status.Code(err) {
+	case codes.NotFound:
+		return NotFound
+	case codes.AlreadyExists:
+		return AlreadyExists
+	case codes.Canceled:
+		return context.Canceled
+	case codes.DeadlineExceeded:
+		return context.DeadlineExceeded
+	}
+
+	if err == iterator.Done {
+		return context.Canceled
+	}
+
+	return err
+}
@@ -0,0 +1,156 @@
+package db
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// listeners fans committed writes out to in-process Listeners, for stores without a native change feed.
+type listeners struct {
+	mu  sync.Mutex
+	set map[*listener]struct{}
+}
+
+func newListeners() *listeners {
+	return &listeners{set: make(map[*listener]struct{})}
+}
+
+// add registers a listener whose first batch of changes is initial. Callers must make sure no write is
+// published between reading initial and calling add.
+func (ls *listeners) add(ctx context.Context, query Query, initial []document) Listener {
+	ctx, cancel := context.WithCancel(ctx)
+	l := &listener{
+		listeners: ls,
+		query:     query,
+		ctx:       ctx,
+		cancel:    cancel,
+		notify:    make(chan struct{}, 1),
+		matching:  make(map[string]map[string]any),
+	}
+
+	changes := make([]DocumentChange, 0, len(initial))
+	for _, doc := range initial {
+		l.matching[doc.id] = doc.data
+		changes = append(changes, DocumentChange{Kind: DocumentAdded, Doc: docSnapshot{id: doc.id, data: doc.data}})
+	}
+
+	l.push(changes)
+
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	ls.set[l] = struct{}{}
+	return l
+}
+
+// publish notifies listeners of committed writes. A nil document means it was deleted.
+func (ls *listeners) publish(staged map[DocumentRef]map[string]any) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	for l := range ls.set {
+		l.observe(staged)
+	}
+}
+
+func (ls *listeners) close() {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	for l := range ls.set {
+		l.cancel()
+	}
+}
+
+func (ls *listeners) remove(l *listener) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	delete(ls.set, l)
+}
+
+type listener struct {
+	listeners *listeners
+	query     Query
+	ctx       context.Context
+	cancel    context.CancelFunc
+	notify    chan struct{}
+	mu        sync.Mutex
+	pending   [][]DocumentChange
+	matching  map[string]map[string]any
+}
+
+func (l *listener) Next() ([]DocumentChange, error) {
+	for {
+		l.mu.Lock()
+		if len(l.pending) > 0 {
+			changes := l.pending[0]
+			l.pending = l.pending[1:]
+			l.mu.Unlock()
+			return changes, nil
+		}
+		l.mu.Unlock()
+
+		select {
+		case <-l.ctx.Done():
+			l.Stop()
+			return nil, l.ctx.Err()
+
+		case <-l.notify:
+		}
+	}
+}
+
+func (l *listener) Stop() {
+	l.cancel()
+	l.listeners.remove(l)
+}
+
+// observe computes the changes a commit made to the listener's result set, skipping documents it left as they were.
+// Limits are not applied to listeners.
+// Removed documents carry their last matching data, as they do in Firestore.
+func (l *listener) observe(staged map[DocumentRef]map[string]any) {
+	var changes []DocumentChange
+	for ref, data := range staged {
+		if ref.Collection != l.query.collection {
+			continue
+		}
+
+		previous, wasMatching := l.matching[ref.ID]
+		isMatching := data != nil && l.query.matches(document{id: ref.ID, data: data})
+		snapshot := docSnapshot{id: ref.ID, data: data}
+
+		switch {
+		case !wasMatching && isMatching:
+			changes = append(changes, DocumentChange{Kind: DocumentAdded, Doc: snapshot})
+		case wasMatching && isMatching && !reflect.DeepEqual(previous, data):
+			changes = append(changes, DocumentChange{Kind: DocumentModified, Doc: snapshot})
+		case wasMatching && !isMatching:
+			changes = append(changes, DocumentChange{Kind: DocumentRemoved, Doc: docSnapshot{id: ref.ID, data: previous}})
+		}
+
+		if isMatching {
+			l.matching[ref.ID] = data
+		} else {
+			delete(l.matching, ref.ID)
+		}
+	}
+
+	if len(changes) > 0 {
+		sort.Slice(changes, func(i, j int) bool { return changes[i].Doc.ID() < changes[j].Doc.ID() })
+		l.push(changes)
+	}
+}
+
+func (l *listener) push(changes []DocumentChange) {
+	l.mu.Lock()
+	l.pending = append(l.pending, changes)
+	l.mu.Unlock()
+
+	select {
+	case l.notify <- struct{}{}:
+	default:
+	}
+}
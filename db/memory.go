@@ -0,0 +1,125 @@
+package db
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/samber/lo"
+)
+
+// Memory is a Store that keeps every document in process. It is meant for local development and tests.
+type Memory struct {
+	mu          sync.RWMutex
+	collections map[string]map[string]map[string]any
+	listeners   *listeners
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		collections: make(map[string]map[string]map[string]any),
+		listeners:   newListeners(),
+	}
+}
+
+func (m *Memory) Get(_ context.Context, ref DocumentRef) (Snapshot, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	data, ok := m.collections[ref.Collection][ref.ID]
+	if !ok {
+		return nil, NotFound
+	}
+
+	return docSnapshot{id: ref.ID, data: data}, nil
+}
+
+func (m *Memory) GetAll(_ context.Context, refs []DocumentRef) ([]Snapshot, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	snapshots := make([]Snapshot, 0, len(refs))
+	for _, ref := range refs {
+		if data, ok := m.collections[ref.Collection][ref.ID]; ok {
+			snapshots = append(snapshots, docSnapshot{id: ref.ID, data: data})
+		}
+	}
+
+	return snapshots, nil
+}
+
+func (m *Memory) Query(_ context.Context, query Query) ([]Snapshot, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return lo.Map(query.apply(m.documents(query.collection)), func(doc document, _ int) Snapshot {
+		return docSnapshot{id: doc.id, data: doc.data}
+	}), nil
+}
+
+func (m *Memory) Listen(ctx context.Context, query Query) Listener {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.listeners.add(ctx, query, query.apply(m.documents(query.collection)))
+}
+
+func (m *Memory) Batch() Batch {
+	return &batch{commit: m.commit}
+}
+
+func (m *Memory) RunTransaction(ctx context.Context, f func(ctx context.Context, tx Transaction) error) error {
+	return runTransaction(ctx, m.read, m.commit, f)
+}
+
+func (m *Memory) Close() error {
+	m.listeners.close()
+	return nil
+}
+
+func (m *Memory) read(_ context.Context, ref DocumentRef) (map[string]any, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	data, ok := m.collections[ref.Collection][ref.ID]
+	return data, ok, nil
+}
+
+func (m *Memory) documents(collection string) []document {
+	docs := make([]document, 0, len(m.collections[collection]))
+	for id, data := range m.collections[collection] {
+		docs = append(docs, document{id: id, data: data})
+	}
+
+	sort.Slice(docs, func(i, j int) bool { return docs[i].id < docs[j].id })
+	return docs
+}
+
+// commit applies writes atomically and notifies listeners.
+func (m *Memory) commit(_ context.Context, writes []pendingWrite) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	staged, err := stage(writes, func(ref DocumentRef) (map[string]any, bool, error) {
+		data, ok := m.collections[ref.Collection][ref.ID]
+		return data, ok, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for ref, data := range staged {
+		if data == nil {
+			delete(m.collections[ref.Collection], ref.ID)
+		} else {
+			if m.collections[ref.Collection] == nil {
+				m.collections[ref.Collection] = make(map[string]map[string]any)
+			}
+
+			m.collections[ref.Collection][ref.ID] = data
+		}
+	}
+
+	m.listeners.publish(staged)
+	return nil
+}
@@ -0,0 +1,109 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/broothie/slink.chat/config"
+)
+
+func newMemoryTestDB() *DB {
+	return &DB{Store: NewMemory(), cfg: &config.Config{Environment: "test"}}
+}
+
+func TestMemory_Query(t *testing.T) {
+	testQuery(t, newMemoryTestDB())
+}
+
+func TestMemory_Transaction(t *testing.T) {
+	testTransaction(t, newMemoryTestDB())
+}
+
+func TestMemory_Batch(t *testing.T) {
+	testBatch(t, newMemoryTestDB())
+}
+
+func TestMemory_CreateMessage(t *testing.T) {
+	testCreateMessage(t, []*DB{newMemoryTestDB()})
+}
+
+// TestMemory_Check commits a transaction's checks directly, which must fail the whole commit if what was read has
+// changed since.
+func TestMemory_Check(t *testing.T) {
+	ctx := context.Background()
+	db := newMemoryTestDB()
+	seedWidgets(t, db)
+
+	memory := db.Store.(*Memory)
+	ref := func(id string) DocumentRef {
+		return DocumentRef{Collection: db.cfg.Collection("widgets"), ID: id}
+	}
+
+	read := func(id string) map[string]any {
+		data, _, err := memory.read(ctx, ref(id))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return data
+	}
+
+	changed := clone(read("a")).(map[string]any)
+	changed["rank"] = int64(100)
+
+	tests := []struct {
+		name  string
+		check pendingWrite
+		want  error
+	}{
+		{"unchanged", pendingWrite{kind: writeCheck, ref: ref("a"), data: read("a")}, nil},
+		{"still missing", pendingWrite{kind: writeCheck, ref: ref("missing")}, nil},
+		{"changed", pendingWrite{kind: writeCheck, ref: ref("a"), data: changed}, errTransactionConflict},
+		{"created since", pendingWrite{kind: writeCheck, ref: ref("a")}, errTransactionConflict},
+		{"deleted since", pendingWrite{kind: writeCheck, ref: ref("missing"), data: read("a")}, errTransactionConflict},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			write := pendingWrite{kind: writeUpdate, ref: ref("b"), updates: []Update{{Path: "name", Value: test.name}}}
+			if err := memory.commit(ctx, []pendingWrite{test.check, write}); err != test.want {
+				t.Fatalf("got %v, want %v", err, test.want)
+			}
+
+			written := fetchWidget(t, db, "b").Name == test.name
+			if written != (test.want == nil) {
+				t.Errorf("wrote %t, want writes only if the check passed", written)
+			}
+		})
+	}
+}
+
+// TestMemory_Snapshots checks that stored documents can't be changed through what was read or written, which
+// would slip past a transaction's checks.
+func TestMemory_Snapshots(t *testing.T) {
+	ctx := context.Background()
+	db := newMemoryTestDB()
+	seedWidgets(t, db)
+
+	tags := []string{"red"}
+	ref := db.Collection("widgets").Doc("g")
+	if err := ref.Create(ctx, widget{Name: "golf", Tags: tags}); err != nil {
+		t.Fatal(err)
+	}
+
+	tags[0] = "changed"
+	snapshot, err := ref.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var w widget
+	if err := snapshot.DataTo(&w); err != nil {
+		t.Fatal(err)
+	}
+
+	w.Tags[0] = "changed too"
+	if got := fetchWidget(t, db, "g").Tags; len(got) != 1 || got[0] != "red" {
+		t.Errorf("got tags %v, want them as written", got)
+	}
+}
@@ -0,0 +1,130 @@
+package db
+
+import (
+	"context"
+
+	"github.com/broothie/slink.chat/model"
+	"github.com/pkg/errors"
+)
+
+var (
+	// InvalidParent is returned for replies to a message that isn't a live, top-level message in the same channel.
+	InvalidParent = errors.New("invalid parent message")
+
+	// InvalidAttachment is returned for attachments that aren't the sender's, unsent, in the same channel.
+	InvalidAttachment = errors.New("invalid attachment")
+)
+
+// CreateMessage stores message with the next sequence number in its channel, bumping the channel's last message in
+// the same transaction so that concurrent sends can't share a number. Replies bump their parent's reply count the
+// same way, and attachments are linked to the message and copied into it. It returns the message as stored and its
+// channel. AlreadyExists and NotFound, for the message and channel respectively, InvalidParent and
+// InvalidAttachment are returned bare.
+func CreateMessage(ctx context.Context, db *DB, message model.Message, attachmentIDs ...string) (model.Message, model.Channel, error) {
+	var channel model.Channel
+	err := db.RunTransaction(ctx, func(ctx context.Context, tx Transaction) error {
+		var err error
+		if channel, err = NewFetcher[model.Channel](db).FetchIn(tx, message.ChannelID); err != nil {
+			return err
+		}
+
+		// Attachments already linked to this message are a retried send, which creating the message will catch
+		message.Attachments = nil
+		for _, attachmentID := range attachmentIDs {
+			attachment, err := NewFetcher[model.Attachment](db).FetchIn(tx, attachmentID)
+			if err == NotFound || (err == nil && (attachment.UserID != message.UserID || attachment.ChannelID != message.ChannelID || (attachment.Linked() && attachment.MessageID != message.ID))) {
+				return InvalidAttachment
+			} else if err != nil {
+				return err
+			}
+
+			attachment.MessageID = message.ID
+			attachment.UpdatedAt = message.CreatedAt
+			message.Attachments = append(message.Attachments, attachment)
+		}
+
+		if message.IsReply() {
+			parent, err := NewFetcher[model.Message](db).FetchIn(tx, message.ParentID)
+			if err == NotFound || (err == nil && (parent.ChannelID != message.ChannelID || parent.IsReply() || parent.Deleted())) {
+				return InvalidParent
+			} else if err != nil {
+				return err
+			}
+
+			tx.Update(db.CollectionFor(parent.Type()).Doc(parent.ID), []Update{
+				{Path: "updated_at", Value: message.CreatedAt},
+				{Path: "reply_count", Value: parent.ReplyCount + 1},
+				{Path: "last_reply_at", Value: message.CreatedAt},
+			})
+		}
+
+		for _, attachment := range message.Attachments {
+			tx.Update(db.CollectionFor(attachment.Type()).Doc(attachment.ID), []Update{
+				{Path: "updated_at", Value: attachment.UpdatedAt},
+				{Path: "message_id", Value: attachment.MessageID},
+			})
+		}
+
+		message.Seq = channel.LastMessageSeq + 1
+		channel.LastMessageSeq = message.Seq
+		channel.LastMessageSentAt = message.CreatedAt
+		channel.LastMessageUserID = message.UserID
+		channel.LastMessageMentions = message.Mentions
+		channel.UpdatedAt = message.CreatedAt
+
+		tx.Create(db.CollectionFor(message.Type()).Doc(message.ID), message)
+		tx.Update(db.CollectionFor(channel.Type()).Doc(channel.ID), []Update{
+			{Path: "updated_at", Value: channel.UpdatedAt},
+			{Path: "last_message_sent_at", Value: channel.LastMessageSentAt},
+			{Path: "last_message_seq", Value: channel.LastMessageSeq},
+			{Path: "last_message_user_id", Value: channel.LastMessageUserID},
+			{Path: "last_message_mentions", Value: channel.LastMessageMentions},
+		})
+
+		return nil
+	})
+	if err != nil {
+		if err == AlreadyExists || err == NotFound || err == InvalidParent || err == InvalidAttachment {
+			return model.Message{}, model.Channel{}, err
+		}
+
+		return model.Message{}, model.Channel{}, errors.Wrap(err, "failed to create message")
+	}
+
+	return message, channel, nil
+}
+
+// UpdateMessage applies f to a message in a transaction, storing the message it leaves if f reports changing it.
+// NotFound and any error from f are returned bare.
+func UpdateMessage(ctx context.Context, db *DB, messageID string, f func(message *model.Message) (bool, error)) (model.Message, error) {
+	var message model.Message
+	var updateErr error
+	err := db.RunTransaction(ctx, func(ctx context.Context, tx Transaction) error {
+		var err error
+		if message, err = NewFetcher[model.Message](db).FetchIn(tx, messageID); err != nil {
+			return err
+		}
+
+		changed, err := f(&message)
+		if err != nil {
+			updateErr = err
+			return err
+		}
+
+		if !changed {
+			return nil
+		}
+
+		tx.Set(db.CollectionFor(message.Type()).Doc(message.ID), message)
+		return nil
+	})
+	if err != nil {
+		if err == NotFound || err == updateErr {
+			return model.Message{}, err
+		}
+
+		return model.Message{}, errors.Wrap(err, "failed to update message")
+	}
+
+	return message, nil
+}
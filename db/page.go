@@ -0,0 +1,256 @@
+package db
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+var InvalidCursor = errors.New("invalid cursor")
+
+// PageParams selects a page of an ordered result. After and Before are cursors from a previous Page; with neither,
+// the first page is returned, or the last one if FromEnd is set.
+type PageParams struct {
+	Before  string
+	After   string
+	Limit   int
+	FromEnd bool
+}
+
+// Page is a slice of an ordered result. Next and Prev are cursors to the neighbouring pages, empty when there are
+// none.
+type Page[M any] struct {
+	Items []M
+	Next  string
+	Prev  string
+}
+
+// Page fetches a page of models ordered by orderBy, breaking ties by ID.
+func (f Fetcher[Model]) Page(ctx context.Context, orderBy string, params PageParams, queryFunc QueryFunc) (Page[Model], error) {
+	logger := ctxzap.Extract(ctx)
+
+	query, err := params.query(queryFunc(f.db.CollectionFor(f.Type()).Query), orderBy)
+	if err != nil {
+		return Page[Model]{}, err
+	}
+
+	snapshots, err := query.Documents(ctx)
+	if err != nil {
+		return Page[Model]{}, errors.Wrapf(err, "failed to query page of %q", f.Type())
+	}
+
+	models := make([]Model, 0, len(snapshots))
+	ids := make([]string, 0, len(snapshots))
+	for i, snapshot := range snapshots {
+		var model Model
+		if err := snapshot.DataTo(&model); err != nil {
+			logger.Error("failed to read snapshot", zap.Error(err), zap.Int("i", i), zap.String("type", string(f.Type())))
+			continue
+		}
+
+		models = append(models, model)
+		ids = append(ids, snapshot.ID())
+	}
+
+	return paginate(models, ids, orderBy, params)
+}
+
+// PageSlice pages through items already in hand, like search results, using the same cursors as Fetcher.Page.
+func PageSlice[M any](items []M, id func(M) string, orderBy string, params PageParams) (Page[M], error) {
+	docs := make([]document, 0, len(items))
+	byID := make(map[string]M, len(items))
+	for _, item := range items {
+		data, err := encode(item)
+		if err != nil {
+			return Page[M]{}, err
+		}
+
+		docs = append(docs, document{id: id(item), data: data})
+		byID[id(item)] = item
+	}
+
+	query, err := params.query(Query{}, orderBy)
+	if err != nil {
+		return Page[M]{}, err
+	}
+
+	docs = query.apply(docs)
+	paged := make([]M, 0, len(docs))
+	ids := make([]string, 0, len(docs))
+	for _, doc := range docs {
+		paged = append(paged, byID[doc.id])
+		ids = append(ids, doc.id)
+	}
+
+	return paginate(paged, ids, orderBy, params)
+}
+
+func (p PageParams) forward() bool {
+	return p.After != "" || (p.Before == "" && !p.FromEnd)
+}
+
+// query fetches one more item than the limit, so paginate can tell whether there's a page beyond.
+func (p PageParams) query(query Query, orderBy string) (Query, error) {
+	query = query.OrderBy(orderBy, Asc).OrderBy(DocumentID, Asc)
+
+	switch {
+	case p.After != "":
+		values, err := decodeCursor(p.After)
+		if err != nil {
+			return Query{}, err
+		}
+
+		return query.StartAfter(values...).Limit(p.Limit + 1), nil
+
+	case p.Before != "":
+		values, err := decodeCursor(p.Before)
+		if err != nil {
+			return Query{}, err
+		}
+
+		return query.EndBefore(values...).LimitToLast(p.Limit + 1), nil
+
+	case p.FromEnd:
+		return query.LimitToLast(p.Limit + 1), nil
+
+	default:
+		return query.Limit(p.Limit + 1), nil
+	}
+}
+
+func paginate[M any](items []M, ids []string, orderBy string, params PageParams) (Page[M], error) {
+	forward := params.forward()
+	more := len(items) > params.Limit
+	if more {
+		if forward {
+			items, ids = items[:params.Limit], ids[:params.Limit]
+		} else {
+			items, ids = items[1:], ids[1:]
+		}
+	}
+
+	page := Page[M]{Items: items}
+	if len(items) == 0 {
+		return page, nil
+	}
+
+	cursor := func(i int) (string, error) {
+		data, err := encode(items[i])
+		if err != nil {
+			return "", err
+		}
+
+		value, ok := lookup(data, orderBy)
+		if !ok {
+			return "", fmt.Errorf("item %q has no %q", ids[i], orderBy)
+		}
+
+		return encodeCursor(value, ids[i])
+	}
+
+	var err error
+	if (forward && params.After != "") || (!forward && more) {
+		if page.Prev, err = cursor(0); err != nil {
+			return Page[M]{}, errors.Wrap(err, "failed to encode prev cursor")
+		}
+	}
+
+	if (forward && more) || (!forward && params.Before != "") {
+		if page.Next, err = cursor(len(items) - 1); err != nil {
+			return Page[M]{}, errors.Wrap(err, "failed to encode next cursor")
+		}
+	}
+
+	return page, nil
+}
+
+type cursorValue struct {
+	Kind  string          `json:"k"`
+	Value json.RawMessage `json:"v"`
+}
+
+// encodeCursor packs the position of a document, its order value followed by its ID, into an opaque string.
+func encodeCursor(values ...any) (string, error) {
+	cursor := make([]cursorValue, 0, len(values))
+	for _, value := range values {
+		kind := ""
+		switch value.(type) {
+		case string:
+			kind = "string"
+		case bool:
+			kind = "bool"
+		case int64, float64:
+			kind = "number"
+		case time.Time:
+			kind = "time"
+		default:
+			return "", fmt.Errorf("cannot use %T in a cursor", value)
+		}
+
+		data, err := json.Marshal(value)
+		if err != nil {
+			return "", err
+		}
+
+		cursor = append(cursor, cursorValue{Kind: kind, Value: data})
+	}
+
+	data, err := json.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeCursor(encoded string) ([]any, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, InvalidCursor
+	}
+
+	var cursor []cursorValue
+	if err := json.Unmarshal(data, &cursor); err != nil || len(cursor) == 0 {
+		return nil, InvalidCursor
+	}
+
+	values := make([]any, 0, len(cursor))
+	for _, value := range cursor {
+		var target any
+		switch value.Kind {
+		case "string":
+			target = new(string)
+		case "bool":
+			target = new(bool)
+		case "number":
+			target = new(float64)
+		case "time":
+			target = new(time.Time)
+		default:
+			return nil, InvalidCursor
+		}
+
+		if err := json.Unmarshal(value.Value, target); err != nil {
+			return nil, InvalidCursor
+		}
+
+		switch target := target.(type) {
+		case *string:
+			values = append(values, *target)
+		case *bool:
+			values = append(values, *target)
+		case *float64:
+			values = append(values, *target)
+		case *time.Time:
+			values = append(values, *target)
+		}
+	}
+
+	return values, nil
+}
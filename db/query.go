@@ -0,0 +1,327 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/samber/lo"
+)
+
+type Direction int
+
+const (
+	Asc Direction = iota
+	Desc
+)
+
+// DocumentID can be passed to OrderBy to order by document ID.
+const DocumentID = "__name__"
+
+type filter struct {
+	path  string
+	op    string
+	value any
+}
+
+type order struct {
+	path      string
+	direction Direction
+}
+
+// Query describes a read against a single collection. Its builder methods mirror firestore.Query, so every
+// Store can translate it to whatever its backend understands.
+type Query struct {
+	store       Store
+	collection  string
+	filters     []filter
+	orders      []order
+	limit       int
+	limitToLast bool
+	startAfter  []any
+	endBefore   []any
+}
+
+func (q Query) Where(path, op string, value any) Query {
+	q.filters = append(append([]filter{}, q.filters...), filter{path: path, op: op, value: value})
+	return q
+}
+
+func (q Query) OrderBy(path string, direction Direction) Query {
+	q.orders = append(append([]order{}, q.orders...), order{path: path, direction: direction})
+	return q
+}
+
+func (q Query) Limit(n int) Query {
+	q.limit = n
+	q.limitToLast = false
+	return q
+}
+
+func (q Query) LimitToLast(n int) Query {
+	q.limit = n
+	q.limitToLast = true
+	return q
+}
+
+// StartAfter only includes documents positioned after values, which are given in the same order as OrderBy.
+func (q Query) StartAfter(values ...any) Query {
+	q.startAfter = values
+	return q
+}
+
+// EndBefore only includes documents positioned before values, which are given in the same order as OrderBy.
+func (q Query) EndBefore(values ...any) Query {
+	q.endBefore = values
+	return q
+}
+
+func (q Query) Documents(ctx context.Context) ([]Snapshot, error) {
+	return q.store.Query(ctx, q)
+}
+
+func (q Query) Snapshots(ctx context.Context) Listener {
+	return q.store.Listen(ctx, q)
+}
+
+func (q Query) matches(doc document) bool {
+	for _, filter := range q.filters {
+		if !filter.matches(doc.data) {
+			return false
+		}
+	}
+
+	for _, order := range q.orders {
+		if _, ok := q.value(doc, order.path); !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (q Query) value(doc document, path string) (any, bool) {
+	if path == DocumentID {
+		return doc.id, true
+	}
+
+	return lookup(doc.data, path)
+}
+
+// apply filters, orders and limits docs the way Firestore would.
+func (q Query) apply(docs []document) []document {
+	docs = lo.Filter(docs, func(doc document, _ int) bool {
+		if !q.matches(doc) {
+			return false
+		}
+
+		if q.startAfter != nil && q.position(doc, q.startAfter) <= 0 {
+			return false
+		}
+
+		return q.endBefore == nil || q.position(doc, q.endBefore) < 0
+	})
+
+	sort.SliceStable(docs, func(i, j int) bool { return q.less(docs[i], docs[j]) })
+
+	if q.limit > 0 && len(docs) > q.limit {
+		if q.limitToLast {
+			docs = docs[len(docs)-q.limit:]
+		} else {
+			docs = docs[:q.limit]
+		}
+	}
+
+	return docs
+}
+
+// position compares where doc sorts relative to cursor values.
+func (q Query) position(doc document, cursor []any) int {
+	for i, order := range q.orders {
+		if i >= len(cursor) {
+			break
+		}
+
+		value, _ := q.value(doc, order.path)
+		cmp, _ := compare(value, encodeValue(cursor[i]))
+		if cmp != 0 {
+			return lo.Ternary(order.direction == Desc, -cmp, cmp)
+		}
+	}
+
+	return 0
+}
+
+func (q Query) less(a, b document) bool {
+	for _, order := range q.orders {
+		aValue, _ := q.value(a, order.path)
+		bValue, _ := q.value(b, order.path)
+		cmp, _ := compare(aValue, bValue)
+		if cmp == 0 {
+			continue
+		}
+
+		if order.direction == Desc {
+			return cmp > 0
+		}
+
+		return cmp < 0
+	}
+
+	return a.id < b.id
+}
+
+func (f filter) matches(data map[string]any) bool {
+	value, ok := lookup(data, f.path)
+	if !ok {
+		return false
+	}
+
+	target := encodeValue(f.value)
+	switch f.op {
+	case "==":
+		return equal(value, target)
+
+	case "!=":
+		return !equal(value, target)
+
+	case "<", "<=", ">", ">=":
+		cmp, ok := compare(value, target)
+		if !ok {
+			return false
+		}
+
+		switch f.op {
+		case "<":
+			return cmp < 0
+		case "<=":
+			return cmp <= 0
+		case ">":
+			return cmp > 0
+		default:
+			return cmp >= 0
+		}
+
+	case "array-contains":
+		elems, _ := value.([]any)
+		return lo.ContainsBy(elems, func(elem any) bool { return equal(elem, target) })
+
+	case "array-contains-any":
+		elems, _ := value.([]any)
+		targets, _ := target.([]any)
+		return lo.SomeBy(elems, func(elem any) bool {
+			return lo.ContainsBy(targets, func(target any) bool { return equal(elem, target) })
+		})
+
+	case "in":
+		targets, _ := target.([]any)
+		return lo.ContainsBy(targets, func(target any) bool { return equal(value, target) })
+
+	case "not-in":
+		targets, _ := target.([]any)
+		return !lo.ContainsBy(targets, func(target any) bool { return equal(value, target) })
+	}
+
+	return false
+}
+
+func lookup(data map[string]any, path string) (any, bool) {
+	var current any = data
+	for _, key := range strings.Split(path, ".") {
+		fields, ok := current.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+
+		if current, ok = fields[key]; !ok {
+			return nil, false
+		}
+	}
+
+	return current, true
+}
+
+func equal(a, b any) bool {
+	if aElems, ok := a.([]any); ok {
+		bElems, ok := b.([]any)
+		if !ok || len(aElems) != len(bElems) {
+			return false
+		}
+
+		for i := range aElems {
+			if !equal(aElems[i], bElems[i]) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	if aFields, ok := a.(map[string]any); ok {
+		bFields, ok := b.(map[string]any)
+		if !ok || len(aFields) != len(bFields) {
+			return false
+		}
+
+		for key, aValue := range aFields {
+			if bValue, ok := bFields[key]; !ok || !equal(aValue, bValue) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+
+	cmp, ok := compare(a, b)
+	return ok && cmp == 0
+}
+
+// compare orders two encoded values of the same kind. ok is false when the values aren't comparable.
+func compare(a, b any) (cmp int, ok bool) {
+	switch a := a.(type) {
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b), true
+		}
+
+	case bool:
+		if b, ok := b.(bool); ok {
+			return lo.Ternary(a == b, 0, lo.Ternary(a, 1, -1)), true
+		}
+
+	case int64, float64:
+		aNumber, _ := toFloat(a)
+		if bNumber, ok := toFloat(b); ok {
+			return lo.Ternary(aNumber == bNumber, 0, lo.Ternary(aNumber > bNumber, 1, -1)), true
+		}
+
+	case time.Time:
+		if b, ok := b.(time.Time); ok {
+			return lo.Ternary(a.Equal(b), 0, lo.Ternary(a.After(b), 1, -1)), true
+		}
+
+	case []byte:
+		if b, ok := b.([]byte); ok {
+			return bytes.Compare(a, b), true
+		}
+	}
+
+	return 0, false
+}
+
+func toFloat(value any) (float64, bool) {
+	switch value := value.(type) {
+	case int64:
+		return float64(value), true
+	case float64:
+		return value, true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,202 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+type columnKind int
+
+const (
+	columnText columnKind = iota
+	columnInt
+	columnBool
+	columnTime
+	columnList
+)
+
+type column struct {
+	name string
+	kind columnKind
+}
+
+// table describes how the SQL store lays out a collection. Every table has an id primary key and a data column
+// holding the whole document as JSON. Columns are copies of document fields that queries can filter and order
+// on. List columns additionally get a side table of their elements, so array-contains is an indexed lookup.
+type table struct {
+	name    string
+	columns []column
+	indexes [][]string
+}
+
+var schema = []table{
+	{
+		name: "users",
+		columns: []column{
+			{name: "screenname", kind: columnText},
+			{name: "screenname_key", kind: columnText},
+			{name: "created_at", kind: columnTime},
+		},
+		indexes: [][]string{{"screenname"}, {"screenname_key"}, {"created_at"}},
+	},
+	{
+		name: "channels",
+		columns: []column{
+			{name: "name", kind: columnText},
+			{name: "user_id", kind: columnText},
+			{name: "chat_key", kind: columnText},
+			{name: "private", kind: columnBool},
+			{name: "created_at", kind: columnTime},
+			{name: "updated_at", kind: columnTime},
+			{name: "last_message_sent_at", kind: columnTime},
+		},
+		indexes: [][]string{{"name", "created_at"}, {"private", "chat_key"}},
+	},
+	{
+		name: "messages",
+		columns: []column{
+			{name: "channel_id", kind: columnText},
+			{name: "user_id", kind: columnText},
+			{name: "created_at", kind: columnTime},
+			{name: "updated_at", kind: columnTime},
+			{name: "seq", kind: columnInt},
+			{name: "parent_id", kind: columnText},
+		},
+		indexes: [][]string{{"channel_id", "created_at"}, {"channel_id", "updated_at"}, {"channel_id", "seq"}, {"channel_id", "parent_id", "seq"}, {"created_at"}},
+	},
+	{
+		name: "subscriptions",
+		columns: []column{
+			{name: "user_id", kind: columnText},
+			{name: "channel_id", kind: columnText},
+			{name: "created_at", kind: columnTime},
+		},
+		indexes: [][]string{{"user_id"}, {"channel_id"}},
+	},
+	{
+		name: "notifications",
+		columns: []column{
+			{name: "user_id", kind: columnText},
+			{name: "read", kind: columnBool},
+			{name: "created_at", kind: columnTime},
+			{name: "updated_at", kind: columnTime},
+		},
+		indexes: [][]string{{"user_id", "created_at"}, {"user_id", "read", "created_at"}, {"user_id", "updated_at"}, {"created_at"}},
+	},
+	{
+		name: "attachments",
+		columns: []column{
+			{name: "user_id", kind: columnText},
+			{name: "channel_id", kind: columnText},
+			{name: "message_id", kind: columnText},
+			{name: "created_at", kind: columnTime},
+		},
+		indexes: [][]string{{"message_id"}, {"created_at"}},
+	},
+	{
+		name: "presences",
+		columns: []column{
+			{name: "user_id", kind: columnText},
+			{name: "expires_at", kind: columnTime},
+		},
+		indexes: [][]string{{"user_id"}, {"expires_at"}},
+	},
+	{
+		name: "migrations",
+		columns: []column{
+			{name: "version", kind: columnInt},
+		},
+	},
+}
+
+// column looks up a column by name. Documents' id field is always available, since it's the primary key.
+func (t table) column(name string) (column, bool) {
+	if name == "id" {
+		return column{name: "id", kind: columnText}, true
+	}
+
+	for _, column := range t.columns {
+		if column.name == name {
+			return column, true
+		}
+	}
+
+	return column{}, false
+}
+
+// value converts an encoded field into what gets stored in the column. Missing fields are stored as NULL.
+func (c column) value(field any) (any, error) {
+	if field == nil {
+		return nil, nil
+	}
+
+	switch c.kind {
+	case columnText:
+		if field, ok := field.(string); ok {
+			return field, nil
+		}
+
+	case columnInt:
+		if number, ok := toFloat(field); ok {
+			return int64(number), nil
+		}
+
+	case columnBool:
+		if field, ok := field.(bool); ok {
+			return field, nil
+		}
+
+	case columnTime:
+		switch field := field.(type) {
+		case time.Time:
+			return field.UnixNano(), nil
+
+		case string:
+			t, err := time.Parse(time.RFC3339Nano, field)
+			if err != nil {
+				return nil, err
+			}
+
+			return t.UnixNano(), nil
+		}
+
+	case columnList:
+		if _, ok := field.([]any); ok {
+			data, err := json.Marshal(field)
+			if err != nil {
+				return nil, err
+			}
+
+			return string(data), nil
+		}
+	}
+
+	return nil, fmt.Errorf("cannot store %T in column %q", field, c.name)
+}
+
+// elem converts an element of a list column into what gets stored in its side table.
+func (c column) elem(elem any) (string, error) {
+	if elem, ok := elem.(string); ok {
+		return elem, nil
+	}
+
+	data, err := json.Marshal(elem)
+	return string(data), err
+}
+
+// normalize restores column fields read back from JSON to the types encode produces, so in-process query
+// matching treats documents from the SQL store like any other.
+func (t table) normalize(data map[string]any) {
+	for _, column := range t.columns {
+		if column.kind != columnTime {
+			continue
+		}
+
+		if field, ok := data[column.name].(string); ok {
+			if parsed, err := time.Parse(time.RFC3339Nano, field); err == nil {
+				data[column.name] = parsed
+			}
+		}
+	}
+}
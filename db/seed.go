@@ -0,0 +1,47 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/broothie/slink.chat/model"
+	"github.com/gorilla/securecookie"
+	"github.com/pkg/errors"
+	"github.com/rs/xid"
+)
+
+// Seed creates SmarterChild and World Chat, which every environment needs before users can sign up.
+func Seed(ctx context.Context, db *DB) (model.User, model.Channel, error) {
+	now := time.Now()
+	smarterChild := model.User{
+		ID:            xid.New().String(),
+		CreatedAt:     now,
+		UpdatedAt:     now,
+		Screenname:    model.ScreennameSmarterChild,
+		ScreennameKey: model.ScreennameKey(model.ScreennameSmarterChild),
+	}
+
+	if err := smarterChild.UpdatePassword(string(securecookie.GenerateRandomKey(32))); err != nil {
+		return model.User{}, model.Channel{}, errors.Wrap(err, "failed to generate password")
+	}
+
+	worldChat := model.Channel{
+		ID:        xid.New().String(),
+		CreatedAt: now,
+		UpdatedAt: now,
+		UserID:    smarterChild.ID,
+		Name:      model.ChannelNameWorldChat,
+	}
+
+	subscription := model.NewSubscription(smarterChild.ID, worldChat.ID, model.RoleOwner, now)
+
+	batch := db.Batch()
+	batch.Create(db.CollectionFor(smarterChild.Type()).Doc(smarterChild.ID), smarterChild)
+	batch.Create(db.CollectionFor(worldChat.Type()).Doc(worldChat.ID), worldChat)
+	batch.Create(db.CollectionFor(subscription.Type()).Doc(subscription.ID), subscription)
+	if err := batch.Commit(ctx); err != nil {
+		return model.User{}, model.Channel{}, errors.Wrap(err, "failed to init db defaults")
+	}
+
+	return smarterChild, worldChat, nil
+}
@@ -0,0 +1,691 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/broothie/slink.chat/bus"
+	"github.com/broothie/slink.chat/config"
+	"github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+)
+
+// SQL is a Store backed by SQLite or Postgres, using the tables described by schema. Listeners are served in
+// process, so they only see writes made through the same SQL store, or announced by others over a bus.
+type SQL struct {
+	db        *sql.DB
+	cfg       *config.Config
+	postgres  bool
+	mu        sync.Mutex
+	listeners *listeners
+	bus       bus.Bus
+	logger    *zap.Logger
+}
+
+func NewSQL(cfg *config.Config) (*SQL, error) {
+	driver, dataSource := "sqlite3", cfg.DatabaseURL
+	if cfg.Database == config.DatabasePostgres {
+		driver = "postgres"
+	} else if dataSource == "" {
+		dataSource = fmt.Sprintf("%s.%s.db", config.AppName, cfg.Environment)
+	}
+
+	sqlDB, err := sql.Open(driver, dataSource)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open %s database", driver)
+	}
+
+	if driver == "sqlite3" {
+		// SQLite only allows a single writer, and each connection to :memory: is its own database.
+		sqlDB.SetMaxOpenConns(1)
+	}
+
+	s := &SQL{
+		db:        sqlDB,
+		cfg:       cfg,
+		postgres:  driver == "postgres",
+		listeners: newListeners(),
+	}
+
+	if err := s.migrate(context.Background()); err != nil {
+		return nil, errors.Wrap(err, "failed to migrate sql schema")
+	}
+
+	return s, nil
+}
+
+func (s *SQL) Get(ctx context.Context, ref DocumentRef) (Snapshot, error) {
+	snapshots, err := s.GetAll(ctx, []DocumentRef{ref})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(snapshots) == 0 {
+		return nil, NotFound
+	}
+
+	return snapshots[0], nil
+}
+
+func (s *SQL) GetAll(ctx context.Context, refs []DocumentRef) ([]Snapshot, error) {
+	var snapshots []Snapshot
+	for collection, refs := range lo.GroupBy(refs, func(ref DocumentRef) string { return ref.Collection }) {
+		table, err := s.table(collection)
+		if err != nil {
+			return nil, err
+		}
+
+		b := s.builder()
+		b.WriteString(fmt.Sprintf("SELECT id, data FROM %s WHERE id IN (", s.tableName(table)))
+		b.WriteString(strings.Join(lo.Map(refs, func(ref DocumentRef, _ int) string { return b.arg(ref.ID) }), ", "))
+		b.WriteString(")")
+
+		docs, err := s.documents(ctx, s.db, table, b)
+		if err != nil {
+			return nil, err
+		}
+
+		byID := lo.KeyBy(docs, func(doc document) string { return doc.id })
+		for _, ref := range refs {
+			if doc, ok := byID[ref.ID]; ok {
+				snapshots = append(snapshots, docSnapshot{id: doc.id, data: doc.data})
+			}
+		}
+	}
+
+	return snapshots, nil
+}
+
+func (s *SQL) Query(ctx context.Context, query Query) ([]Snapshot, error) {
+	docs, err := s.query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	return lo.Map(docs, func(doc document, _ int) Snapshot { return docSnapshot{id: doc.id, data: doc.data} }), nil
+}
+
+func (s *SQL) Listen(ctx context.Context, query Query) Listener {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	docs, err := s.query(ctx, query)
+	if err != nil {
+		return errListener{err: err}
+	}
+
+	return s.listeners.add(ctx, query, docs)
+}
+
+func (s *SQL) Batch() Batch {
+	return &batch{commit: s.commitBatch}
+}
+
+func (s *SQL) RunTransaction(ctx context.Context, f func(ctx context.Context, tx Transaction) error) error {
+	return runTransaction(ctx, func(ctx context.Context, ref DocumentRef) (map[string]any, bool, error) {
+		return s.read(ctx, s.db, ref, false)
+	}, s.commit, f)
+}
+
+func (s *SQL) Close() error {
+	s.listeners.close()
+	return s.db.Close()
+}
+
+func (s *SQL) query(ctx context.Context, query Query) ([]document, error) {
+	table, err := s.table(query.collection)
+	if err != nil {
+		return nil, err
+	}
+
+	b := s.builder()
+	b.WriteString(fmt.Sprintf("SELECT id, data FROM %s", s.tableName(table)))
+
+	var conditions []string
+	for _, filter := range query.filters {
+		condition, err := s.condition(b, table, filter)
+		if err != nil {
+			return nil, err
+		}
+
+		conditions = append(conditions, condition)
+	}
+
+	orders := query.orders
+	if query.limitToLast {
+		orders = lo.Map(orders, func(o order, _ int) order {
+			return order{path: o.path, direction: lo.Ternary(o.direction == Desc, Asc, Desc)}
+		})
+	}
+
+	var orderBys []string
+	for _, order := range orders {
+		name, err := s.orderColumn(table, order)
+		if err != nil {
+			return nil, err
+		}
+
+		conditions = append(conditions, fmt.Sprintf("%s IS NOT NULL", name))
+		orderBys = append(orderBys, fmt.Sprintf("%s %s", name, lo.Ternary(order.direction == Desc, "DESC", "ASC")))
+	}
+
+	for _, cursor := range []struct {
+		values []any
+		after  bool
+	}{{values: query.startAfter, after: true}, {values: query.endBefore, after: false}} {
+		if cursor.values == nil {
+			continue
+		}
+
+		condition, err := s.cursorCondition(b, table, query.orders, cursor.values, cursor.after)
+		if err != nil {
+			return nil, err
+		}
+
+		conditions = append(conditions, condition)
+	}
+
+	orderBys = append(orderBys, fmt.Sprintf("id %s", lo.Ternary(query.limitToLast, "DESC", "ASC")))
+
+	if len(conditions) > 0 {
+		b.WriteString(" WHERE " + strings.Join(conditions, " AND "))
+	}
+
+	b.WriteString(" ORDER BY " + strings.Join(orderBys, ", "))
+	if query.limit > 0 {
+		b.WriteString(fmt.Sprintf(" LIMIT %d", query.limit))
+	}
+
+	docs, err := s.documents(ctx, s.db, table, b)
+	if err != nil {
+		return nil, err
+	}
+
+	if query.limitToLast {
+		docs = lo.Reverse(docs)
+	}
+
+	return docs, nil
+}
+
+func (s *SQL) condition(b *sqlBuilder, table table, filter filter) (string, error) {
+	column, ok := table.column(filter.path)
+	if !ok {
+		return "", fmt.Errorf("cannot filter %s on %q: not a column", table.name, filter.path)
+	}
+
+	target := encodeValue(filter.value)
+	switch filter.op {
+	case "==", "!=", "<", "<=", ">", ">=":
+		value, err := column.value(target)
+		if err != nil {
+			return "", err
+		}
+
+		return fmt.Sprintf("%s %s %s", column.name, lo.Ternary(filter.op == "==", "=", filter.op), b.arg(value)), nil
+
+	case "in", "not-in":
+		targets, _ := target.([]any)
+		if len(targets) == 0 {
+			return lo.Ternary(filter.op == "in", "1 = 0", fmt.Sprintf("%s IS NOT NULL", column.name)), nil
+		}
+
+		placeholders := make([]string, 0, len(targets))
+		for _, target := range targets {
+			value, err := column.value(target)
+			if err != nil {
+				return "", err
+			}
+
+			placeholders = append(placeholders, b.arg(value))
+		}
+
+		return fmt.Sprintf("%s %s (%s)", column.name, lo.Ternary(filter.op == "in", "IN", "NOT IN"), strings.Join(placeholders, ", ")), nil
+
+	case "array-contains", "array-contains-any":
+		if column.kind != columnList {
+			return "", fmt.Errorf("cannot use %s on non-list column %q", filter.op, column.name)
+		}
+
+		targets := []any{target}
+		if filter.op == "array-contains-any" {
+			targets, _ = target.([]any)
+		}
+
+		placeholders := make([]string, 0, len(targets))
+		for _, target := range targets {
+			elem, err := column.elem(target)
+			if err != nil {
+				return "", err
+			}
+
+			placeholders = append(placeholders, b.arg(elem))
+		}
+
+		if len(placeholders) == 0 {
+			return "1 = 0", nil
+		}
+
+		return fmt.Sprintf("id IN (SELECT id FROM %s WHERE value IN (%s))", s.sideTableName(table, column), strings.Join(placeholders, ", ")), nil
+	}
+
+	return "", fmt.Errorf("unsupported operator %q", filter.op)
+}
+
+func (s *SQL) orderColumn(table table, order order) (string, error) {
+	if order.path == DocumentID {
+		return "id", nil
+	}
+
+	if _, ok := table.column(order.path); !ok {
+		return "", fmt.Errorf("cannot order %s by %q: not a column", table.name, order.path)
+	}
+
+	return order.path, nil
+}
+
+// cursorCondition selects rows positioned strictly after (or before) cursor values under orders, by expanding
+// the tuple comparison into (a > x) OR (a = x AND b > y) and so on.
+func (s *SQL) cursorCondition(b *sqlBuilder, table table, orders []order, values []any, after bool) (string, error) {
+	var alternatives, equalities []string
+	for i, order := range orders {
+		if i >= len(values) {
+			break
+		}
+
+		name, err := s.orderColumn(table, order)
+		if err != nil {
+			return "", err
+		}
+
+		value := encodeValue(values[i])
+		if column, ok := table.column(order.path); ok {
+			if value, err = column.value(value); err != nil {
+				return "", err
+			}
+		}
+
+		ascending := order.direction == Asc
+		op := lo.Ternary(after == ascending, ">", "<")
+		alternatives = append(alternatives, strings.Join(append(append([]string{}, equalities...), fmt.Sprintf("%s %s %s", name, op, b.arg(value))), " AND "))
+		equalities = append(equalities, fmt.Sprintf("%s = %s", name, b.arg(value)))
+	}
+
+	if len(alternatives) == 0 {
+		return "1 = 1", nil
+	}
+
+	return fmt.Sprintf("((%s))", strings.Join(alternatives, ") OR (")), nil
+}
+
+type sqlQueryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+func (s *SQL) documents(ctx context.Context, queryer sqlQueryer, table table, b *sqlBuilder) ([]document, error) {
+	rows, err := queryer.QueryContext(ctx, b.String(), b.args...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to query %s", table.name)
+	}
+	defer rows.Close()
+
+	var docs []document
+	for rows.Next() {
+		var id, data string
+		if err := rows.Scan(&id, &data); err != nil {
+			return nil, errors.Wrapf(err, "failed to scan %s row", table.name)
+		}
+
+		var fields map[string]any
+		if err := json.Unmarshal([]byte(data), &fields); err != nil {
+			return nil, errors.Wrapf(err, "failed to unmarshal %s/%s", table.name, id)
+		}
+
+		table.normalize(fields)
+		docs = append(docs, document{id: id, data: fields})
+	}
+
+	return docs, errors.Wrapf(rows.Err(), "failed to read %s rows", table.name)
+}
+
+// write stores the row for id, updating it in place if it exists, or deletes it when data is nil. Rows are updated
+// rather than replaced so that, on Postgres, commits waiting on a row's lock go on to see its new version.
+func (s *SQL) write(ctx context.Context, tx *sql.Tx, table table, id string, data map[string]any, exists bool) error {
+	tableName := s.tableName(table)
+	if data == nil {
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE id = %s", tableName, s.placeholder(1)), id); err != nil {
+			return errors.Wrapf(err, "failed to delete %s/%s", table.name, id)
+		}
+	}
+
+	for _, column := range table.columns {
+		if column.kind != columnList || (data != nil && !exists) {
+			continue
+		}
+
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE id = %s", s.sideTableName(table, column), s.placeholder(1)), id); err != nil {
+			return errors.Wrapf(err, "failed to delete %s/%s %s", table.name, id, column.name)
+		}
+	}
+
+	if data == nil {
+		return nil
+	}
+
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return errors.Wrapf(err, "failed to marshal %s/%s", table.name, id)
+	}
+
+	names := []string{"data"}
+	values := []any{string(encoded)}
+	for _, column := range table.columns {
+		field, _ := lookup(data, column.name)
+		value, err := column.value(field)
+		if err != nil {
+			return errors.Wrapf(err, "failed to convert %s/%s %s", table.name, id, column.name)
+		}
+
+		names = append(names, column.name)
+		values = append(values, value)
+	}
+
+	b := s.builder()
+	if exists {
+		sets := lo.Map(names, func(name string, i int) string { return fmt.Sprintf("%s = %s", name, b.arg(values[i])) })
+		b.WriteString(fmt.Sprintf("UPDATE %s SET %s WHERE id = %s", tableName, strings.Join(sets, ", "), b.arg(id)))
+	} else {
+		placeholders := append([]string{b.arg(id)}, lo.Map(values, func(value any, _ int) string { return b.arg(value) })...)
+		b.WriteString(fmt.Sprintf("INSERT INTO %s (id, %s) VALUES (%s)", tableName, strings.Join(names, ", "), strings.Join(placeholders, ", ")))
+	}
+
+	statement := b.String()
+
+	if _, err := tx.ExecContext(ctx, statement, b.args...); err != nil {
+		return errors.Wrapf(err, "failed to write %s/%s", table.name, id)
+	}
+
+	for _, column := range table.columns {
+		if column.kind != columnList {
+			continue
+		}
+
+		field, _ := lookup(data, column.name)
+		elems, _ := field.([]any)
+		values := make([]string, 0, len(elems))
+		for _, elem := range elems {
+			value, err := column.elem(elem)
+			if err != nil {
+				return errors.Wrapf(err, "failed to convert %s/%s %s element", table.name, id, column.name)
+			}
+
+			values = append(values, value)
+		}
+
+		for _, value := range lo.Uniq(values) {
+			statement := fmt.Sprintf("INSERT INTO %s (value, id) VALUES (%s, %s)", s.sideTableName(table, column), s.placeholder(1), s.placeholder(2))
+			if _, err := tx.ExecContext(ctx, statement, value, id); err != nil {
+				return errors.Wrapf(err, "failed to insert %s/%s %s element", table.name, id, column.name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// migrate creates any missing tables, columns and indexes, backfilling new columns from each row's data.
+func (s *SQL) migrate(ctx context.Context) error {
+	for _, table := range schema {
+		tableName := s.tableName(table)
+		if _, err := s.db.ExecContext(ctx, fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (id TEXT PRIMARY KEY, data TEXT NOT NULL)", tableName)); err != nil {
+			return errors.Wrapf(err, "failed to create table %s", tableName)
+		}
+
+		rows, err := s.db.QueryContext(ctx, fmt.Sprintf("SELECT * FROM %s LIMIT 0", tableName))
+		if err != nil {
+			return errors.Wrapf(err, "failed to inspect table %s", tableName)
+		}
+
+		existing, err := rows.Columns()
+		rows.Close()
+		if err != nil {
+			return errors.Wrapf(err, "failed to read columns of %s", tableName)
+		}
+
+		missing := lo.Reject(table.columns, func(column column, _ int) bool { return lo.Contains(existing, column.name) })
+		for _, column := range missing {
+			if _, err := s.db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", tableName, column.name, s.columnType(column))); err != nil {
+				return errors.Wrapf(err, "failed to add column %s.%s", tableName, column.name)
+			}
+		}
+
+		for _, column := range table.columns {
+			if column.kind != columnList {
+				continue
+			}
+
+			sideTableName := s.sideTableName(table, column)
+			if _, err := s.db.ExecContext(ctx, fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (value TEXT NOT NULL, id TEXT NOT NULL, PRIMARY KEY (value, id))", sideTableName)); err != nil {
+				return errors.Wrapf(err, "failed to create table %s", sideTableName)
+			}
+
+			if _, err := s.db.ExecContext(ctx, fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s_id ON %s (id)", sideTableName, sideTableName)); err != nil {
+				return errors.Wrapf(err, "failed to index %s", sideTableName)
+			}
+		}
+
+		for _, columns := range table.indexes {
+			indexName := fmt.Sprintf("%s_%s", tableName, strings.Join(columns, "_"))
+			if _, err := s.db.ExecContext(ctx, fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (%s)", indexName, tableName, strings.Join(columns, ", "))); err != nil {
+				return errors.Wrapf(err, "failed to create index %s", indexName)
+			}
+		}
+
+		if len(missing) > 0 && len(existing) > 0 {
+			if err := s.backfill(ctx, table); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *SQL) backfill(ctx context.Context, table table) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback()
+
+	b := s.builder()
+	b.WriteString(fmt.Sprintf("SELECT id, data FROM %s", s.tableName(table)))
+	docs, err := s.documents(ctx, tx, table, b)
+	if err != nil {
+		return err
+	}
+
+	for _, doc := range docs {
+		if err := s.write(ctx, tx, table, doc.id, doc.data, true); err != nil {
+			return errors.Wrapf(err, "failed to backfill %s", table.name)
+		}
+	}
+
+	return errors.Wrapf(tx.Commit(), "failed to commit %s backfill", table.name)
+}
+
+func (s *SQL) table(collection string) (table, error) {
+	name := strings.TrimPrefix(collection, s.cfg.Collection(""))
+	if table, ok := lo.Find(schema, func(table table) bool { return table.name == name }); ok {
+		return table, nil
+	}
+
+	return table{}, fmt.Errorf("no sql table for collection %q", collection)
+}
+
+func (s *SQL) tableName(table table) string {
+	return strings.NewReplacer(".", "_", "-", "_").Replace(s.cfg.Collection(table.name))
+}
+
+func (s *SQL) sideTableName(table table, column column) string {
+	return fmt.Sprintf("%s__%s", s.tableName(table), column.name)
+}
+
+func (s *SQL) columnType(column column) string {
+	switch column.kind {
+	case columnInt, columnTime:
+		return "BIGINT"
+	case columnBool:
+		return "BOOLEAN"
+	default:
+		return "TEXT"
+	}
+}
+
+func (s *SQL) placeholder(n int) string {
+	if s.postgres {
+		return fmt.Sprintf("$%d", n)
+	}
+
+	return "?"
+}
+
+func (s *SQL) builder() *sqlBuilder {
+	return &sqlBuilder{sql: s}
+}
+
+// sqlBuilder accumulates a statement and its arguments, numbering placeholders for the dialect.
+type sqlBuilder struct {
+	strings.Builder
+	sql  *SQL
+	args []any
+}
+
+func (b *sqlBuilder) arg(value any) string {
+	b.args = append(b.args, value)
+	return b.sql.placeholder(len(b.args))
+}
+
+// commit applies writes in a transaction and notifies listeners once it commits. Writes that run into another
+// instance's, creating the same row or deadlocking with it, return errTransactionConflict.
+func (s *SQL) commit(ctx context.Context, writes []pendingWrite) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	staged, err := s.apply(ctx, writes)
+	if conflicted(err) {
+		return errTransactionConflict
+	} else if err != nil {
+		return err
+	}
+
+	s.listeners.publish(staged)
+	s.announce(staged)
+	return nil
+}
+
+// commitBatch commits a batch's writes, trying again if they run into another instance's. Having read nothing
+// that could go stale, they can be retried as they are.
+func (s *SQL) commitBatch(ctx context.Context, writes []pendingWrite) error {
+	for attempt := 0; attempt < maxTransactionAttempts; attempt++ {
+		if attempt > 0 {
+			if err := backoff(ctx, attempt); err != nil {
+				return err
+			}
+		}
+
+		if err := s.commit(ctx, writes); err != errTransactionConflict {
+			return err
+		}
+	}
+
+	return errors.Wrapf(errTransactionConflict, "failed after %d attempts", maxTransactionAttempts)
+}
+
+// apply stages writes and stores what they leave behind. The rows it reads stay locked until it's done, so that
+// on Postgres, commits from other instances to the same documents take turns rather than both passing their
+// checks against the same data. s.mu does the same for commits in this process.
+func (s *SQL) apply(ctx context.Context, writes []pendingWrite) (map[DocumentRef]map[string]any, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback()
+
+	existed := make(map[DocumentRef]bool)
+	staged, err := stage(writes, func(ref DocumentRef) (map[string]any, bool, error) {
+		data, exists, err := s.read(ctx, tx, ref, true)
+		existed[ref] = exists
+		return data, exists, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for ref, data := range staged {
+		table, err := s.table(ref.Collection)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := s.write(ctx, tx, table, ref.ID, data, existed[ref]); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "failed to commit transaction")
+	}
+
+	return staged, nil
+}
+
+// conflicted reports whether err is Postgres refusing a write because of a concurrent one: a row created by
+// another transaction since it was found missing, or a deadlock between the two.
+func conflicted(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+
+	return pqErr.Code.Name() == "unique_violation" || pqErr.Code.Name() == "deadlock_detected"
+}
+
+// read gets a single document's data, reporting whether it exists. lock has Postgres hold the row until the
+// transaction it's read in ends.
+func (s *SQL) read(ctx context.Context, queryer sqlQueryer, ref DocumentRef, lock bool) (map[string]any, bool, error) {
+	table, err := s.table(ref.Collection)
+	if err != nil {
+		return nil, false, err
+	}
+
+	b := s.builder()
+	b.WriteString(fmt.Sprintf("SELECT id, data FROM %s WHERE id = %s", s.tableName(table), b.arg(ref.ID)))
+	if lock && s.postgres {
+		b.WriteString(" FOR UPDATE")
+	}
+
+	docs, err := s.documents(ctx, queryer, table, b)
+	if err != nil || len(docs) == 0 {
+		return nil, false, err
+	}
+
+	return docs[0].data, true, nil
+}
+
+type errListener struct {
+	err error
+}
+
+func (l errListener) Next() ([]DocumentChange, error) {
+	return nil, l.err
+}
+
+func (l errListener) Stop() {}
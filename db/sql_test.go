@@ -0,0 +1,126 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/broothie/slink.chat/config"
+	"github.com/rs/xid"
+)
+
+// sqlDatabases are the SQL stores to test against. SQLite always runs in memory; Postgres runs when
+// TEST_POSTGRES_URL points at a database to make tables in.
+func sqlDatabases() map[string]string {
+	databases := map[string]string{config.DatabaseSQLite: ":memory:"}
+	if url := os.Getenv("TEST_POSTGRES_URL"); url != "" {
+		databases[config.DatabasePostgres] = url
+	}
+
+	return databases
+}
+
+// newSQLTestDB returns instances of a fresh SQL database, which share their tables like servers would.
+func newSQLTestDB(t *testing.T, database, url string, instances int) []*DB {
+	t.Helper()
+
+	cfg := &config.Config{
+		Environment: fmt.Sprintf("test%s", xid.New()),
+		Database:    database,
+		DatabaseURL: url,
+	}
+
+	dbs := make([]*DB, instances)
+	for i := range dbs {
+		store, err := NewSQL(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		dbs[i] = &DB{Store: store, cfg: cfg}
+	}
+
+	t.Cleanup(func() {
+		if database == config.DatabasePostgres {
+			dropTables(t, dbs[0].Store.(*SQL))
+		}
+
+		for _, db := range dbs {
+			db.Close()
+		}
+	})
+
+	return dbs
+}
+
+func dropTables(t *testing.T, s *SQL) {
+	for _, table := range schema {
+		for _, column := range table.columns {
+			if column.kind == columnList {
+				if _, err := s.db.Exec(fmt.Sprintf("DROP TABLE %s", s.sideTableName(table, column))); err != nil {
+					t.Error(err)
+				}
+			}
+		}
+
+		if _, err := s.db.Exec(fmt.Sprintf("DROP TABLE %s", s.tableName(table))); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func forEachSQL(t *testing.T, test func(t *testing.T, db *DB)) {
+	for database, url := range sqlDatabases() {
+		t.Run(database, func(t *testing.T) {
+			test(t, newSQLTestDB(t, database, url, 1)[0])
+		})
+	}
+}
+
+func TestSQL_Query(t *testing.T) {
+	forEachSQL(t, testQuery)
+}
+
+func TestSQL_Transaction(t *testing.T) {
+	forEachSQL(t, testTransaction)
+}
+
+func TestSQL_Batch(t *testing.T) {
+	forEachSQL(t, testBatch)
+}
+
+func TestSQL_Migrate(t *testing.T) {
+	forEachSQL(t, func(t *testing.T, db *DB) {
+		seedWidgets(t, db)
+		s := db.Store.(*SQL)
+
+		// Dropping a column leaves it to be added back and backfilled, as for a column new to the schema
+		if _, err := s.db.Exec(fmt.Sprintf("ALTER TABLE %s DROP COLUMN rank", s.tableName(schema[len(schema)-1]))); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := s.migrate(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		if got, want := ids(t, db.Collection("widgets").Where("rank", ">", 2)), []string{"a", "e"}; fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+}
+
+// TestSQL_CreateMessage sends from many instances at once where the database can be shared.
+func TestSQL_CreateMessage(t *testing.T) {
+	for database, url := range sqlDatabases() {
+		t.Run(database, func(t *testing.T) {
+			// An SQLite database is only ever one instance's
+			instances := 1
+			if database == config.DatabasePostgres {
+				instances = 3
+			}
+
+			testCreateMessage(t, newSQLTestDB(t, database, url, instances))
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package db
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+var AlreadyExists = errors.New("already exists")
+
+// Store is the storage backend behind a DB.
+type Store interface {
+	Get(ctx context.Context, ref DocumentRef) (Snapshot, error)
+	GetAll(ctx context.Context, refs []DocumentRef) ([]Snapshot, error)
+	Query(ctx context.Context, query Query) ([]Snapshot, error)
+	Listen(ctx context.Context, query Query) Listener
+	Batch() Batch
+	RunTransaction(ctx context.Context, f func(ctx context.Context, tx Transaction) error) error
+	Close() error
+}
+
+// Snapshot is a single document read from a Store.
+type Snapshot interface {
+	ID() string
+	DataTo(any) error
+}
+
+// Batch is a set of writes committed atomically.
+type Batch interface {
+	Create(ref DocumentRef, data any)
+	Set(ref DocumentRef, data any)
+	Update(ref DocumentRef, updates []Update)
+	Delete(ref DocumentRef)
+	Commit(ctx context.Context) error
+}
+
+// Transaction reads documents and stages writes, like Firestore's. Its writes only commit if nothing it read has
+// changed in the meantime; otherwise the transaction function is run again, so it shouldn't have other side effects.
+// Reads have to come before writes.
+type Transaction interface {
+	Get(ref DocumentRef) (Snapshot, error)
+	Create(ref DocumentRef, data any)
+	Set(ref DocumentRef, data any)
+	Update(ref DocumentRef, updates []Update)
+	Delete(ref DocumentRef)
+}
+
+type ChangeKind int
+
+const (
+	DocumentAdded ChangeKind = iota
+	DocumentRemoved
+	DocumentModified
+)
+
+type DocumentChange struct {
+	Kind ChangeKind
+	Doc  Snapshot
+}
+
+// Listener streams changes to the documents matching a query. The first call to Next returns every document
+// matching at the time the listener started. Next returns context.Canceled once the listener is stopped.
+type Listener interface {
+	Next() ([]DocumentChange, error)
+	Stop()
+}
+
+type Update struct {
+	Path  string
+	Value any
+}
+
+type arrayUnion []any
+
+type arrayRemove []any
+
+func ArrayUnion(elems ...any) any {
+	return arrayUnion(elems)
+}
+
+func ArrayRemove(elems ...any) any {
+	return arrayRemove(elems)
+}
+
+type CollectionRef struct {
+	Query
+}
+
+func (c CollectionRef) Doc(id string) DocumentRef {
+	return DocumentRef{Collection: c.collection, ID: id, store: c.store}
+}
+
+type DocumentRef struct {
+	Collection string
+	ID         string
+	store      Store
+}
+
+func (d DocumentRef) Get(ctx context.Context) (Snapshot, error) {
+	return d.store.Get(ctx, d)
+}
+
+func (d DocumentRef) Create(ctx context.Context, data any) error {
+	batch := d.store.Batch()
+	batch.Create(d, data)
+	return batch.Commit(ctx)
+}
+
+func (d DocumentRef) Set(ctx context.Context, data any) error {
+	batch := d.store.Batch()
+	batch.Set(d, data)
+	return batch.Commit(ctx)
+}
+
+func (d DocumentRef) Update(ctx context.Context, updates []Update) error {
+	batch := d.store.Batch()
+	batch.Update(d, updates)
+	return batch.Commit(ctx)
+}
+
+func (d DocumentRef) Delete(ctx context.Context) error {
+	batch := d.store.Batch()
+	batch.Delete(d)
+	return batch.Commit(ctx)
+}
@@ -0,0 +1,382 @@
+package db
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/broothie/slink.chat/model"
+	"github.com/pkg/errors"
+	"github.com/rs/xid"
+	"github.com/samber/lo"
+)
+
+// Tests store widgets, which have a field of every column kind.
+func init() {
+	schema = append(schema, table{
+		name: "widgets",
+		columns: []column{
+			{name: "name", kind: columnText},
+			{name: "rank", kind: columnInt},
+			{name: "active", kind: columnBool},
+			{name: "created_at", kind: columnTime},
+			{name: "tags", kind: columnList},
+		},
+	})
+}
+
+// Widgets keep their ID in their data, as models do.
+type widget struct {
+	ID        string    `firestore:"id"`
+	Name      string    `firestore:"name"`
+	Rank      int64     `firestore:"rank"`
+	Active    bool      `firestore:"active"`
+	CreatedAt time.Time `firestore:"created_at"`
+	Tags      []string  `firestore:"tags"`
+}
+
+var epoch = time.Date(2022, 8, 1, 0, 0, 0, 0, time.UTC)
+
+// seedWidgets stores widgets a through e, plus f, which only has a name.
+func seedWidgets(t *testing.T, db *DB) {
+	t.Helper()
+
+	widgets := map[string]widget{
+		"a": {Name: "alpha", Rank: 3, Active: true, CreatedAt: epoch, Tags: []string{"red", "round"}},
+		"b": {Name: "bravo", Rank: 1, Active: false, CreatedAt: epoch.Add(time.Hour), Tags: []string{"blue"}},
+		"c": {Name: "charlie", Rank: 2, Active: true, CreatedAt: epoch.Add(2 * time.Hour), Tags: []string{"red"}},
+		"d": {Name: "delta", Rank: 2, Active: false, CreatedAt: epoch.Add(3 * time.Hour)},
+		"e": {Name: "echo", Rank: 5, Active: true, CreatedAt: epoch.Add(4 * time.Hour), Tags: []string{"green", "round"}},
+	}
+
+	batch := db.Batch()
+	for id, widget := range widgets {
+		widget.ID = id
+		batch.Create(db.Collection("widgets").Doc(id), widget)
+	}
+
+	batch.Create(db.Collection("widgets").Doc("f"), map[string]any{"name": "foxtrot"})
+	if err := batch.Commit(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func ids(t *testing.T, query Query) []string {
+	t.Helper()
+
+	snapshots, err := query.Documents(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return lo.Map(snapshots, func(snapshot Snapshot, _ int) string { return snapshot.ID() })
+}
+
+func fetchWidget(t *testing.T, db *DB, id string) widget {
+	t.Helper()
+
+	snapshot, err := db.Collection("widgets").Doc(id).Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var w widget
+	if err := snapshot.DataTo(&w); err != nil {
+		t.Fatal(err)
+	}
+
+	return w
+}
+
+func testQuery(t *testing.T, db *DB) {
+	seedWidgets(t, db)
+	widgets := db.Collection("widgets")
+
+	tests := []struct {
+		name  string
+		query Query
+		want  []string
+	}{
+		{"all", widgets.Query, []string{"a", "b", "c", "d", "e", "f"}},
+		{"equal", widgets.Where("name", "==", "charlie"), []string{"c"}},
+		{"equal id", widgets.Where("id", "==", "d"), []string{"d"}},
+		{"not equal", widgets.Where("rank", "!=", 2), []string{"a", "b", "e"}},
+		{"less than", widgets.Where("rank", "<", 3), []string{"b", "c", "d"}},
+		{"at least", widgets.Where("rank", ">=", 3), []string{"a", "e"}},
+		{"bool", widgets.Where("active", "==", true), []string{"a", "c", "e"}},
+		{"time", widgets.Where("created_at", ">", epoch.Add(2*time.Hour)), []string{"d", "e"}},
+		{"in", widgets.Where("name", "in", []string{"alpha", "echo", "zulu"}), []string{"a", "e"}},
+		{"in nothing", widgets.Where("name", "in", []string{}), []string{}},
+		{"not in", widgets.Where("rank", "not-in", []int{1, 2}), []string{"a", "e"}},
+		{"array contains", widgets.Where("tags", "array-contains", "red"), []string{"a", "c"}},
+		{"array contains any", widgets.Where("tags", "array-contains-any", []string{"blue", "round"}), []string{"a", "b", "e"}},
+		{"filters combine", widgets.Where("active", "==", true).Where("tags", "array-contains", "round"), []string{"a", "e"}},
+		{"order", widgets.OrderBy("rank", Asc), []string{"b", "c", "d", "a", "e"}},
+		{"order desc", widgets.OrderBy("created_at", Desc), []string{"e", "d", "c", "b", "a"}},
+		{"order by two", widgets.OrderBy("rank", Desc).OrderBy("name", Desc), []string{"e", "a", "d", "c", "b"}},
+		{"order by id", widgets.OrderBy(DocumentID, Desc), []string{"f", "e", "d", "c", "b", "a"}},
+		{"where and order", widgets.Where("active", "==", true).OrderBy("created_at", Desc), []string{"e", "c", "a"}},
+		{"limit", widgets.OrderBy("rank", Asc).Limit(2), []string{"b", "c"}},
+		{"limit to last", widgets.OrderBy("rank", Asc).LimitToLast(2), []string{"a", "e"}},
+		{"start after", widgets.OrderBy("rank", Asc).OrderBy("name", Asc).StartAfter(2, "charlie"), []string{"d", "a", "e"}},
+		{"end before", widgets.OrderBy("rank", Asc).OrderBy("name", Asc).EndBefore(2, "delta"), []string{"b", "c"}},
+		{"start after desc", widgets.OrderBy("created_at", Desc).StartAfter(epoch.Add(2 * time.Hour)).Limit(1), []string{"b"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := ids(t, test.query); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func testTransaction(t *testing.T, db *DB) {
+	ctx := context.Background()
+	seedWidgets(t, db)
+	ref := db.Collection("widgets").Doc("a")
+
+	increment := func(ctx context.Context, tx Transaction) error {
+		snapshot, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+
+		var w widget
+		if err := snapshot.DataTo(&w); err != nil {
+			return err
+		}
+
+		tx.Update(ref, []Update{{Path: "rank", Value: w.Rank + 1}})
+		return nil
+	}
+
+	t.Run("commits", func(t *testing.T) {
+		if err := db.RunTransaction(ctx, increment); err != nil {
+			t.Fatal(err)
+		}
+
+		if rank := fetchWidget(t, db, "a").Rank; rank != 4 {
+			t.Errorf("got rank %d, want 4", rank)
+		}
+	})
+
+	t.Run("retries on conflict", func(t *testing.T) {
+		attempts := 0
+		err := db.RunTransaction(ctx, func(ctx context.Context, tx Transaction) error {
+			attempts++
+			if err := increment(ctx, tx); err != nil {
+				return err
+			}
+
+			// Another writer gets in between the first attempt's read and its commit
+			if attempts == 1 {
+				return ref.Update(ctx, []Update{{Path: "rank", Value: 10}})
+			}
+
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if attempts != 2 {
+			t.Errorf("ran %d times, want 2", attempts)
+		}
+
+		if rank := fetchWidget(t, db, "a").Rank; rank != 11 {
+			t.Errorf("got rank %d, want the other write's plus one", rank)
+		}
+	})
+
+	t.Run("retries when a missing document appears", func(t *testing.T) {
+		missing := db.Collection("widgets").Doc("new")
+		attempts := 0
+		err := db.RunTransaction(ctx, func(ctx context.Context, tx Transaction) error {
+			attempts++
+			if _, err := tx.Get(missing); err == nil {
+				return AlreadyExists
+			} else if err != NotFound {
+				return err
+			}
+
+			tx.Create(missing, widget{Name: "mine"})
+			if attempts == 1 {
+				return missing.Create(ctx, widget{Name: "theirs"})
+			}
+
+			return nil
+		})
+		if err != AlreadyExists {
+			t.Errorf("got %v, want %v", err, AlreadyExists)
+		}
+
+		if name := fetchWidget(t, db, "new").Name; name != "theirs" {
+			t.Errorf("got %q, want the other write's", name)
+		}
+	})
+
+	t.Run("gives up", func(t *testing.T) {
+		attempts := 0
+		err := db.RunTransaction(ctx, func(ctx context.Context, tx Transaction) error {
+			attempts++
+			if err := increment(ctx, tx); err != nil {
+				return err
+			}
+
+			return ref.Update(ctx, []Update{{Path: "rank", Value: attempts * 100}})
+		})
+		if errors.Cause(err) != errTransactionConflict {
+			t.Errorf("got %v, want %v", err, errTransactionConflict)
+		}
+
+		if attempts != maxTransactionAttempts {
+			t.Errorf("ran %d times, want %d", attempts, maxTransactionAttempts)
+		}
+	})
+
+	t.Run("bare errors", func(t *testing.T) {
+		err := db.RunTransaction(ctx, func(ctx context.Context, tx Transaction) error {
+			tx.Create(ref, widget{})
+			return nil
+		})
+		if err != AlreadyExists {
+			t.Errorf("create got %v, want %v", err, AlreadyExists)
+		}
+
+		err = db.RunTransaction(ctx, func(ctx context.Context, tx Transaction) error {
+			tx.Update(db.Collection("widgets").Doc("missing"), []Update{{Path: "rank", Value: 1}})
+			return nil
+		})
+		if err != NotFound {
+			t.Errorf("update got %v, want %v", err, NotFound)
+		}
+
+		failed := errors.New("failed")
+		err = db.RunTransaction(ctx, func(ctx context.Context, tx Transaction) error {
+			tx.Delete(ref)
+			return failed
+		})
+		if err != failed {
+			t.Errorf("got %v, want the function's error", err)
+		}
+
+		if _, err := ref.Get(ctx); err != nil {
+			t.Errorf("failed transaction wrote: %v", err)
+		}
+	})
+}
+
+func testBatch(t *testing.T, db *DB) {
+	ctx := context.Background()
+	seedWidgets(t, db)
+	widgets := db.Collection("widgets")
+
+	t.Run("commits together", func(t *testing.T) {
+		batch := db.Batch()
+		batch.Create(widgets.Doc("g"), widget{Name: "golf", Rank: 7, Tags: []string{"red"}})
+		batch.Set(widgets.Doc("c"), widget{Name: "charlie", Rank: 9})
+		batch.Update(widgets.Doc("a"), []Update{{Path: "rank", Value: 8}, {Path: "tags", Value: ArrayRemove("red")}})
+		batch.Update(widgets.Doc("b"), []Update{{Path: "tags", Value: ArrayUnion("red", "blue")}})
+		batch.Delete(widgets.Doc("d"))
+		batch.Delete(widgets.Doc("missing"))
+		if err := batch.Commit(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		if got, want := ids(t, widgets.OrderBy("rank", Desc)), []string{"c", "a", "g", "e", "b"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+
+		if got, want := ids(t, widgets.Where("tags", "array-contains", "red")), []string{"b", "g"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+
+		if tags := fetchWidget(t, db, "b").Tags; !reflect.DeepEqual(tags, []string{"blue", "red"}) {
+			t.Errorf("got tags %v, want the union without repeats", tags)
+		}
+	})
+
+	t.Run("fails together", func(t *testing.T) {
+		for _, test := range []struct {
+			name  string
+			write func(batch Batch)
+			want  error
+		}{
+			{"create existing", func(batch Batch) { batch.Create(widgets.Doc("a"), widget{}) }, AlreadyExists},
+			{"update missing", func(batch Batch) { batch.Update(widgets.Doc("missing"), []Update{{Path: "rank", Value: 1}}) }, NotFound},
+		} {
+			batch := db.Batch()
+			batch.Set(widgets.Doc("e"), widget{Name: "overwritten"})
+			test.write(batch)
+			if err := batch.Commit(ctx); err != test.want {
+				t.Errorf("%s: got %v, want %v", test.name, err, test.want)
+			}
+
+			if name := fetchWidget(t, db, "e").Name; name != "echo" {
+				t.Errorf("%s: failed batch wrote %q", test.name, name)
+			}
+		}
+	})
+}
+
+// testCreateMessage sends to one channel from each of dbs at once, which should still number messages with no gaps
+// or ties.
+func testCreateMessage(t *testing.T, dbs []*DB) {
+	ctx := context.Background()
+	channel := model.Channel{ID: xid.New().String(), CreatedAt: time.Now(), Name: "busy"}
+	if err := dbs[0].CollectionFor(channel.Type()).Doc(channel.ID).Create(ctx, channel); err != nil {
+		t.Fatal(err)
+	}
+
+	const messages = 20
+	seqs := make(chan int64, messages)
+	var wg sync.WaitGroup
+	for i := 0; i < messages; i++ {
+		wg.Add(1)
+		go func(db *DB) {
+			defer wg.Done()
+
+			message := model.Message{ID: xid.New().String(), CreatedAt: time.Now(), ChannelID: channel.ID, UserID: "sender", Body: "hi"}
+			message, _, err := CreateMessage(ctx, db, message)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			seqs <- message.Seq
+		}(dbs[i%len(dbs)])
+	}
+
+	wg.Wait()
+	close(seqs)
+
+	var got []int
+	for seq := range seqs {
+		got = append(got, int(seq))
+	}
+
+	sort.Ints(got)
+	if len(got) != messages {
+		t.Fatalf("got seqs %v, want %d messages", got, messages)
+	}
+
+	for i, seq := range got {
+		if seq != i+1 {
+			t.Fatalf("got seqs %v, want 1 through %d", got, messages)
+		}
+	}
+
+	channel, err := NewFetcher[model.Channel](dbs[0]).Fetch(ctx, channel.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if channel.LastMessageSeq != messages || channel.LastMessageUserID != "sender" {
+		t.Errorf("got last message %d from %q, want %d from the sender", channel.LastMessageSeq, channel.LastMessageUserID, messages)
+	}
+}
@@ -0,0 +1,137 @@
+package event
+
+import (
+	_ "embed"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+// Version is bumped whenever an envelope or payload changes in a way old clients can't read.
+const Version = 1
+
+type Type string
+
+const (
+	MessageCreated      Type = "message.created"
+	MessageUpdated      Type = "message.updated"
+	MessageDeleted      Type = "message.deleted"
+	ChannelUpdated      Type = "channel.updated"
+	MemberJoined        Type = "member.joined"
+	MemberLeft          Type = "member.left"
+	UnreadUpdated       Type = "unread.updated"
+	NotificationCreated Type = "notification.created"
+	NotificationUpdated Type = "notification.updated"
+	TypingStarted       Type = "typing.started"
+	TypingStopped       Type = "typing.stopped"
+	PresenceUpdated     Type = "presence.updated"
+	RefetchRequired     Type = "refetch.required"
+
+	// Session socket replies.
+	Subscribed   Type = "subscribed"
+	Unsubscribed Type = "unsubscribed"
+	Ack          Type = "ack"
+	Error        Type = "error"
+)
+
+// Schema is the JSON schema for Envelope and every payload, served to clients that generate their types from it.
+//
+//go:embed schema.json
+var Schema []byte
+
+// Envelope is what every realtime socket writes. Payload depends on Type:
+//
+//	message.created, message.updated  model.Message
+//	message.deleted                   MessageRef
+//	channel.updated                   model.Channel
+//	member.joined, member.left        Member
+//	unread.updated                    Unread
+//	notification.created              model.Notification
+//	notification.updated              model.Notification
+//	typing.started, typing.stopped    Typing
+//	presence.updated                  Presence
+//	refetch.required                  Refetch
+//	ack                               AckPayload
+//	error                             ErrorPayload
+//	subscribed, unsubscribed          none
+type Envelope struct {
+	Version   int       `json:"v"`
+	ID        string    `json:"id"`
+	Type      Type      `json:"type"`
+	TS        time.Time `json:"ts"`
+	ChannelID string    `json:"channelID,omitempty"`
+	Payload   any       `json:"payload,omitempty"`
+}
+
+func New(eventType Type, channelID string, payload any) Envelope {
+	return Envelope{
+		Version:   Version,
+		ID:        xid.New().String(),
+		Type:      eventType,
+		TS:        time.Now(),
+		ChannelID: channelID,
+		Payload:   payload,
+	}
+}
+
+// NewError builds an error event, scoped to channelID if it's set.
+func NewError(channelID, message string) Envelope {
+	return New(Error, channelID, ErrorPayload{Message: message})
+}
+
+// NewSendError builds the error event for a failed send, referencing the nonce the client sent it with.
+func NewSendError(channelID, nonce, message string) Envelope {
+	return New(Error, channelID, ErrorPayload{Message: message, Nonce: nonce})
+}
+
+// MessageRef identifies a message that no longer exists.
+type MessageRef struct {
+	MessageID string `json:"messageID"`
+	ChannelID string `json:"channelID"`
+}
+
+// Member is a user joining or leaving a channel.
+type Member struct {
+	ChannelID string `json:"channelID"`
+	UserID    string `json:"userID"`
+	Role      string `json:"role,omitempty"`
+}
+
+// Unread is a user's unread counts for a channel.
+type Unread struct {
+	ChannelID         string `json:"channelID"`
+	LastReadMessageID string `json:"lastReadMessageID"`
+	UnreadCount       int    `json:"unreadCount"`
+	MentionCount      int    `json:"mentionCount"`
+}
+
+// Typing is a user starting or stopping typing in a channel. It's never stored.
+type Typing struct {
+	ChannelID string `json:"channelID"`
+	UserID    string `json:"userID"`
+}
+
+// Presence is where a user stands: online, away, idle or offline. AwayMessage is set while they're away.
+type Presence struct {
+	UserID      string `json:"userID"`
+	Status      string `json:"status"`
+	AwayMessage string `json:"awayMessage,omitempty"`
+}
+
+// Refetch tells a client it missed more of a channel than can be replayed, so it should fetch it again.
+type Refetch struct {
+	ChannelID string `json:"channelID"`
+	Reason    string `json:"reason"`
+}
+
+// AckPayload confirms a send, referencing the nonce the client sent it with and the message it was stored as.
+type AckPayload struct {
+	Nonce     string `json:"nonce,omitempty"`
+	MessageID string `json:"messageID"`
+	Seq       int64  `json:"seq"`
+}
+
+type ErrorPayload struct {
+	Message string `json:"message"`
+	Nonce   string `json:"nonce,omitempty"`
+}
@@ -0,0 +1,414 @@
+package format
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// maxDepth is how deeply formatting can nest. Tags past it are dropped, though their contents are kept.
+const maxDepth = 16
+
+type Kind string
+
+const (
+	KindText      Kind = "text"
+	KindBold      Kind = "bold"
+	KindItalic    Kind = "italic"
+	KindUnderline Kind = "underline"
+	KindColor     Kind = "color"
+	KindLink      Kind = "link"
+	KindBreak     Kind = "break"
+)
+
+// Node is a piece of a formatted message. Only text nodes have text, and only color and link nodes have a color or
+// href, which Parse has already checked are safe to render.
+type Node struct {
+	Kind     Kind   `json:"kind"`
+	Text     string `json:"text,omitempty"`
+	Color    string `json:"color,omitempty"`
+	Href     string `json:"href,omitempty"`
+	Children []Node `json:"children,omitempty"`
+}
+
+// Parse reads a message written with AIM's HTML subset, a little Markdown, or both. The tags it knows are b, strong,
+// i, em, u, font with a color, a with an http, https or mailto href, and br; html and body, which AIM wrapped
+// messages in, are ignored. Any other tag is kept as the text it was written as. Within text, **bold**, *italic*,
+// _italic_, __underline__ and [links](https://example.com) are formatted, bare URLs are linked, and a backslash
+// escapes the character after it.
+func Parse(source string) []Node {
+	root := &Node{}
+	stack := []frame{{node: root}}
+
+	tokenizer := html.NewTokenizer(strings.NewReader(source))
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			break
+		}
+
+		raw := string(tokenizer.Raw())
+		token := tokenizer.Token()
+		top := stack[len(stack)-1]
+		depth := len(stack) - 1
+
+		switch tokenType {
+		case html.TextToken:
+			top.node.Children = append(top.node.Children, inline(token.Data, depth)...)
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if token.DataAtom == atom.Br {
+				top.node.Children = append(top.node.Children, Node{Kind: KindBreak})
+				continue
+			}
+
+			node, known := tagNode(token)
+			if !known {
+				top.node.Children = append(top.node.Children, textNode(raw))
+				continue
+			}
+
+			if tokenType == html.SelfClosingTagToken || depth >= maxDepth {
+				continue
+			}
+
+			// Tags that don't format anything, like an unsafe link, leave their contents to the tag they're in
+			parent := top.node
+			if node != nil {
+				parent.Children = append(parent.Children, *node)
+				parent = &parent.Children[len(parent.Children)-1]
+			}
+
+			stack = append(stack, frame{atom: token.DataAtom, node: parent})
+
+		case html.EndTagToken:
+			if _, known := tagNode(token); !known {
+				top.node.Children = append(top.node.Children, textNode(raw))
+				continue
+			}
+
+			// Closing a tag closes everything opened inside it; closing one that isn't open does nothing
+			for i := len(stack) - 1; i > 0; i-- {
+				if sameTag(stack[i].atom, token.DataAtom) {
+					stack = stack[:i]
+					break
+				}
+			}
+
+		default:
+			top.node.Children = append(top.node.Children, textNode(raw))
+		}
+	}
+
+	return normalize(root.Children, false)
+}
+
+// frame is an open tag, and the node its contents go in.
+type frame struct {
+	atom atom.Atom
+	node *Node
+}
+
+// tagNode returns the node a tag opens, or nil for a tag that's known but doesn't format, like font without a valid
+// color. Unknown tags aren't known.
+func tagNode(token html.Token) (*Node, bool) {
+	switch token.DataAtom {
+	case atom.B, atom.Strong:
+		return &Node{Kind: KindBold}, true
+
+	case atom.I, atom.Em:
+		return &Node{Kind: KindItalic}, true
+
+	case atom.U:
+		return &Node{Kind: KindUnderline}, true
+
+	case atom.Font:
+		if color, ok := Color(attr(token, "color")); ok {
+			return &Node{Kind: KindColor, Color: color}, true
+		}
+
+		return nil, true
+
+	case atom.A:
+		if href, ok := Href(attr(token, "href")); ok {
+			return &Node{Kind: KindLink, Href: href}, true
+		}
+
+		return nil, true
+
+	case atom.Html, atom.Body:
+		return nil, true
+	}
+
+	return nil, false
+}
+
+func sameTag(open, close atom.Atom) bool {
+	return open == close || (open == atom.Strong && close == atom.B) || (open == atom.B && close == atom.Strong) ||
+		(open == atom.Em && close == atom.I) || (open == atom.I && close == atom.Em)
+}
+
+func attr(token html.Token, key string) string {
+	for _, attr := range token.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+
+	return ""
+}
+
+var (
+	hexColorPattern = regexp.MustCompile(`^#([0-9a-f]{3}|[0-9a-f]{6})$`)
+
+	namedColors = map[string]string{
+		"black":   "#000000",
+		"white":   "#ffffff",
+		"gray":    "#808080",
+		"grey":    "#808080",
+		"silver":  "#c0c0c0",
+		"red":     "#ff0000",
+		"maroon":  "#800000",
+		"orange":  "#ffa500",
+		"yellow":  "#ffff00",
+		"olive":   "#808000",
+		"lime":    "#00ff00",
+		"green":   "#008000",
+		"aqua":    "#00ffff",
+		"teal":    "#008080",
+		"blue":    "#0000ff",
+		"navy":    "#000080",
+		"fuchsia": "#ff00ff",
+		"purple":  "#800080",
+	}
+)
+
+// Color normalizes a font color, either a hex code or one of the HTML 4 color names, to a six digit hex code.
+func Color(color string) (string, bool) {
+	color = strings.ToLower(strings.TrimSpace(color))
+	if named, ok := namedColors[color]; ok {
+		return named, true
+	}
+
+	if !hexColorPattern.MatchString(color) {
+		return "", false
+	}
+
+	if len(color) == 4 {
+		color = string([]byte{'#', color[1], color[1], color[2], color[2], color[3], color[3]})
+	}
+
+	return color, true
+}
+
+// Href normalizes a link's URL, allowing only absolute http, https and mailto ones.
+func Href(href string) (string, bool) {
+	u, err := url.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return "", false
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		if u.Host == "" {
+			return "", false
+		}
+
+	case "mailto":
+		if u.Opaque == "" {
+			return "", false
+		}
+
+	default:
+		return "", false
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	return u.String(), true
+}
+
+// inline parses the Markdown in a run of text.
+func inline(text string, depth int) []Node {
+	var nodes []Node
+	var plain strings.Builder
+	flush := func() {
+		if plain.Len() > 0 {
+			nodes = append(nodes, textNode(plain.String()))
+			plain.Reset()
+		}
+	}
+
+	for i := 0; i < len(text); {
+		rest := text[i:]
+		if node, n, ok := markdownNode(text, i, depth); ok {
+			flush()
+			nodes = append(nodes, node)
+			i += n
+			continue
+		}
+
+		switch {
+		case rest[0] == '\\' && len(rest) > 1 && strings.ContainsRune(escapable, rune(rest[1])):
+			plain.WriteByte(rest[1])
+			i += 2
+
+		case rest[0] == '\n':
+			flush()
+			nodes = append(nodes, Node{Kind: KindBreak})
+			i++
+
+		default:
+			_, size := utf8.DecodeRuneInString(rest)
+			plain.WriteString(rest[:size])
+			i += size
+		}
+	}
+
+	flush()
+	return nodes
+}
+
+// escapable is what a backslash can escape.
+const escapable = `\*_[]()`
+
+var urlPattern = regexp.MustCompile(`^https?://[^\s<>"]+`)
+
+// markdownNode parses the Markdown starting at text[i], if any, returning the node and how much of text it took up.
+func markdownNode(text string, i, depth int) (Node, int, bool) {
+	rest := text[i:]
+	if urlPattern.MatchString(rest) && (i == 0 || !isWordRune(lastRune(text[:i]))) {
+		link := strings.TrimRight(urlPattern.FindString(rest), ".,:;!?'")
+		if strings.HasSuffix(link, ")") && strings.Count(link, "(") < strings.Count(link, ")") {
+			link = link[:len(link)-1]
+		}
+
+		if href, ok := Href(link); ok {
+			return Node{Kind: KindLink, Href: href, Children: []Node{textNode(link)}}, len(link), true
+		}
+	}
+
+	if depth >= maxDepth {
+		return Node{}, 0, false
+	}
+
+	for _, delimiter := range []struct {
+		marker string
+		kind   Kind
+	}{
+		{"**", KindBold},
+		{"__", KindUnderline},
+		{"*", KindItalic},
+		{"_", KindItalic},
+	} {
+		if !strings.HasPrefix(rest, delimiter.marker) {
+			continue
+		}
+
+		// Underscores inside words, like snake_case, aren't formatting
+		if delimiter.marker[0] == '_' && i > 0 && isWordRune(lastRune(text[:i])) {
+			continue
+		}
+
+		body := rest[len(delimiter.marker):]
+		end := closingDelimiter(body, delimiter.marker)
+		if end <= 0 || unicode.IsSpace(firstRune(body)) || unicode.IsSpace(lastRune(body[:end])) {
+			continue
+		}
+
+		n := len(delimiter.marker)*2 + end
+		if delimiter.marker[0] == '_' && i+n < len(text) && isWordRune(firstRune(text[i+n:])) {
+			continue
+		}
+
+		return Node{Kind: delimiter.kind, Children: inline(body[:end], depth+1)}, n, true
+	}
+
+	if rest[0] == '[' {
+		closeText := strings.Index(rest, "](")
+		if closeText > 1 {
+			closeURL := strings.IndexByte(rest[closeText+2:], ')')
+			if closeURL > 0 {
+				if href, ok := Href(rest[closeText+2 : closeText+2+closeURL]); ok {
+					return Node{Kind: KindLink, Href: href, Children: inline(rest[1:closeText], depth+1)}, closeText + 3 + closeURL, true
+				}
+			}
+		}
+	}
+
+	return Node{}, 0, false
+}
+
+// closingDelimiter finds where marker closes in body, skipping escaped characters. A single * or _ isn't closed by
+// a double one.
+func closingDelimiter(body, marker string) int {
+	for i := 0; i < len(body); i++ {
+		switch {
+		case body[i] == '\\':
+			i++
+		case body[i] == '\n':
+			return -1
+		case strings.HasPrefix(body[i:], marker):
+			if len(marker) == 1 && i+1 < len(body) && body[i+1] == marker[0] {
+				i++
+				continue
+			}
+
+			return i
+		}
+	}
+
+	return -1
+}
+
+// normalize joins adjacent text nodes, which the tokenizer splits text into around things like unknown tags, and
+// replaces links inside links with their contents, since links can't nest.
+func normalize(nodes []Node, inLink bool) []Node {
+	normalized := make([]Node, 0, len(nodes))
+	for _, node := range nodes {
+		if inLink && node.Kind == KindLink {
+			normalized = appendText(normalized, normalize(node.Children, true)...)
+			continue
+		}
+
+		node.Children = normalize(node.Children, inLink || node.Kind == KindLink)
+		normalized = appendText(normalized, node)
+	}
+
+	return normalized
+}
+
+func appendText(nodes []Node, more ...Node) []Node {
+	for _, node := range more {
+		if n := len(nodes); n > 0 && node.Kind == KindText && nodes[n-1].Kind == KindText {
+			nodes[n-1].Text += node.Text
+			continue
+		}
+
+		nodes = append(nodes, node)
+	}
+
+	return nodes
+}
+
+func textNode(text string) Node {
+	return Node{Kind: KindText, Text: text}
+}
+
+func firstRune(s string) rune {
+	r, _ := utf8.DecodeRuneInString(s)
+	return r
+}
+
+func lastRune(s string) rune {
+	r, _ := utf8.DecodeLastRuneInString(s)
+	return r
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,218 @@
+package format
+
+import (
+	stdhtml "html"
+	"net/url"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// link is how HTML renders a link's opening tag.
+func link(href string) string {
+	return `<a href="` + href + `" target="_blank" rel="nofollow noopener noreferrer">`
+}
+
+func render(source string) string {
+	return HTML(Parse(source), func(text string) string { return text })
+}
+
+func TestHTML_AIM(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"plain text", "hello", "hello"},
+		{"bold", "<b>hi</b> <strong>there</strong>", "<b>hi</b> <b>there</b>"},
+		{"italic", "<i>hi</i> <em>there</em>", "<i>hi</i> <i>there</i>"},
+		{"underline", "<u>hi</u>", "<u>hi</u>"},
+		{"named color", "<font color=red>hi</font>", `<span style="color: #ff0000">hi</span>`},
+		{"short hex color", `<font color="#AbC">hi</font>`, `<span style="color: #aabbcc">hi</span>`},
+		{"invalid color", `<font color="expression(alert(1))">hi</font>`, "hi"},
+		{"font without color", `<font face="Arial">hi</font>`, "hi"},
+		{"link", `<a href="https://example.com">hi</a>`, link("https://example.com") + "hi</a>"},
+		{"mailto link", `<a href="mailto:bob@example.com">bob</a>`, link("mailto:bob@example.com") + "bob</a>"},
+		{"javascript link", `<a href="javascript:alert(1)">hi</a>`, "hi"},
+		{"relative link", `<a href="/settings">hi</a>`, "hi"},
+		{"link scheme case", `<a href=" HTTPS://example.com">hi</a>`, link("https://example.com") + "hi</a>"},
+		{"breaks", "a<br>b<br/>c\nd", "a<br>b<br>c<br>d"},
+		{"aim wrapper", `<HTML><BODY BGCOLOR="#ffffff"><FONT COLOR="#0000ff">hi <B>there</B></FONT></BODY></HTML>`, `<span style="color: #0000ff">hi <b>there</b></span>`},
+		{"misnested tags", "<b>bold <i>both</b> after</i>", "<b>bold <i>both</i></b> after"},
+		{"unclosed tag", "<b>bold", "<b>bold</b>"},
+		{"stray close tag", "plain</b>", "plain"},
+		{"unknown tags kept as text", "<script>alert(1)</script>", "&lt;script&gt;alert(1)&lt;/script&gt;"},
+		{"unknown attributes dropped", `<b onclick="alert(1)">hi</b>`, "<b>hi</b>"},
+		{"comments kept as text", "<!-- hi -->", "&lt;!-- hi --&gt;"},
+		{"entities", "&lt;b&gt; &amp; a < b", "&lt;b&gt; &amp; a &lt; b"},
+		{"nested links flattened", `<a href="https://a.com">in <a href="https://b.com">b</a></a>`, link("https://a.com") + "in b</a>"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := render(test.source); got != test.want {
+				t.Errorf("render(%q) = %q, want %q", test.source, got, test.want)
+			}
+		})
+	}
+}
+
+func TestHTML_Markdown(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"bold", "**hi**", "<b>hi</b>"},
+		{"italic", "*hi* _there_", "<i>hi</i> <i>there</i>"},
+		{"underline", "__hi__", "<u>hi</u>"},
+		{"nested", "**bold *both* more**", "<b>bold <i>both</i> more</b>"},
+		{"snake case", "snake_case_name", "snake_case_name"},
+		{"spaced markers", "a * b * c", "a * b * c"},
+		{"unclosed", "**hi", "**hi"},
+		{"across lines", "*a\nb*", "*a<br>b*"},
+		{"escaped", `\*not\* \_not\_`, "*not* _not_"},
+		{"link", "[click **me**](https://a.com/b)", link("https://a.com/b") + "click <b>me</b></a>"},
+		{"javascript link", "[bad](javascript:alert(1))", "[bad](javascript:alert(1))"},
+		{"autolink", "see https://example.com.", "see " + link("https://example.com") + "https://example.com</a>."},
+		{"autolink with parens", "(https://example.com/a_(b))", "(" + link("https://example.com/a_(b)") + "https://example.com/a_(b)</a>)"},
+		{"autolink in word", "xhttps://example.com", "xhttps://example.com"},
+		{"autolink inside link", `<a href="https://a.com">https://b.com</a>`, link("https://a.com") + "https://b.com</a>"},
+		{"mixed with html", "<b>**both**</b>", "<b><b>both</b></b>"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := render(test.source); got != test.want {
+				t.Errorf("render(%q) = %q, want %q", test.source, got, test.want)
+			}
+		})
+	}
+}
+
+func TestHTML_Censor(t *testing.T) {
+	got := HTML(Parse("<b>darn</b> <a href=\"https://darn.com\">darn</a>"), func(text string) string {
+		return strings.ReplaceAll(text, "darn", "****")
+	})
+
+	want := "<b>****</b> " + link("https://darn.com") + "****</a>"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestHTML_Depth(t *testing.T) {
+	source := strings.Repeat("<b>", maxDepth*4) + "deep" + strings.Repeat("</b>", maxDepth*4)
+	if got := strings.Count(render(source), "<b>"); got != maxDepth {
+		t.Errorf("got %d levels, want %d", got, maxDepth)
+	}
+}
+
+func TestPlain(t *testing.T) {
+	source := "<b>hi</b> **there**<br>[link](https://a.com) &amp; <script>"
+	if got, want := Plain(Parse(source)), "hi there\nlink & <script>"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestLinks(t *testing.T) {
+	source := `https://a.com [b](https://b.com) <a href="https://a.com">again</a> <a href="mailto:c@d.com">mail</a> **https://c.com**`
+	got := Links(Parse(source))
+	want := []string{"https://a.com", "https://b.com", "https://c.com"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func FuzzHTML(f *testing.F) {
+	for _, seed := range []string{
+		"hello **bold** and *it* and _it_ and __under__ snake_case_name",
+		`<b>bold <i>both</b> after</i> <font color=red>red</font> <font color="expression(x)">bad</font>`,
+		`<a href="javascript:alert(1)">js</a> <a href="https://x.com/?a=1&b=\"2">ok</a>`,
+		`<script>alert(1)</script><img src=x onerror=alert(1)><!-- c --> <3 a < b`,
+		`see https://example.com/path_(x). and [click **me**](https://a.b/c) [bad](javascript:x) \*not\*`,
+		`<a href=https://a.com>outer https://b.com [in](https://c.com)</a>`,
+		`<a href=" javascript:alert(1)">x</a><a href="jav&#x09;ascript:alert(1)">y</a>`,
+		`<font color="red" onmouseover="alert(1)">x</font><b style="x">y</b>`,
+		"<svg><script>alert(1)</script></svg><iframe src=javascript:alert(1)>",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, source string) {
+		checkSafe(t, source, render(source))
+	})
+}
+
+// checkSafe fails t unless output is made of only the tags and attributes HTML renders, with safe values, and text
+// that's fully escaped.
+func checkSafe(t *testing.T, source, output string) {
+	t.Helper()
+
+	lower := strings.ToLower(output)
+	if strings.Contains(lower, "<script") {
+		t.Fatalf("render(%q) = %q, which has a script tag", source, output)
+	}
+
+	tokenizer := html.NewTokenizer(strings.NewReader(output))
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			return
+		}
+
+		raw := string(tokenizer.Raw())
+		token := tokenizer.Token()
+		switch tokenType {
+		case html.TextToken:
+			// The tokenizer normalizes newlines in text, as browsers do
+			raw = strings.NewReplacer("\r\n", "\n", "\r", "\n").Replace(raw)
+			if stdhtml.EscapeString(token.Data) != raw {
+				t.Fatalf("render(%q) = %q, which has unescaped text %q", source, output, raw)
+			}
+
+		case html.StartTagToken, html.EndTagToken, html.SelfClosingTagToken:
+			switch token.DataAtom {
+			case atom.B, atom.I, atom.U, atom.Span, atom.A, atom.Br:
+			default:
+				t.Fatalf("render(%q) = %q, which has tag %q", source, output, token.Data)
+			}
+
+			for _, attr := range token.Attr {
+				checkAttr(t, source, output, token, attr)
+			}
+
+		default:
+			t.Fatalf("render(%q) = %q, which has %v %q", source, output, tokenType, raw)
+		}
+	}
+}
+
+func checkAttr(t *testing.T, source, output string, token html.Token, attr html.Attribute) {
+	t.Helper()
+
+	if strings.HasPrefix(attr.Key, "on") {
+		t.Fatalf("render(%q) = %q, which has event handler %q", source, output, attr.Key)
+	}
+
+	switch {
+	case token.DataAtom == atom.A && attr.Key == "href":
+		href, err := url.Parse(attr.Val)
+		if err != nil || !(href.Scheme == "http" || href.Scheme == "https" || href.Scheme == "mailto") {
+			t.Fatalf("render(%q) = %q, which has href %q", source, output, attr.Val)
+		}
+
+	case token.DataAtom == atom.A && attr.Key == "target" && attr.Val == "_blank":
+	case token.DataAtom == atom.A && attr.Key == "rel" && attr.Val == "nofollow noopener noreferrer":
+
+	case token.DataAtom == atom.Span && attr.Key == "style":
+		if _, ok := Color(strings.TrimPrefix(attr.Val, "color: ")); !ok || !strings.HasPrefix(attr.Val, "color: #") {
+			t.Fatalf("render(%q) = %q, which has style %q", source, output, attr.Val)
+		}
+
+	default:
+		t.Fatalf("render(%q) = %q, which has attribute %s=%q on %s", source, output, attr.Key, attr.Val, token.Data)
+	}
+}
@@ -0,0 +1,100 @@
+package format
+
+import (
+	"html"
+	"strings"
+)
+
+// HTML renders nodes as HTML that's safe to put straight into a page. All text goes through censor first, and is
+// escaped after. Links open in a new tab, without passing along the page they were opened from.
+func HTML(nodes []Node, censor func(string) string) string {
+	var b strings.Builder
+	writeHTML(&b, nodes, censor)
+	return b.String()
+}
+
+func writeHTML(b *strings.Builder, nodes []Node, censor func(string) string) {
+	for _, node := range nodes {
+		switch node.Kind {
+		case KindText:
+			b.WriteString(html.EscapeString(censor(node.Text)))
+
+		case KindBreak:
+			b.WriteString("<br>")
+
+		case KindBold:
+			wrapHTML(b, "<b>", "</b>", node.Children, censor)
+
+		case KindItalic:
+			wrapHTML(b, "<i>", "</i>", node.Children, censor)
+
+		case KindUnderline:
+			wrapHTML(b, "<u>", "</u>", node.Children, censor)
+
+		case KindColor:
+			// Nodes built by hand haven't been through Parse, so the color and href are checked again
+			if color, ok := Color(node.Color); ok {
+				wrapHTML(b, `<span style="color: `+color+`">`, "</span>", node.Children, censor)
+			} else {
+				writeHTML(b, node.Children, censor)
+			}
+
+		case KindLink:
+			if href, ok := Href(node.Href); ok {
+				wrapHTML(b, `<a href="`+html.EscapeString(href)+`" target="_blank" rel="nofollow noopener noreferrer">`, "</a>", node.Children, censor)
+			} else {
+				writeHTML(b, node.Children, censor)
+			}
+		}
+	}
+}
+
+func wrapHTML(b *strings.Builder, open, close string, children []Node, censor func(string) string) {
+	b.WriteString(open)
+	writeHTML(b, children, censor)
+	b.WriteString(close)
+}
+
+// Plain is the text of nodes without their formatting, with breaks as newlines.
+func Plain(nodes []Node) string {
+	var b strings.Builder
+	writePlain(&b, nodes)
+	return b.String()
+}
+
+func writePlain(b *strings.Builder, nodes []Node) {
+	for _, node := range nodes {
+		switch node.Kind {
+		case KindText:
+			b.WriteString(node.Text)
+		case KindBreak:
+			b.WriteByte('\n')
+		default:
+			writePlain(b, node.Children)
+		}
+	}
+}
+
+// Links is the http and https links in nodes, in the order they appear, each once.
+func Links(nodes []Node) []string {
+	var links []string
+	seen := make(map[string]bool)
+	var walk func(nodes []Node)
+	walk = func(nodes []Node) {
+		for _, node := range nodes {
+			if node.Kind != KindLink {
+				walk(node.Children)
+				continue
+			}
+
+			href, ok := Href(node.Href)
+			if ok && !strings.HasPrefix(href, "mailto:") && !seen[href] {
+				seen[href] = true
+				links = append(links, href)
+			}
+		}
+	}
+
+	walk(nodes)
+	return links
+}
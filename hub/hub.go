@@ -0,0 +1,192 @@
+package hub
+
+import (
+	"context"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+const DefaultBufferSize = 64
+
+// Source feeds a topic from upstream, calling publish for each event until ctx is done. Returning ends the topic.
+type Source func(ctx context.Context, publish func(event any)) error
+
+// Hub fans events out to local clients. Each topic has one upstream Source, started by its first client and
+// stopped when its last client leaves, no matter how many clients are subscribed.
+type Hub struct {
+	logger     *zap.Logger
+	bufferSize int
+
+	mu     sync.Mutex
+	topics map[string]*topic
+}
+
+type topic struct {
+	key    string
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	clients map[*Client]struct{}
+	ended   bool
+}
+
+// Client receives a topic's events. Events is closed when the client is closed, when the topic's source ends, or
+// when the client falls a full buffer behind, in which case Dropped reports true.
+type Client struct {
+	hub    *Hub
+	topic  *topic
+	events chan any
+
+	mu      sync.Mutex
+	closed  bool
+	dropped bool
+}
+
+func New(logger *zap.Logger, bufferSize int) *Hub {
+	if bufferSize <= 0 {
+		bufferSize = DefaultBufferSize
+	}
+
+	return &Hub{logger: logger, bufferSize: bufferSize, topics: make(map[string]*topic)}
+}
+
+// Subscribe adds a client to the topic for key, starting source if the topic isn't running yet.
+func (h *Hub) Subscribe(key string, source Source) *Client {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for {
+		t, ok := h.topics[key]
+		if !ok {
+			ctx, cancel := context.WithCancel(context.Background())
+			t = &topic{key: key, cancel: cancel, clients: make(map[*Client]struct{})}
+			h.topics[key] = t
+
+			go h.run(ctx, t, source)
+		}
+
+		t.mu.Lock()
+		if t.ended {
+			// The last client just left and the source is winding down, so start over with a fresh topic
+			t.mu.Unlock()
+			delete(h.topics, key)
+			continue
+		}
+
+		client := &Client{hub: h, topic: t, events: make(chan any, h.bufferSize)}
+		t.clients[client] = struct{}{}
+		t.mu.Unlock()
+		return client
+	}
+}
+
+// Publish hands event to the clients of the topic for key alongside what its source publishes, for events that
+// don't come from upstream. It does nothing if no one is subscribed.
+func (h *Hub) Publish(key string, event any) {
+	h.mu.Lock()
+	t, ok := h.topics[key]
+	h.mu.Unlock()
+
+	if ok {
+		h.publish(t, event)
+	}
+}
+
+// Stats reports how many topics are running and how many clients they're serving.
+func (h *Hub) Stats() (topics, clients int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, t := range h.topics {
+		t.mu.Lock()
+		clients += len(t.clients)
+		t.mu.Unlock()
+	}
+
+	return len(h.topics), clients
+}
+
+func (h *Hub) run(ctx context.Context, t *topic, source Source) {
+	logger := h.logger.With(zap.String("topic", t.key))
+	logger.Debug("topic started")
+
+	if err := source(ctx, func(event any) { h.publish(t, event) }); err != nil && ctx.Err() == nil {
+		logger.Error("topic source failed", zap.Error(err))
+	}
+
+	h.end(t)
+	logger.Debug("topic ended")
+}
+
+// publish hands event to every client without blocking. Clients whose buffer is full are dropped.
+func (h *Hub) publish(t *topic, event any) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for client := range t.clients {
+		select {
+		case client.events <- event:
+		default:
+			h.logger.Info("dropping slow client", zap.String("topic", t.key))
+			client.mu.Lock()
+			client.dropped = true
+			client.mu.Unlock()
+			h.remove(t, client)
+		}
+	}
+}
+
+// end closes out every client of t once its source has returned.
+func (h *Hub) end(t *topic) {
+	h.mu.Lock()
+	if h.topics[t.key] == t {
+		delete(h.topics, t.key)
+	}
+	h.mu.Unlock()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.ended = true
+	t.cancel()
+	for client := range t.clients {
+		h.remove(t, client)
+	}
+}
+
+// remove closes client and takes it off t, stopping t's source if it was the last one. t.mu must be held.
+func (h *Hub) remove(t *topic, client *Client) {
+	client.mu.Lock()
+	if !client.closed {
+		client.closed = true
+		close(client.events)
+	}
+	client.mu.Unlock()
+
+	delete(t.clients, client)
+	if len(t.clients) == 0 && !t.ended {
+		t.ended = true
+		t.cancel()
+	}
+}
+
+func (c *Client) Events() <-chan any {
+	return c.events
+}
+
+// Dropped reports whether the client was removed for not keeping up.
+func (c *Client) Dropped() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.dropped
+}
+
+// Close unsubscribes the client.
+func (c *Client) Close() {
+	c.topic.mu.Lock()
+	defer c.topic.mu.Unlock()
+
+	c.hub.remove(c.topic, c)
+}
@@ -0,0 +1,323 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// upstream stands in for the bus: every listener gets its own copy of each payload, which its source decodes.
+type upstream struct {
+	mu        sync.Mutex
+	listeners map[chan []byte]struct{}
+	started   int32
+}
+
+func newUpstream() *upstream {
+	return &upstream{listeners: make(map[chan []byte]struct{})}
+}
+
+func (u *upstream) source(ctx context.Context, publish func(event any)) error {
+	atomic.AddInt32(&u.started, 1)
+
+	payloads := make(chan []byte, DefaultBufferSize)
+	u.mu.Lock()
+	u.listeners[payloads] = struct{}{}
+	u.mu.Unlock()
+
+	defer func() {
+		u.mu.Lock()
+		delete(u.listeners, payloads)
+		u.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case payload := <-payloads:
+			var event map[string]any
+			if err := json.Unmarshal(payload, &event); err != nil {
+				return err
+			}
+
+			publish(event)
+		}
+	}
+}
+
+func (u *upstream) send(payload []byte) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for listener := range u.listeners {
+		listener <- payload
+	}
+}
+
+func (u *upstream) count() int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return len(u.listeners)
+}
+
+// waitFor polls until condition holds, failing t if it doesn't within a second.
+func waitFor(t testing.TB, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting")
+		}
+
+		runtime.Gosched()
+	}
+}
+
+func receive(t *testing.T, client *Client) any {
+	t.Helper()
+
+	select {
+	case event, ok := <-client.Events():
+		if !ok {
+			t.Fatal("events closed")
+		}
+
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for event")
+		return nil
+	}
+}
+
+func waitClosed(t *testing.T, client *Client) {
+	t.Helper()
+
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-client.Events():
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for events to close")
+		}
+	}
+}
+
+func TestHub_Subscribe(t *testing.T) {
+	hub := New(zap.NewNop(), 0)
+	source := newUpstream()
+
+	first := hub.Subscribe("channel", source.source)
+	second := hub.Subscribe("channel", source.source)
+	other := hub.Subscribe("other", newUpstream().source)
+	waitFor(t, func() bool { return source.count() == 1 })
+
+	if started := atomic.LoadInt32(&source.started); started != 1 {
+		t.Errorf("started %d sources, want 1", started)
+	}
+
+	if topics, clients := hub.Stats(); topics != 2 || clients != 3 {
+		t.Errorf("Stats() = %d, %d, want 2, 3", topics, clients)
+	}
+
+	source.send([]byte(`{"n": 1}`))
+	for _, client := range []*Client{first, second} {
+		if event := receive(t, client); fmt.Sprint(event) != "map[n:1]" {
+			t.Errorf("got %v, want upstream event", event)
+		}
+	}
+
+	hub.Publish("channel", "local")
+	for _, client := range []*Client{first, second} {
+		if event := receive(t, client); event != "local" {
+			t.Errorf("got %v, want local event", event)
+		}
+	}
+
+	select {
+	case event := <-other.Events():
+		t.Errorf("other topic got %v", event)
+	default:
+	}
+}
+
+func TestHub_Unsubscribe(t *testing.T) {
+	hub := New(zap.NewNop(), 0)
+	source := newUpstream()
+
+	first := hub.Subscribe("channel", source.source)
+	second := hub.Subscribe("channel", source.source)
+	waitFor(t, func() bool { return source.count() == 1 })
+
+	first.Close()
+	first.Close()
+	waitClosed(t, first)
+	if first.Dropped() {
+		t.Error("closed client reported dropped")
+	}
+
+	hub.Publish("channel", "still here")
+	if event := receive(t, second); event != "still here" {
+		t.Errorf("got %v, want event after other client left", event)
+	}
+
+	second.Close()
+	waitFor(t, func() bool { return source.count() == 0 })
+	waitFor(t, func() bool { topics, _ := hub.Stats(); return topics == 0 })
+
+	third := hub.Subscribe("channel", source.source)
+	waitFor(t, func() bool { return source.count() == 1 })
+	if started := atomic.LoadInt32(&source.started); started != 2 {
+		t.Errorf("started %d sources, want a fresh one after the last client left", started)
+	}
+
+	hub.Publish("channel", "again")
+	if event := receive(t, third); event != "again" {
+		t.Errorf("got %v, want event on resubscribed topic", event)
+	}
+
+	third.Close()
+}
+
+func TestHub_SlowConsumer(t *testing.T) {
+	hub := New(zap.NewNop(), 2)
+	source := newUpstream()
+
+	slow := hub.Subscribe("channel", source.source)
+	fast := hub.Subscribe("channel", source.source)
+	for i := 0; i < 3; i++ {
+		hub.Publish("channel", i)
+		if event := receive(t, fast); event != i {
+			t.Errorf("got %v, want %d", event, i)
+		}
+	}
+
+	var buffered []any
+	for event := range slow.Events() {
+		buffered = append(buffered, event)
+	}
+
+	if fmt.Sprint(buffered) != "[0 1]" {
+		t.Errorf("slow client got %v before closing, want its full buffer", buffered)
+	}
+
+	if !slow.Dropped() {
+		t.Error("slow client not reported dropped")
+	}
+
+	if fast.Dropped() {
+		t.Error("fast client reported dropped")
+	}
+
+	if _, clients := hub.Stats(); clients != 1 {
+		t.Errorf("hub has %d clients, want 1", clients)
+	}
+
+	fast.Close()
+}
+
+func TestHub_SourceEnds(t *testing.T) {
+	hub := New(zap.NewNop(), 0)
+	end := make(chan struct{})
+
+	client := hub.Subscribe("channel", func(ctx context.Context, publish func(event any)) error {
+		publish("last")
+		<-end
+		return nil
+	})
+
+	if event := receive(t, client); event != "last" {
+		t.Errorf("got %v, want last event", event)
+	}
+
+	close(end)
+	waitClosed(t, client)
+	if client.Dropped() {
+		t.Error("client of ended topic reported dropped")
+	}
+
+	waitFor(t, func() bool { topics, _ := hub.Stats(); return topics == 0 })
+	client.Close()
+}
+
+// BenchmarkHub fans each upstream event out to many sockets through one listener per topic.
+func BenchmarkHub(b *testing.B) {
+	for _, sockets := range []int{10, 100, 1000} {
+		b.Run(fmt.Sprintf("sockets=%d", sockets), func(b *testing.B) {
+			benchmarkFanOut(b, sockets, func(int) string { return "channel" })
+		})
+	}
+}
+
+// BenchmarkHub_ListenerPerSocket is the baseline, giving every socket its own upstream listener as if there were
+// no hub to share one.
+func BenchmarkHub_ListenerPerSocket(b *testing.B) {
+	for _, sockets := range []int{10, 100, 1000} {
+		b.Run(fmt.Sprintf("sockets=%d", sockets), func(b *testing.B) {
+			benchmarkFanOut(b, sockets, func(socket int) string { return fmt.Sprint("channel-", socket) })
+		})
+	}
+}
+
+func benchmarkFanOut(b *testing.B, sockets int, key func(socket int) string) {
+	hub := New(zap.NewNop(), 0)
+	source := newUpstream()
+	listeners := map[string]struct{}{}
+
+	var received int64
+	var wg sync.WaitGroup
+	clients := make([]*Client, sockets)
+	for i := range clients {
+		clients[i] = hub.Subscribe(key(i), source.source)
+		listeners[key(i)] = struct{}{}
+
+		wg.Add(1)
+		go func(client *Client) {
+			defer wg.Done()
+			for range client.Events() {
+				atomic.AddInt64(&received, 1)
+			}
+		}(clients[i])
+	}
+
+	waitFor(b, func() bool { return source.count() == len(listeners) })
+	payload := []byte(`{"type": "message.created", "message": {"id": "abc", "body": "hello"}}`)
+
+	// Let sockets catch up every half buffer, so none are dropped for falling behind
+	window := DefaultBufferSize / 2
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 1; i <= b.N; i++ {
+		source.send(payload)
+		if i%window == 0 || i == b.N {
+			want := int64(i * sockets)
+			for atomic.LoadInt64(&received) < want {
+				runtime.Gosched()
+			}
+		}
+	}
+	b.StopTimer()
+
+	for _, client := range clients {
+		if client.Dropped() {
+			b.Fatal("socket dropped")
+		}
+
+		client.Close()
+	}
+
+	wg.Wait()
+}
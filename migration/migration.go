@@ -0,0 +1,233 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/broothie/slink.chat/db"
+	"github.com/broothie/slink.chat/model"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+)
+
+const (
+	collection = "migrations"
+	pageSize   = 200
+
+	// Firestore caps batches at 500 writes.
+	maxBatchWrites = 400
+)
+
+// Migration evolves stored documents. Versions are applied in ascending order, each at most once per environment.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(ctx context.Context, run *Run) error
+}
+
+type record struct {
+	ID        string    `firestore:"id"`
+	Version   int       `firestore:"version"`
+	Name      string    `firestore:"name"`
+	AppliedAt time.Time `firestore:"applied_at"`
+}
+
+type Migrator struct {
+	db         *db.DB
+	migrations []Migration
+	out        io.Writer
+}
+
+func New(db *db.DB, out io.Writer) *Migrator {
+	return &Migrator{db: db, migrations: Migrations, out: out}
+}
+
+// Pending returns the migrations not yet applied, in order.
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	snapshots, err := m.db.Collection(collection).Documents(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch applied migrations")
+	}
+
+	applied := make(map[int]bool, len(snapshots))
+	for _, snapshot := range snapshots {
+		var record record
+		if err := snapshot.DataTo(&record); err != nil {
+			return nil, errors.Wrapf(err, "failed to read migration %q", snapshot.ID())
+		}
+
+		applied[record.Version] = true
+	}
+
+	migrations := append([]Migration{}, m.migrations...)
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version == migrations[i-1].Version {
+			return nil, fmt.Errorf("duplicate migration version %d", migrations[i].Version)
+		}
+	}
+
+	return lo.Reject(migrations, func(migration Migration, _ int) bool { return applied[migration.Version] }), nil
+}
+
+// Run applies pending migrations in order, stopping at the first failure. With dryRun, migrations report what
+// they would change without writing anything.
+func (m *Migrator) Run(ctx context.Context, dryRun bool) error {
+	pending, err := m.Pending(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(pending) == 0 {
+		fmt.Fprintln(m.out, "no pending migrations")
+		return nil
+	}
+
+	for i, migration := range pending {
+		fmt.Fprintf(m.out, "[%d/%d] %04d %s\n", i+1, len(pending), migration.Version, migration.Name)
+
+		start := time.Now()
+		run := &Run{DB: m.db, DryRun: dryRun, migration: migration, out: m.out}
+		if err := migration.Up(ctx, run); err != nil {
+			return errors.Wrapf(err, "migration %04d %s failed", migration.Version, migration.Name)
+		}
+
+		if dryRun {
+			continue
+		}
+
+		record := record{
+			ID:        strconv.Itoa(migration.Version),
+			Version:   migration.Version,
+			Name:      migration.Name,
+			AppliedAt: time.Now(),
+		}
+
+		if err := m.db.Collection(collection).Doc(record.ID).Create(ctx, record); err != nil {
+			return errors.Wrapf(err, "failed to record migration %04d", migration.Version)
+		}
+
+		fmt.Fprintf(m.out, "  done in %s\n", time.Since(start).Round(time.Millisecond))
+	}
+
+	return nil
+}
+
+// Run is handed to a migration while it's applied.
+type Run struct {
+	DB     *db.DB
+	DryRun bool
+
+	migration Migration
+	out       io.Writer
+}
+
+func (r *Run) Printf(format string, args ...any) {
+	fmt.Fprintf(r.out, "  "+format+"\n", args...)
+}
+
+// Commit commits batch, unless this is a dry run.
+func (r *Run) Commit(ctx context.Context, batch db.Batch) error {
+	if r.DryRun {
+		return nil
+	}
+
+	return batch.Commit(ctx)
+}
+
+// Each pages through every stored M, calling fn with a batch for its writes. fn reports whether it changed
+// anything. Each page is committed as it's done, so progress survives a failure part way through, and large pages
+// are split across as many commits as they need.
+func Each[M model.Typer](ctx context.Context, run *Run, fn func(batch db.Batch, m M) (bool, error)) error {
+	fetcher := db.NewFetcher[M](run.DB)
+	params := db.PageParams{Limit: pageSize}
+
+	seen, changed := 0, 0
+	for {
+		page, err := fetcher.Page(ctx, "created_at", params, func(query db.Query) db.Query { return query })
+		if err != nil {
+			return errors.Wrapf(err, "failed to fetch %q", fetcher.Type())
+		}
+
+		batch := &chunkedBatch{ctx: ctx, run: run, batch: run.DB.Batch()}
+		pageChanged := 0
+		for _, m := range page.Items {
+			ok, err := fn(batch, m)
+			if err != nil {
+				return err
+			}
+
+			if ok {
+				pageChanged++
+			}
+		}
+
+		if pageChanged > 0 {
+			if err := batch.Commit(ctx); err != nil {
+				return errors.Wrapf(err, "failed to commit %q batch", fetcher.Type())
+			}
+		}
+
+		seen += len(page.Items)
+		changed += pageChanged
+		run.Printf("%s: %d seen, %d %s", fetcher.Type(), seen, changed, lo.Ternary(run.DryRun, "would change", "changed"))
+
+		if page.Next == "" {
+			return nil
+		}
+
+		params.After = page.Next
+	}
+}
+
+// chunkedBatch commits its writes every maxBatchWrites, holding on to the first error until Commit.
+type chunkedBatch struct {
+	ctx    context.Context
+	run    *Run
+	batch  db.Batch
+	writes int
+	err    error
+}
+
+func (b *chunkedBatch) Create(ref db.DocumentRef, data any) {
+	b.batch.Create(ref, data)
+	b.wrote()
+}
+
+func (b *chunkedBatch) Set(ref db.DocumentRef, data any) {
+	b.batch.Set(ref, data)
+	b.wrote()
+}
+
+func (b *chunkedBatch) Update(ref db.DocumentRef, updates []db.Update) {
+	b.batch.Update(ref, updates)
+	b.wrote()
+}
+
+func (b *chunkedBatch) Delete(ref db.DocumentRef) {
+	b.batch.Delete(ref)
+	b.wrote()
+}
+
+func (b *chunkedBatch) Commit(ctx context.Context) error {
+	if b.err != nil {
+		return b.err
+	}
+
+	return b.run.Commit(ctx, b.batch)
+}
+
+func (b *chunkedBatch) wrote() {
+	b.writes++
+	if b.writes < maxBatchWrites || b.err != nil {
+		return
+	}
+
+	b.err = b.run.Commit(b.ctx, b.batch)
+	b.batch = b.run.DB.Batch()
+	b.writes = 0
+}
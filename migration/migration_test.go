@@ -0,0 +1,255 @@
+package migration
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/broothie/slink.chat/config"
+	"github.com/broothie/slink.chat/db"
+	"github.com/broothie/slink.chat/model"
+)
+
+// countingStore records how many writes each batch committed, so tests can see how writes are split up.
+type countingStore struct {
+	db.Store
+	commits []int
+}
+
+func (s *countingStore) Batch() db.Batch {
+	return &countingBatch{Batch: s.Store.Batch(), store: s}
+}
+
+type countingBatch struct {
+	db.Batch
+	store  *countingStore
+	writes int
+}
+
+func (b *countingBatch) Create(ref db.DocumentRef, data any) {
+	b.writes++
+	b.Batch.Create(ref, data)
+}
+
+func (b *countingBatch) Set(ref db.DocumentRef, data any) {
+	b.writes++
+	b.Batch.Set(ref, data)
+}
+
+func (b *countingBatch) Update(ref db.DocumentRef, updates []db.Update) {
+	b.writes++
+	b.Batch.Update(ref, updates)
+}
+
+func (b *countingBatch) Delete(ref db.DocumentRef) {
+	b.writes++
+	b.Batch.Delete(ref)
+}
+
+func (b *countingBatch) Commit(ctx context.Context) error {
+	b.store.commits = append(b.store.commits, b.writes)
+	return b.Batch.Commit(ctx)
+}
+
+// newTestDB is a memory store holding only channels numbered 0 through channels-1, oldest first.
+func newTestDB(t *testing.T, channels int) (*db.DB, *countingStore) {
+	t.Helper()
+
+	store, err := db.New(&config.Config{Environment: "test", Database: config.DatabaseMemory})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The memory store seeds World Chat, which would count towards every page
+	seeded, err := db.NewFetcher[model.Channel](store).Query(context.Background(), func(query db.Query) db.Query { return query })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	epoch := time.Date(2022, 8, 1, 0, 0, 0, 0, time.UTC)
+	batch := store.Batch()
+	for _, channel := range seeded {
+		batch.Delete(store.CollectionFor(channel.Type()).Doc(channel.ID))
+	}
+
+	for i := 0; i < channels; i++ {
+		channel := model.Channel{ID: fmt.Sprintf("channel-%03d", i), CreatedAt: epoch.Add(time.Duration(i) * time.Minute), Name: fmt.Sprint(i)}
+		batch.Create(store.CollectionFor(channel.Type()).Doc(channel.ID), channel)
+	}
+
+	if err := batch.Commit(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	counting := &countingStore{Store: store.Store}
+	store.Store = counting
+	return store, counting
+}
+
+// renameChannels is a migration that renames every channel, making writes writes to each.
+func renameChannels(writes int) func(ctx context.Context, run *Run) error {
+	return func(ctx context.Context, run *Run) error {
+		return Each(ctx, run, func(batch db.Batch, channel model.Channel) (bool, error) {
+			for i := 0; i < writes; i++ {
+				batch.Update(run.DB.CollectionFor(channel.Type()).Doc(channel.ID), []db.Update{{Path: "name", Value: "renamed " + channel.Name}})
+			}
+
+			return true, nil
+		})
+	}
+}
+
+func channelNames(t *testing.T, store *db.DB) []string {
+	t.Helper()
+
+	channels, err := db.NewFetcher[model.Channel](store).Query(context.Background(), func(query db.Query) db.Query {
+		return query.OrderBy("created_at", db.Asc)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := make([]string, len(channels))
+	for i, channel := range channels {
+		names[i] = channel.Name
+	}
+
+	return names
+}
+
+func TestMigrator_Run(t *testing.T) {
+	ctx := context.Background()
+	store, counting := newTestDB(t, 3)
+
+	runs := map[int]int{}
+	migration := func(version int, up func(ctx context.Context, run *Run) error) Migration {
+		return Migration{Version: version, Name: fmt.Sprint(version), Up: func(ctx context.Context, run *Run) error {
+			runs[version]++
+			return up(ctx, run)
+		}}
+	}
+
+	var out bytes.Buffer
+	migrator := &Migrator{db: store, out: &out, migrations: []Migration{
+		migration(2, func(context.Context, *Run) error { return nil }),
+		migration(1, renameChannels(1)),
+	}}
+
+	t.Run("dry run", func(t *testing.T) {
+		if err := migrator.Run(ctx, true); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := channelNames(t, store); fmt.Sprint(got) != "[0 1 2]" || len(counting.commits) != 0 {
+			t.Errorf("got %v after %d commits, want nothing written", got, len(counting.commits))
+		}
+
+		if pending, err := migrator.Pending(ctx); err != nil || len(pending) != 2 {
+			t.Errorf("got %d pending, %v, want both still pending", len(pending), err)
+		}
+
+		if !strings.Contains(out.String(), "3 would change") {
+			t.Errorf("got output %q, want what would change", out.String())
+		}
+	})
+
+	t.Run("run", func(t *testing.T) {
+		if err := migrator.Run(ctx, false); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := channelNames(t, store); fmt.Sprint(got) != "[renamed 0 renamed 1 renamed 2]" {
+			t.Errorf("got %v, want every channel renamed", got)
+		}
+
+		if pending, err := migrator.Pending(ctx); err != nil || len(pending) != 0 {
+			t.Errorf("got %d pending, %v, want none", len(pending), err)
+		}
+
+		if !strings.Contains(out.String(), "[1/2] 0001 1\n") || !strings.Contains(out.String(), "[2/2] 0002 2\n") {
+			t.Errorf("got output %q, want migrations applied in version order", out.String())
+		}
+	})
+
+	t.Run("rerun", func(t *testing.T) {
+		out.Reset()
+		if err := migrator.Run(ctx, false); err != nil {
+			t.Fatal(err)
+		}
+
+		if runs[1] != 2 || runs[2] != 2 || !strings.Contains(out.String(), "no pending migrations") {
+			t.Errorf("got runs %v and output %q, want applied migrations skipped", runs, out.String())
+		}
+
+		// A new migration runs alone
+		migrator.migrations = append(migrator.migrations, migration(3, func(context.Context, *Run) error { return nil }))
+		if err := migrator.Run(ctx, false); err != nil {
+			t.Fatal(err)
+		}
+
+		if runs[1] != 2 || runs[2] != 2 || runs[3] != 1 {
+			t.Errorf("got runs %v, want only the new migration run", runs)
+		}
+	})
+
+	t.Run("duplicate versions", func(t *testing.T) {
+		migrator := &Migrator{db: store, out: &out, migrations: []Migration{migration(9, nil), migration(9, nil)}}
+		if err := migrator.Run(ctx, false); err == nil {
+			t.Error("ran migrations with the same version")
+		}
+	})
+}
+
+func TestEach(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("pages", func(t *testing.T) {
+		store, counting := newTestDB(t, pageSize+50)
+		if err := renameChannels(1)(ctx, &Run{DB: store, out: &bytes.Buffer{}}); err != nil {
+			t.Fatal(err)
+		}
+
+		names := channelNames(t, store)
+		if names[0] != "renamed 0" || names[len(names)-1] != fmt.Sprintf("renamed %d", pageSize+49) {
+			t.Errorf("got %v, want every channel renamed", names)
+		}
+
+		if want := []int{pageSize, 50}; fmt.Sprint(counting.commits) != fmt.Sprint(want) {
+			t.Errorf("got commits of %v writes, want one per page, %v", counting.commits, want)
+		}
+	})
+
+	t.Run("large pages", func(t *testing.T) {
+		// Three writes a channel makes a full page too many writes for one commit
+		store, counting := newTestDB(t, pageSize+50)
+		if err := renameChannels(3)(ctx, &Run{DB: store, out: &bytes.Buffer{}}); err != nil {
+			t.Fatal(err)
+		}
+
+		if want := []int{maxBatchWrites, 3*pageSize - maxBatchWrites, 3 * 50}; fmt.Sprint(counting.commits) != fmt.Sprint(want) {
+			t.Errorf("got commits of %v writes, want %v", counting.commits, want)
+		}
+	})
+
+	t.Run("unchanged pages", func(t *testing.T) {
+		store, counting := newTestDB(t, 3)
+		err := Each(ctx, &Run{DB: store, out: &bytes.Buffer{}}, func(db.Batch, model.Channel) (bool, error) { return false, nil })
+		if err != nil || len(counting.commits) != 0 {
+			t.Errorf("got %v after %d commits, want nothing committed", err, len(counting.commits))
+		}
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		store, counting := newTestDB(t, pageSize+50)
+		if err := renameChannels(3)(ctx, &Run{DB: store, DryRun: true, out: &bytes.Buffer{}}); err != nil {
+			t.Fatal(err)
+		}
+
+		if names := channelNames(t, store); names[0] != "0" || len(counting.commits) != 0 {
+			t.Errorf("got %v after %d commits, want nothing written", names[:3], len(counting.commits))
+		}
+	})
+}
@@ -0,0 +1,153 @@
+package migration
+
+import (
+	"context"
+	"time"
+
+	"github.com/broothie/slink.chat/db"
+	"github.com/broothie/slink.chat/model"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+)
+
+// Migrations lists every migration. Append new ones with the next version; never renumber or remove one that
+// has shipped.
+var Migrations = []Migration{
+	{Version: 1, Name: "seed defaults", Up: seedDefaults},
+	{Version: 2, Name: "subscriptions from channel user_ids", Up: subscriptionsFromUserIDs},
+	{Version: 3, Name: "message sequence numbers", Up: messageSeqs},
+	{Version: 4, Name: "message parent ids", Up: messageParentIDs},
+	{Version: 5, Name: "user screenname keys", Up: userScreennameKeys},
+}
+
+// seedDefaults creates SmarterChild and World Chat in environments that don't have them yet.
+func seedDefaults(ctx context.Context, run *Run) error {
+	_, err := db.NewFetcher[model.User](run.DB).FetchFirst(ctx, func(query db.Query) db.Query {
+		return query.Where("screenname", "==", model.ScreennameSmarterChild)
+	})
+	if err == nil {
+		run.Printf("%s already exists", model.ScreennameSmarterChild)
+		return nil
+	} else if err != db.NotFound {
+		return errors.Wrap(err, "failed to fetch smarterchild")
+	}
+
+	run.Printf("creating %s and %s", model.ScreennameSmarterChild, model.ChannelNameWorldChat)
+	if run.DryRun {
+		return nil
+	}
+
+	_, _, err = db.Seed(ctx, run.DB)
+	return err
+}
+
+// legacyChannel reads the user_ids array channels had before subscriptions.
+type legacyChannel struct {
+	model.Channel
+	UserIDs []string `firestore:"user_ids"`
+}
+
+// subscriptionsFromUserIDs gives every member of a channel's user_ids a subscription, keys private chats by their
+// members, then rewrites the channel without user_ids.
+func subscriptionsFromUserIDs(ctx context.Context, run *Run) error {
+	return Each(ctx, run, func(batch db.Batch, channel legacyChannel) (bool, error) {
+		if channel.UserIDs == nil {
+			return false, nil
+		}
+
+		now := time.Now()
+		for _, userID := range channel.UserIDs {
+			subscription := model.NewSubscription(userID, channel.ID, lo.Ternary(userID == channel.UserID, model.RoleOwner, model.RoleMember), now)
+			subscription.JoinedAt = channel.CreatedAt
+			batch.Set(run.DB.CollectionFor(subscription.Type()).Doc(subscription.ID), subscription)
+		}
+
+		if channel.Private {
+			channel.ChatKey = model.ChatKey(channel.UserIDs)
+		}
+
+		batch.Set(run.DB.CollectionFor(channel.Type()).Doc(channel.ID), channel.Channel)
+		return true, nil
+	})
+}
+
+// messageSeqs numbers each channel's messages in the order they were sent and records the last number on the
+// channel, then moves read markers onto the numbers.
+func messageSeqs(ctx context.Context, run *Run) error {
+	if err := Each(ctx, run, func(batch db.Batch, channel model.Channel) (bool, error) {
+		params := db.PageParams{Limit: pageSize}
+		seq := int64(0)
+		for {
+			page, err := db.NewFetcher[model.Message](run.DB).Page(ctx, "created_at", params, func(query db.Query) db.Query {
+				return query.Where("channel_id", "==", channel.ID)
+			})
+			if err != nil {
+				return false, errors.Wrapf(err, "failed to fetch messages of channel %q", channel.ID)
+			}
+
+			for _, message := range page.Items {
+				seq++
+				batch.Update(run.DB.CollectionFor(message.Type()).Doc(message.ID), []db.Update{{Path: "seq", Value: seq}})
+			}
+
+			if page.Next == "" {
+				break
+			}
+
+			params.After = page.Next
+		}
+
+		batch.Update(run.DB.CollectionFor(channel.Type()).Doc(channel.ID), []db.Update{{Path: "last_message_seq", Value: seq}})
+		return true, nil
+	}); err != nil {
+		return err
+	}
+
+	return Each(ctx, run, func(batch db.Batch, subscription model.Subscription) (bool, error) {
+		if subscription.LastReadMessageID == "" {
+			return false, nil
+		}
+
+		message, err := db.NewFetcher[model.Message](run.DB).Fetch(ctx, subscription.LastReadMessageID)
+		if err == db.NotFound {
+			return false, nil
+		} else if err != nil {
+			return false, errors.Wrapf(err, "failed to fetch last read message of %q", subscription.ID)
+		}
+
+		batch.Update(run.DB.CollectionFor(subscription.Type()).Doc(subscription.ID), []db.Update{{Path: "last_read_seq", Value: message.Seq}})
+		return true, nil
+	})
+}
+
+// legacyMessage reads whether a message was stored before threads, when it had no parent_id.
+type legacyMessage struct {
+	model.Message
+	ParentID *string `firestore:"parent_id"`
+}
+
+// messageParentIDs gives messages from before threads an empty parent_id, so that queries for top-level messages
+// find them.
+func messageParentIDs(ctx context.Context, run *Run) error {
+	return Each(ctx, run, func(batch db.Batch, message legacyMessage) (bool, error) {
+		if message.ParentID != nil {
+			return false, nil
+		}
+
+		batch.Update(run.DB.CollectionFor(message.Type()).Doc(message.ID), []db.Update{{Path: "parent_id", Value: ""}})
+		return true, nil
+	})
+}
+
+// userScreennameKeys gives users from before mentions looked them up by screenname_key their key.
+func userScreennameKeys(ctx context.Context, run *Run) error {
+	return Each(ctx, run, func(batch db.Batch, user model.User) (bool, error) {
+		key := model.ScreennameKey(user.Screenname)
+		if user.ScreennameKey == key {
+			return false, nil
+		}
+
+		batch.Update(run.DB.CollectionFor(user.Type()).Doc(user.ID), []db.Update{{Path: "screenname_key", Value: key}})
+		return true, nil
+	})
+}
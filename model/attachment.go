@@ -0,0 +1,53 @@
+package model
+
+import (
+	"strings"
+	"time"
+)
+
+const TypeAttachment Type = "attachment"
+
+// Attachment is a file uploaded to a channel. It's stored unlinked until its uploader sends it with a message,
+// after which the message keeps a copy of it.
+type Attachment struct {
+	ID        string    `firestore:"id" json:"attachmentID"`
+	CreatedAt time.Time `firestore:"created_at" json:"createdAt"`
+	UpdatedAt time.Time `firestore:"updated_at" json:"updatedAt"`
+
+	UserID    string `firestore:"user_id" json:"userID"`
+	ChannelID string `firestore:"channel_id" json:"channelID"`
+	MessageID string `firestore:"message_id" json:"messageID"`
+
+	Name        string `firestore:"name" json:"name"`
+	ContentType string `firestore:"content_type" json:"contentType"`
+	Size        int64  `firestore:"size" json:"size"`
+
+	// Images have their dimensions, and a thumbnail once one's been made
+	Width     int  `firestore:"width" json:"width,omitempty"`
+	Height    int  `firestore:"height" json:"height,omitempty"`
+	Thumbnail bool `firestore:"thumbnail" json:"thumbnail"`
+}
+
+func (Attachment) Type() Type {
+	return TypeAttachment
+}
+
+// IsImage reports whether the attachment can be shown inline.
+func (a Attachment) IsImage() bool {
+	return strings.HasPrefix(a.ContentType, "image/")
+}
+
+// Linked reports whether the attachment has been sent with a message.
+func (a Attachment) Linked() bool {
+	return a.MessageID != ""
+}
+
+// Key is where the attachment's file is in the blob store.
+func (a Attachment) Key() string {
+	return "attachments/" + a.ID
+}
+
+// ThumbnailKey is where the attachment's thumbnail is in the blob store.
+func (a Attachment) ThumbnailKey() string {
+	return "thumbnails/" + a.ID + ".jpg"
+}
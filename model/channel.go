@@ -1,6 +1,10 @@
 package model
 
-import "time"
+import (
+	"sort"
+	"strings"
+	"time"
+)
 
 const (
 	TypeChannel Type = "channel"
@@ -15,11 +19,23 @@ type Channel struct {
 
 	Name              string    `firestore:"name" json:"name"`
 	UserID            string    `firestore:"user_id" json:"userID"`
-	UserIDs           []string  `firestore:"user_ids" json:"userIDs"`
+	ChatKey           string    `firestore:"chat_key,omitempty" json:"-"`
 	Private           bool      `firestore:"private" json:"private"`
 	LastMessageSentAt time.Time `firestore:"last_message_sent_at" json:"lastMessageSentAt"`
+	LastMessageSeq    int64     `firestore:"last_message_seq" json:"lastMessageSeq"`
+
+	// Who sent the last message and who it mentioned, so subscribers can count it as unread without reading it
+	LastMessageUserID   string   `firestore:"last_message_user_id" json:"-"`
+	LastMessageMentions []string `firestore:"last_message_mentions" json:"-"`
 }
 
 func (Channel) Type() Type {
 	return TypeChannel
 }
+
+// ChatKey identifies a private chat by its members, so starting a chat with the same people finds the existing one.
+func ChatKey(userIDs []string) string {
+	sorted := append([]string{}, userIDs...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",")
+}
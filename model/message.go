@@ -1,10 +1,19 @@
 package model
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/TwiN/go-away"
+	"github.com/broothie/slink.chat/format"
+	"github.com/samber/lo"
 )
 
 const TypeMessage Type = "message"
@@ -14,22 +23,369 @@ type Message struct {
 	CreatedAt time.Time `firestore:"created_at" json:"createdAt"`
 	UpdatedAt time.Time `firestore:"updated_at" json:"updatedAt"`
 
+	// Seq orders messages within a channel, counting up from 1 with no gaps or ties
+	Seq int64 `firestore:"seq" json:"seq"`
+
 	UserID    string `firestore:"user_id" json:"userID"`
 	ChannelID string `firestore:"channel_id" json:"channelID"`
-	Body      string `firestore:"body" json:"body"`
+	AutoReply bool   `firestore:"auto_reply" json:"autoReply"`
+
+	// Body is the message as its author wrote it, and HTML is it formatted, sanitized and censored for display.
+	Body string `firestore:"body" json:"body"`
+	HTML string `firestore:"html" json:"html"`
+
+	// Replies have the ID of the message they're in the thread of, which is always a top-level message. Top-level
+	// messages count their replies.
+	ParentID    string    `firestore:"parent_id" json:"parentID"`
+	ReplyCount  int64     `firestore:"reply_count" json:"replyCount"`
+	LastReplyAt time.Time `firestore:"last_reply_at" json:"lastReplyAt"`
+
+	// Edited and deleted messages keep their place. Edits holds each body a message had before it was edited, and
+	// deleting a message leaves a tombstone with neither body nor edits.
+	EditedAt  time.Time `firestore:"edited_at" json:"editedAt"`
+	DeletedAt time.Time `firestore:"deleted_at" json:"deletedAt"`
+	Edits     []Edit    `firestore:"edits" json:"edits"`
+
+	// Mentions holds the IDs of the channel members mentioned in the message, resolved when it's created.
+	Mentions []string `firestore:"mentions" json:"mentions"`
+
+	// Reactions holds the IDs of the users who reacted with each emoji, in the order they reacted.
+	Reactions map[string][]string `firestore:"reactions" json:"reactions"`
+
+	// Attachments are copies of the attachments sent with the message.
+	Attachments []Attachment `firestore:"attachments" json:"attachments"`
+
+	// Previews are cards for the links in the message, added as each link is unfurled.
+	Previews []Preview `firestore:"previews" json:"previews"`
+}
+
+// Preview is what a link's page says about itself, from its OpenGraph and other meta tags.
+type Preview struct {
+	URL         string `firestore:"url" json:"url"`
+	Title       string `firestore:"title" json:"title"`
+	Description string `firestore:"description" json:"description"`
+	Image       string `firestore:"image" json:"image"`
+	SiteName    string `firestore:"site_name" json:"siteName"`
+}
+
+// Reaction totals a message's reactions with one emoji.
+type Reaction struct {
+	Emoji   string   `json:"emoji"`
+	Count   int      `json:"count"`
+	UserIDs []string `json:"userIDs"`
+}
+
+// RenderBody formats a message body for display.
+func RenderBody(body string) string {
+	return format.HTML(format.Parse(body), goaway.Censor)
+}
+
+// Edit is a body a message had until EditedAt, when UserID replaced it.
+type Edit struct {
+	Body     string    `firestore:"body" json:"body"`
+	UserID   string    `firestore:"user_id" json:"userID"`
+	EditedAt time.Time `firestore:"edited_at" json:"editedAt"`
 }
 
 func (Message) Type() Type {
 	return TypeMessage
 }
 
+// IsReply reports whether the message is in a thread.
+func (m Message) IsReply() bool {
+	return m.ParentID != ""
+}
+
+// Deleted reports whether the message is a tombstone.
+func (m Message) Deleted() bool {
+	return !m.DeletedAt.IsZero()
+}
+
+// Links is the http and https links in the message's body.
+func (m Message) Links() []string {
+	return format.Links(format.Parse(m.Body))
+}
+
+// Edit replaces the message's body, keeping the old one in its edits. Previews of links the new body no longer has
+// are dropped.
+func (m *Message) Edit(userID, body string, now time.Time) {
+	m.Edits = append(m.Edits, Edit{Body: m.Body, UserID: userID, EditedAt: now})
+	m.Body = body
+	m.HTML = RenderBody(body)
+
+	links := m.Links()
+	m.Previews = lo.Filter(m.Previews, func(preview Preview, _ int) bool { return lo.Contains(links, preview.URL) })
+	m.EditedAt = now
+	m.UpdatedAt = now
+}
+
+// React adds userID's reaction with emoji, reporting whether they hadn't already.
+func (m *Message) React(userID, emoji string, now time.Time) bool {
+	for _, reactedID := range m.Reactions[emoji] {
+		if reactedID == userID {
+			return false
+		}
+	}
+
+	if m.Reactions == nil {
+		m.Reactions = make(map[string][]string)
+	}
+
+	m.Reactions[emoji] = append(m.Reactions[emoji], userID)
+	m.UpdatedAt = now
+	return true
+}
+
+// Unreact removes userID's reaction with emoji, reporting whether there was one.
+func (m *Message) Unreact(userID, emoji string, now time.Time) bool {
+	for i, reactedID := range m.Reactions[emoji] {
+		if reactedID != userID {
+			continue
+		}
+
+		m.Reactions[emoji] = append(m.Reactions[emoji][:i:i], m.Reactions[emoji][i+1:]...)
+		if len(m.Reactions[emoji]) == 0 {
+			delete(m.Reactions, emoji)
+		}
+
+		m.UpdatedAt = now
+		return true
+	}
+
+	return false
+}
+
+// ReactionList totals the message's reactions, most popular first, then in emoji order.
+func (m Message) ReactionList() []Reaction {
+	reactions := make([]Reaction, 0, len(m.Reactions))
+	for emoji, userIDs := range m.Reactions {
+		reactions = append(reactions, Reaction{Emoji: emoji, Count: len(userIDs), UserIDs: userIDs})
+	}
+
+	sort.Slice(reactions, func(i, j int) bool {
+		if reactions[i].Count != reactions[j].Count {
+			return reactions[i].Count > reactions[j].Count
+		}
+
+		return reactions[i].Emoji < reactions[j].Emoji
+	})
+
+	return reactions
+}
+
+// Delete turns the message into a tombstone.
+func (m *Message) Delete(now time.Time) {
+	m.Body = ""
+	m.HTML = ""
+	m.Edits = nil
+	m.Mentions = nil
+	m.Reactions = nil
+	m.Attachments = nil
+	m.Previews = nil
+	m.DeletedAt = now
+	m.UpdatedAt = now
+}
+
+// MentionsUser reports whether the message mentions userID.
+func (m Message) MentionsUser(userID string) bool {
+	for _, mentionedID := range m.Mentions {
+		if mentionedID == userID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MarshalJSON leaves out edits, which are fetched separately, and totals reactions.
 func (m Message) MarshalJSON() ([]byte, error) {
+	mentions := m.Mentions
+	if mentions == nil {
+		mentions = []string{}
+	}
+
+	attachments := m.Attachments
+	if attachments == nil {
+		attachments = []Attachment{}
+	}
+
+	previews := m.Previews
+	if previews == nil {
+		previews = []Preview{}
+	}
+
+	// Messages from before formatting have only their body
+	html := m.HTML
+	if html == "" && m.Body != "" {
+		html = RenderBody(m.Body)
+	}
+
+	fields := map[string]any{
+		"messageID":   m.ID,
+		"createdAt":   m.CreatedAt,
+		"updatedAt":   m.UpdatedAt,
+		"seq":         m.Seq,
+		"userID":      m.UserID,
+		"channelID":   m.ChannelID,
+		"body":        goaway.Censor(m.Body),
+		"html":        html,
+		"autoReply":   m.AutoReply,
+		"mentions":    mentions,
+		"reactions":   m.ReactionList(),
+		"attachments": attachments,
+		"previews":    previews,
+	}
+
+	if m.IsReply() {
+		fields["parentID"] = m.ParentID
+	} else {
+		fields["replyCount"] = m.ReplyCount
+	}
+
+	if !m.LastReplyAt.IsZero() {
+		fields["lastReplyAt"] = m.LastReplyAt
+	}
+
+	if !m.EditedAt.IsZero() {
+		fields["editedAt"] = m.EditedAt
+	}
+
+	if m.Deleted() {
+		fields["deletedAt"] = m.DeletedAt
+	}
+
+	return json.Marshal(fields)
+}
+
+func (e Edit) MarshalJSON() ([]byte, error) {
 	return json.Marshal(map[string]any{
-		"messageID": m.ID,
-		"createdAt": m.CreatedAt,
-		"updatedAt": m.UpdatedAt,
-		"userID":    m.UserID,
-		"channelID": m.ChannelID,
-		"body":      goaway.Censor(m.Body),
+		"body":     goaway.Censor(e.Body),
+		"userID":   e.UserID,
+		"editedAt": e.EditedAt,
 	})
 }
+
+// MentionedScreennames returns which of screennames body mentions with an @, ignoring case, in the order they're
+// first mentioned. Screennames can have spaces, so where one is a prefix of another, like "bob" and "bob smith", the
+// longest one that fits is the one mentioned.
+func MentionedScreennames(body string, screennames []string) []string {
+	var mentioned []string
+	seen := make(map[string]bool)
+	for i := 0; i < len(body); i++ {
+		if body[i] != '@' || (i > 0 && isWordRune(lastRune(body[:i]))) {
+			continue
+		}
+
+		rest := body[i+1:]
+		longest := ""
+		for _, screenname := range screennames {
+			if len(screenname) <= len(longest) || len(screenname) > len(rest) || !strings.EqualFold(rest[:len(screenname)], screenname) {
+				continue
+			}
+
+			if next, _ := utf8.DecodeRuneInString(rest[len(screenname):]); len(rest) > len(screenname) && isWordRune(next) {
+				continue
+			}
+
+			longest = screenname
+		}
+
+		if longest != "" && !seen[longest] {
+			seen[longest] = true
+			mentioned = append(mentioned, longest)
+		}
+	}
+
+	return mentioned
+}
+
+const (
+	// maxMentionLength is the longest screenname MentionCandidates looks for.
+	maxMentionLength = 64
+
+	// MaxMentions is how many @s in a message MentionCandidates looks at, and MaxMentionCandidates how many
+	// screennames it returns to look up, so a message full of @s can't cost a lookup per word.
+	MaxMentions          = 20
+	MaxMentionCandidates = 30
+)
+
+// MentionCandidates returns the screenname keys body could mention with an @, for looking up which are users before
+// MentionedScreennames picks out the ones mentioned. Since screennames can have spaces, every run of whole words
+// following an @ is a candidate, up to maxMentionLength. Shorter runs come first, so each @'s first word is looked
+// up before any @'s longer runs.
+func MentionCandidates(body string) []string {
+	var runs [][]string
+	seenRuns := make(map[string]bool)
+	for i := 0; i < len(body) && len(runs) < MaxMentions; i++ {
+		if body[i] != '@' || (i > 0 && isWordRune(lastRune(body[:i]))) {
+			continue
+		}
+
+		// Screennames don't go past the end of a line, or into another mention
+		rest := body[i+1:]
+		if end := strings.IndexAny(rest, "\n@"); end >= 0 {
+			rest = rest[:end]
+		}
+
+		var run []string
+		for end := 1; end <= len(rest) && end <= maxMentionLength; end++ {
+			next, _ := utf8.DecodeRuneInString(rest[end:])
+			if !isWordRune(lastRune(rest[:end])) || (end < len(rest) && isWordRune(next)) {
+				continue
+			}
+
+			run = append(run, ScreennameKey(rest[:end]))
+		}
+
+		// The same @ over again doesn't count toward MaxMentions
+		if key := strings.Join(run, "\n"); !seenRuns[key] {
+			seenRuns[key] = true
+			runs = append(runs, run)
+		}
+	}
+
+	var candidates []string
+	seen := make(map[string]bool)
+	for words := 0; len(candidates) < MaxMentionCandidates; words++ {
+		more := false
+		for _, run := range runs {
+			if words >= len(run) {
+				continue
+			}
+
+			more = true
+			if key := run[words]; !seen[key] && len(candidates) < MaxMentionCandidates {
+				seen[key] = true
+				candidates = append(candidates, key)
+			}
+		}
+
+		if !more {
+			break
+		}
+	}
+
+	return candidates
+}
+
+func lastRune(s string) rune {
+	r, _ := utf8.DecodeLastRuneInString(s)
+	return r
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// AutoReplyID is the ID of user's auto-reply in a channel for the away session that began at awaySince. Creating
+// it fails once it exists, which is what keeps it to one per conversation per away session.
+func AutoReplyID(channelID, userID string, awaySince time.Time) string {
+	return fmt.Sprintf("autoreply_%s_%s_%d", channelID, userID, awaySince.UnixNano())
+}
+
+// NonceMessageID is the ID of the message user sent to a channel with a client nonce. Retrying the send derives the
+// same ID, so creating it again fails instead of posting the message twice.
+func NonceMessageID(channelID, userID, nonce string) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%s", channelID, userID, nonce)))
+	return "nonce_" + hex.EncodeToString(sum[:16])
+}
@@ -0,0 +1,79 @@
+package model
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMentionCandidates(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{"none", "no one here", nil},
+		{"one word", "hi @Bob", []string{"bob"}},
+		{"runs of words", "@bob smith, hi", []string{"bob", "bob smith", "bob smith, hi"}},
+		{"first words first", "@ann lee and @bob", []string{"ann", "bob", "ann lee", "ann lee and"}},
+		{"not an email", "mail bob@example.com", nil},
+		{"end of line", "@bob\nsmith", []string{"bob"}},
+		{"repeats", "@bob @BOB @bob", []string{"bob"}},
+		{"nothing after", "@ @", nil},
+		{"past the longest screenname", "@" + strings.Repeat("a", maxMentionLength+1), nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := MentionCandidates(test.body); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMentionCandidates_Caps(t *testing.T) {
+	t.Run("candidates", func(t *testing.T) {
+		body := strings.Repeat("@a b c d e f g h i j k ", 200)
+		if got := MentionCandidates(body); len(got) > MaxMentionCandidates {
+			t.Errorf("got %d candidates, want at most %d", len(got), MaxMentionCandidates)
+		}
+	})
+
+	t.Run("mentions", func(t *testing.T) {
+		var mentions []string
+		for i := 0; i < 4*MaxMentions; i++ {
+			mentions = append(mentions, fmt.Sprintf("@user%d", i))
+		}
+
+		got := MentionCandidates(strings.Join(mentions, " "))
+		if len(got) != MaxMentions || got[MaxMentions-1] != fmt.Sprintf("user%d", MaxMentions-1) {
+			t.Errorf("got %q, want the first %d mentions", got, MaxMentions)
+		}
+	})
+
+	t.Run("repeats don't count", func(t *testing.T) {
+		body := strings.Repeat("@a ", 4*MaxMentions) + "@bob"
+		if got, want := MentionCandidates(body), []string{"a", "bob"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got %q, want bob after repeats", got)
+		}
+	})
+}
+
+func TestMentionedScreennames(t *testing.T) {
+	screennames := []string{"bob", "Bob Smith", "ann"}
+	tests := map[string][]string{
+		"hi @bob":                 {"bob"},
+		"hi @bob smith!":          {"Bob Smith"},
+		"@ANN and @bob, and @ann": {"ann", "bob"},
+		"@bobby":                  nil,
+		"ann@bob":                 nil,
+	}
+
+	for body, want := range tests {
+		if got := MentionedScreennames(body, screennames); !reflect.DeepEqual(got, want) {
+			t.Errorf("MentionedScreennames(%q) = %q, want %q", body, got, want)
+		}
+	}
+}
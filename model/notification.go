@@ -0,0 +1,51 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	TypeNotification Type = "notification"
+
+	NotificationMention = "mention"
+)
+
+// Notification tells a user about something that happened to them, like being mentioned in a message.
+type Notification struct {
+	ID        string    `firestore:"id" json:"notificationID"`
+	CreatedAt time.Time `firestore:"created_at" json:"createdAt"`
+	UpdatedAt time.Time `firestore:"updated_at" json:"updatedAt"`
+
+	UserID    string    `firestore:"user_id" json:"userID"`
+	Kind      string    `firestore:"kind" json:"kind"`
+	ActorID   string    `firestore:"actor_id" json:"actorID"`
+	ChannelID string    `firestore:"channel_id" json:"channelID"`
+	MessageID string    `firestore:"message_id" json:"messageID"`
+	Read      bool      `firestore:"read" json:"read"`
+	ReadAt    time.Time `firestore:"read_at" json:"readAt"`
+}
+
+// NewMentionNotification tells userID that message mentions them.
+func NewMentionNotification(userID string, message Message, now time.Time) Notification {
+	return Notification{
+		ID:        NotificationID(NotificationMention, message.ID, userID),
+		CreatedAt: now,
+		UpdatedAt: now,
+		UserID:    userID,
+		Kind:      NotificationMention,
+		ActorID:   message.UserID,
+		ChannelID: message.ChannelID,
+		MessageID: message.ID,
+	}
+}
+
+func (Notification) Type() Type {
+	return TypeNotification
+}
+
+// NotificationID is derived from what the notification is about and who it's for, so a user is only notified once
+// per message however many times the fan-out runs.
+func NotificationID(kind, messageID, userID string) string {
+	return fmt.Sprintf("%s_%s_%s", kind, messageID, userID)
+}
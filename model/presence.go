@@ -0,0 +1,48 @@
+package model
+
+import (
+	"time"
+
+	"github.com/samber/lo"
+)
+
+const (
+	TypePresence Type = "presence"
+
+	StatusOnline  = "online"
+	StatusAway    = "away"
+	StatusIdle    = "idle"
+	StatusOffline = "offline"
+)
+
+// Presence is one open socket of a user's. Heartbeats push back ExpiresAt, so the sockets of a server that dies
+// expire on their own.
+type Presence struct {
+	ID        string    `firestore:"id" json:"presenceID"`
+	CreatedAt time.Time `firestore:"created_at" json:"createdAt"`
+	UpdatedAt time.Time `firestore:"updated_at" json:"updatedAt"`
+
+	UserID    string    `firestore:"user_id" json:"userID"`
+	Idle      bool      `firestore:"idle" json:"idle"`
+	ExpiresAt time.Time `firestore:"expires_at" json:"expiresAt"`
+}
+
+func (Presence) Type() Type {
+	return TypePresence
+}
+
+// Status is where user stands given their sockets: offline with none live, away with an away message, idle if
+// every socket is idle, and online otherwise.
+func Status(user User, presences []Presence, now time.Time) string {
+	live := lo.Filter(presences, func(presence Presence, _ int) bool { return presence.ExpiresAt.After(now) })
+	switch {
+	case len(live) == 0:
+		return StatusOffline
+	case user.AwayMessage != "":
+		return StatusAway
+	case lo.EveryBy(live, func(presence Presence) bool { return presence.Idle }):
+		return StatusIdle
+	default:
+		return StatusOnline
+	}
+}
@@ -0,0 +1,56 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	TypeSubscription Type = "subscription"
+
+	RoleOwner  = "owner"
+	RoleMember = "member"
+)
+
+// Subscription is a user's membership in a channel.
+type Subscription struct {
+	ID        string    `firestore:"id" json:"subscriptionID"`
+	CreatedAt time.Time `firestore:"created_at" json:"createdAt"`
+	UpdatedAt time.Time `firestore:"updated_at" json:"updatedAt"`
+
+	UserID            string    `firestore:"user_id" json:"userID"`
+	ChannelID         string    `firestore:"channel_id" json:"channelID"`
+	Role              string    `firestore:"role" json:"role"`
+	JoinedAt          time.Time `firestore:"joined_at" json:"joinedAt"`
+	LastReadMessageID string    `firestore:"last_read_message_id" json:"lastReadMessageID"`
+	LastReadSeq       int64     `firestore:"last_read_seq" json:"lastReadSeq"`
+	LastReadAt        time.Time `firestore:"last_read_at" json:"lastReadAt"`
+	Muted             bool      `firestore:"muted" json:"muted"`
+}
+
+func NewSubscription(userID, channelID, role string, now time.Time) Subscription {
+	return Subscription{
+		ID:        SubscriptionID(userID, channelID),
+		CreatedAt: now,
+		UpdatedAt: now,
+		UserID:    userID,
+		ChannelID: channelID,
+		Role:      role,
+		JoinedAt:  now,
+	}
+}
+
+// Moderates reports whether the subscriber can moderate the channel, which channel owners do.
+func (s Subscription) Moderates() bool {
+	return s.Role == RoleOwner
+}
+
+func (Subscription) Type() Type {
+	return TypeSubscription
+}
+
+// SubscriptionID is derived from the user and channel, so membership checks are a single get and joining twice
+// doesn't create two subscriptions.
+func SubscriptionID(userID, channelID string) string {
+	return fmt.Sprintf("%s_%s", channelID, userID)
+}
@@ -2,6 +2,7 @@ package model
 
 import (
 	"context"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
@@ -21,8 +22,16 @@ type User struct {
 	CreatedAt time.Time `firestore:"created_at" json:"createdAt"`
 	UpdatedAt time.Time `firestore:"updated_at" json:"updatedAt"`
 
-	Screenname     string `firestore:"screenname" json:"screenname"`
-	PasswordDigest []byte `firestore:"password_digest" json:"-"`
+	Screenname     string    `firestore:"screenname" json:"screenname"`
+	ScreennameKey  string    `firestore:"screenname_key" json:"-"`
+	PasswordDigest []byte    `firestore:"password_digest" json:"-"`
+	AwayMessage    string    `firestore:"away_message" json:"awayMessage"`
+	AwaySince      time.Time `firestore:"away_since" json:"awaySince"`
+}
+
+// ScreennameKey is screenname lowercased, which users are looked up by when case doesn't matter, as for mentions.
+func ScreennameKey(screenname string) string {
+	return strings.ToLower(screenname)
 }
 
 func (User) Type() Type {
@@ -51,6 +60,17 @@ func (u *User) PasswordMatches(password string) (bool, error) {
 	return true, nil
 }
 
+// ExpandAwayMessage fills in AIM's away message variables: %n for the screenname of whoever sent the message being
+// replied to, %t for the time and %d for the date. The time and date are now's, in now's location; users don't have
+// time zones, so auto-replies give the server's.
+func (u User) ExpandAwayMessage(senderScreenname string, now time.Time) string {
+	return strings.NewReplacer(
+		"%n", senderScreenname,
+		"%t", now.Format("3:04:05 PM"),
+		"%d", now.Format("1/2/2006"),
+	).Replace(u.AwayMessage)
+}
+
 func (u *User) OnContext(ctx context.Context) context.Context {
 	return context.WithValue(ctx, userContextKey, *u)
 }
@@ -0,0 +1,37 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUser_ExpandAwayMessage(t *testing.T) {
+	now := time.Date(2022, 8, 1, 15, 4, 5, 0, time.UTC)
+	tests := []struct {
+		awayMessage string
+		want        string
+	}{
+		{"brb", "brb"},
+		{"sorry %n, brb", "sorry bob, brb"},
+		{"away since %t on %d", "away since 3:04:05 PM on 8/1/2022"},
+		{"%n %n %t%d", "bob bob 3:04:05 PM8/1/2022"},
+		{"100%", "100%"},
+		{"%x %%n", "%x %bob"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.awayMessage, func(t *testing.T) {
+			user := User{AwayMessage: test.awayMessage}
+			if got := user.ExpandAwayMessage("bob", now); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+
+	t.Run("in now's location", func(t *testing.T) {
+		user := User{AwayMessage: "%t %d"}
+		if got, want := user.ExpandAwayMessage("bob", now.In(time.FixedZone("UTC+10", 10*60*60))), "1:04:05 AM 8/2/2022"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+}
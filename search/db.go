@@ -4,7 +4,6 @@ import (
 	"context"
 	"strings"
 
-	"cloud.google.com/go/firestore"
 	pkgdb "github.com/broothie/slink.chat/db"
 	"github.com/broothie/slink.chat/model"
 	"github.com/pkg/errors"
@@ -24,7 +23,7 @@ func (db *DB) IndexUser(model.User) error {
 }
 
 func (db *DB) SearchUsers(query string) ([]model.User, error) {
-	users, err := pkgdb.NewFetcher[model.User](db.db).Query(context.Background(), func(query firestore.Query) firestore.Query { return query.Where("screenname", ">", "") })
+	users, err := pkgdb.NewFetcher[model.User](db.db).Query(context.Background(), func(query pkgdb.Query) pkgdb.Query { return query.Where("screenname", ">", "") })
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to fetch users")
 	}
@@ -34,7 +33,7 @@ func (db *DB) SearchUsers(query string) ([]model.User, error) {
 }
 
 func (db *DB) DeleteUser(userID string) error {
-	_, err := db.db.Doc(userID).Delete(context.Background())
+	err := db.db.CollectionFor(model.TypeUser).Doc(userID).Delete(context.Background())
 	return errors.Wrap(err, "deleting user from db")
 }
 
@@ -43,7 +42,7 @@ func (db *DB) IndexChannel(user model.Channel) error {
 }
 
 func (db *DB) SearchChannels(query string) ([]model.Channel, error) {
-	channels, err := pkgdb.NewFetcher[model.Channel](db.db).Query(context.Background(), func(query firestore.Query) firestore.Query { return query.Where("screenname", ">", "") })
+	channels, err := pkgdb.NewFetcher[model.Channel](db.db).Query(context.Background(), func(query pkgdb.Query) pkgdb.Query { return query.Where("name", ">", "") })
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to fetch channels")
 	}
@@ -55,6 +54,6 @@ func (db *DB) SearchChannels(query string) ([]model.Channel, error) {
 }
 
 func (db *DB) DeleteChannel(channelID string) error {
-	_, err := db.db.Doc(channelID).Delete(context.Background())
+	err := db.db.CollectionFor(model.TypeChannel).Doc(channelID).Delete(context.Background())
 	return errors.Wrap(err, "deleting channel from db")
 }
@@ -1,6 +1,10 @@
 package search
 
 import (
+	"fmt"
+
+	"github.com/broothie/slink.chat/config"
+	pkgdb "github.com/broothie/slink.chat/db"
 	"github.com/broothie/slink.chat/model"
 )
 
@@ -13,3 +17,18 @@ type Search interface {
 	SearchChannels(string) ([]model.Channel, error)
 	DeleteChannel(string) error
 }
+
+// New builds the search cfg picks. Searching the store needs nothing else, so it's what's left when Algolia isn't set
+// up.
+func New(cfg *config.Config, db *pkgdb.DB) (Search, error) {
+	switch cfg.SearchBackend() {
+	case config.SearchAlgolia:
+		return NewAlgolia(cfg), nil
+
+	case config.SearchDB:
+		return NewDB(db), nil
+
+	default:
+		return nil, fmt.Errorf("unknown search %q", cfg.Search)
+	}
+}
@@ -0,0 +1,332 @@
+package server
+
+import (
+	"bytes"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/broothie/slink.chat/async/job"
+	"github.com/broothie/slink.chat/blob"
+	"github.com/broothie/slink.chat/db"
+	"github.com/broothie/slink.chat/model"
+	"github.com/broothie/slink.chat/util"
+	"github.com/go-chi/chi/v5"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"github.com/pkg/errors"
+	"github.com/rs/xid"
+	"go.uber.org/zap"
+)
+
+const (
+	// maxAttachmentSize caps uploads, which are held in memory while they're checked.
+	maxAttachmentSize = 10 << 20
+
+	// maxImagePixels keeps small files that decode to huge images from being thumbnailed.
+	maxImagePixels = 40_000_000
+
+	// maxAttachmentNameLength caps the filenames attachments keep.
+	maxAttachmentNameLength = 255
+
+	// maxMessageAttachments is how many attachments one message can have.
+	maxMessageAttachments = 10
+)
+
+// attachmentTypes are what can be uploaded, by the type their contents sniff as rather than what the client says.
+// Images listed here are ones that can be thumbnailed.
+var attachmentTypes = map[string]bool{
+	"image/png":       true,
+	"image/jpeg":      true,
+	"image/gif":       true,
+	"application/pdf": true,
+	"text/plain":      true,
+}
+
+var (
+	errNoAttachmentFile       = errors.New("upload must have a file")
+	errAttachmentTooLarge     = errors.Errorf("attachments can't be larger than %d bytes", maxAttachmentSize)
+	errAttachmentType         = errors.New("attachments must be png, jpeg or gif images, pdfs or plain text")
+	errInvalidImage           = errors.New("image is invalid or too large")
+	errTooManyAttachments     = errors.Errorf("messages can't have more than %d attachments", maxMessageAttachments)
+	errAttachmentNotAvailable = errors.New("attachment not found")
+)
+
+// uploadAttachment stores a file for the user to send to a channel. It's only theirs to see until they do.
+func (s *Server) uploadAttachment(w http.ResponseWriter, r *http.Request) {
+	logger := ctxzap.Extract(r.Context())
+
+	user, _ := model.UserFromContext(r.Context())
+	channelID := chi.URLParam(r, "channel_id")
+	if !s.requireSubscription(w, r, user, channelID) {
+		return
+	}
+
+	name, data, err := readUpload(w, r)
+	if err != nil {
+		switch err {
+		case errNoAttachmentFile:
+			s.render.JSON(w, http.StatusBadRequest, errorMap(err))
+		case errAttachmentTooLarge:
+			s.render.JSON(w, http.StatusRequestEntityTooLarge, errorMap(err))
+		default:
+			logger.Error("failed to read upload", zap.Error(err))
+			s.render.JSON(w, http.StatusBadRequest, errorMap(err))
+		}
+
+		return
+	}
+
+	contentType := http.DetectContentType(data)
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if !attachmentTypes[mediaType] {
+		s.render.JSON(w, http.StatusUnsupportedMediaType, errorMap(errAttachmentType))
+		return
+	}
+
+	now := time.Now()
+	attachment := model.Attachment{
+		ID:          xid.New().String(),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		UserID:      user.ID,
+		ChannelID:   channelID,
+		Name:        name,
+		ContentType: contentType,
+		Size:        int64(len(data)),
+	}
+
+	if attachment.IsImage() {
+		config, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil || config.Width*config.Height > maxImagePixels {
+			s.render.JSON(w, http.StatusBadRequest, errorMap(errInvalidImage))
+			return
+		}
+
+		attachment.Width = config.Width
+		attachment.Height = config.Height
+	}
+
+	if err := s.Blobs.Put(r.Context(), attachment.Key(), attachment.ContentType, bytes.NewReader(data)); err != nil {
+		logger.Error("failed to put attachment blob", zap.Error(err))
+		s.render.JSON(w, http.StatusInternalServerError, errorMap(err))
+		return
+	}
+
+	if err := s.DB.CollectionFor(attachment.Type()).Doc(attachment.ID).Create(r.Context(), attachment); err != nil {
+		logger.Error("failed to create attachment", zap.Error(err))
+		s.render.JSON(w, http.StatusInternalServerError, errorMap(err))
+		return
+	}
+
+	if attachment.IsImage() {
+		if err := s.Async.Do(r.Context(), job.ThumbnailJob{AttachmentID: attachment.ID}); err != nil {
+			logger.Error("failed to queue ThumbnailJob", zap.Error(err))
+		}
+	}
+
+	s.render.JSON(w, http.StatusCreated, util.Map{"attachment": attachment})
+}
+
+// readUpload reads the file from a multipart upload's file field, along with a cleaned up version of its name.
+func readUpload(w http.ResponseWriter, r *http.Request) (string, []byte, error) {
+	// The rest of the form gets a little room on top of the file
+	r.Body = http.MaxBytesReader(w, r.Body, maxAttachmentSize+64<<10)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return "", nil, errors.Wrap(err, "failed to read multipart body")
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return "", nil, errNoAttachmentFile
+		} else if err != nil {
+			if isMaxBytesError(err) {
+				return "", nil, errAttachmentTooLarge
+			}
+
+			return "", nil, errors.Wrap(err, "failed to read multipart part")
+		}
+
+		if part.FormName() != "file" {
+			continue
+		}
+
+		data, err := io.ReadAll(io.LimitReader(part, maxAttachmentSize+1))
+		if err != nil {
+			if isMaxBytesError(err) {
+				return "", nil, errAttachmentTooLarge
+			}
+
+			return "", nil, errors.Wrap(err, "failed to read file")
+		}
+
+		if len(data) > maxAttachmentSize {
+			return "", nil, errAttachmentTooLarge
+		}
+
+		if len(data) == 0 {
+			return "", nil, errNoAttachmentFile
+		}
+
+		return attachmentName(part.FileName()), data, nil
+	}
+}
+
+// attachmentName keeps the last element of an uploaded file's name, without control characters.
+func attachmentName(filename string) string {
+	name := strings.Map(func(r rune) rune {
+		if r < ' ' || r == 0x7f {
+			return -1
+		}
+
+		return r
+	}, filepath.Base(strings.ReplaceAll(filename, "\\", "/")))
+
+	for len(name) > maxAttachmentNameLength {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+
+	if name = strings.TrimSpace(name); name == "" || name == "." || name == ".." || name == "/" {
+		return "attachment"
+	}
+
+	return name
+}
+
+// isMaxBytesError reports whether err is from reading past http.MaxBytesReader's limit, which only says so in its
+// message.
+func isMaxBytesError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "request body too large")
+}
+
+// showAttachment serves an attachment's file.
+func (s *Server) showAttachment(w http.ResponseWriter, r *http.Request) {
+	attachment, ok := s.fetchAttachment(w, r)
+	if !ok {
+		return
+	}
+
+	s.serveBlob(w, r, attachment.Key(), attachment.ContentType, attachment.Name, attachment.IsImage())
+}
+
+// showThumbnail serves an image attachment's thumbnail, once it has one.
+func (s *Server) showThumbnail(w http.ResponseWriter, r *http.Request) {
+	attachment, ok := s.fetchAttachment(w, r)
+	if !ok {
+		return
+	}
+
+	if !attachment.Thumbnail {
+		s.render.JSON(w, http.StatusNotFound, errorMap(errAttachmentNotAvailable))
+		return
+	}
+
+	s.serveBlob(w, r, attachment.ThumbnailKey(), "image/jpeg", attachment.Name, true)
+}
+
+// fetchAttachment fetches the attachment in the URL, if the user can see it: unsent attachments are only their
+// uploader's, and sent ones are their channel's until their message is deleted. Others are rendered as not found.
+func (s *Server) fetchAttachment(w http.ResponseWriter, r *http.Request) (model.Attachment, bool) {
+	logger := ctxzap.Extract(r.Context())
+
+	user, _ := model.UserFromContext(r.Context())
+	attachment, err := db.NewFetcher[model.Attachment](s.DB).Fetch(r.Context(), chi.URLParam(r, "attachment_id"))
+	if err == nil {
+		err = s.checkAttachmentAccess(r, user, attachment)
+	}
+
+	if err != nil {
+		if err == db.NotFound || err == errAttachmentNotAvailable {
+			s.render.JSON(w, http.StatusNotFound, errorMap(errAttachmentNotAvailable))
+			return model.Attachment{}, false
+		}
+
+		logger.Error("failed to fetch attachment", zap.Error(err))
+		s.render.JSON(w, http.StatusInternalServerError, errorMap(err))
+		return model.Attachment{}, false
+	}
+
+	return attachment, true
+}
+
+func (s *Server) checkAttachmentAccess(r *http.Request, user model.User, attachment model.Attachment) error {
+	if !attachment.Linked() {
+		if attachment.UserID != user.ID {
+			return errAttachmentNotAvailable
+		}
+
+		return nil
+	}
+
+	if err := s.checkSubscription(r.Context(), user, attachment.ChannelID); err != nil {
+		if err == errNotInChannel {
+			return errAttachmentNotAvailable
+		}
+
+		return err
+	}
+
+	message, err := db.NewFetcher[model.Message](s.DB).Fetch(r.Context(), attachment.MessageID)
+	if err != nil {
+		if err == db.NotFound {
+			return errAttachmentNotAvailable
+		}
+
+		return errors.Wrap(err, "failed to fetch message")
+	}
+
+	if message.Deleted() {
+		return errAttachmentNotAvailable
+	}
+
+	return nil
+}
+
+// serveBlob writes a blob as a download, or inline for images. Either way browsers are kept from sniffing it as
+// something else or running anything in it.
+func (s *Server) serveBlob(w http.ResponseWriter, r *http.Request, key, contentType, name string, inline bool) {
+	logger := ctxzap.Extract(r.Context())
+
+	body, err := s.Blobs.Get(r.Context(), key)
+	if err != nil {
+		if err == blob.NotFound {
+			s.render.JSON(w, http.StatusNotFound, errorMap(errAttachmentNotAvailable))
+			return
+		}
+
+		logger.Error("failed to get blob", zap.Error(err))
+		s.render.JSON(w, http.StatusInternalServerError, errorMap(err))
+		return
+	}
+	defer body.Close()
+
+	disposition := "attachment"
+	if inline {
+		disposition = "inline"
+	}
+
+	if withName := mime.FormatMediaType(disposition, map[string]string{"filename": name}); withName != "" {
+		disposition = withName
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", disposition)
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := io.Copy(w, body); err != nil {
+		logger.Info("failed to write blob", zap.Error(err))
+	}
+}
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAttachmentName(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		want     string
+	}{
+		{"plain", "cat.png", "cat.png"},
+		{"path", "photos/cat.png", "cat.png"},
+		{"traversal", "../../etc/passwd", "passwd"},
+		{"windows path", `C:\Users\bob\cat.png`, "cat.png"},
+		{"control characters", "ca\x00t\r\n.png\x7f", "cat.png"},
+		{"only dots", "..", "attachment"},
+		{"only a slash", "/", "attachment"},
+		{"empty", "", "attachment"},
+		{"blank", " \t ", "attachment"},
+		{"too long", strings.Repeat("é", maxAttachmentNameLength), strings.Repeat("é", maxAttachmentNameLength/2)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := attachmentName(test.filename); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+// testPNG is a valid PNG whose header claims it's width by height, which is all that's read before thumbnailing.
+func testPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+
+	// The header chunk's data follows the signature and its length and type, and is followed by its checksum
+	data := buf.Bytes()
+	binary.BigEndian.PutUint32(data[16:], uint32(width))
+	binary.BigEndian.PutUint32(data[20:], uint32(height))
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+	return data
+}
+
+// upload posts a file to a channel's attachments, failing the test unless it gets status back, and returns the
+// attachment's ID if it was created.
+func (c *testClient) upload(t *testing.T, channelID, filename string, data []byte, status int) string {
+	t.Helper()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := part.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := form.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	request, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/api/v1/channels/%s/attachments", c.server.URL, channelID), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request.Header.Set("Content-Type", form.FormDataContentType())
+	request.Header.Set("X-Csrf-Token", c.token)
+	response, err := c.client.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer response.Body.Close()
+	var raw bytes.Buffer
+	if _, err := raw.ReadFrom(response.Body); err != nil {
+		t.Fatal(err)
+	}
+
+	if response.StatusCode != status {
+		t.Fatalf("upload %s got %d, want %d: %s", filename, response.StatusCode, status, raw.String())
+	}
+
+	var created struct {
+		Attachment struct {
+			ID string `json:"attachmentID"`
+		}
+	}
+	if status == http.StatusCreated {
+		if err := json.Unmarshal(raw.Bytes(), &created); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return created.Attachment.ID
+}
+
+func TestServer_Attachments(t *testing.T) {
+	server := newTestServer(t)
+	alice := newTestClient(t, server, "alice")
+	bob := newTestClient(t, server, "bob")
+	carol := newTestClient(t, server, "carol")
+
+	var created struct {
+		Channel struct {
+			ID string `json:"channelID"`
+		}
+	}
+	alice.do(t, http.MethodPost, "/api/v1/channels", map[string]any{"name": "files"}, http.StatusCreated, &created)
+	channelID := created.Channel.ID
+	bob.do(t, http.MethodPost, fmt.Sprintf("/api/v1/channels/%s/join", channelID), nil, http.StatusCreated, nil)
+
+	t.Run("uploads", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			filename string
+			data     []byte
+			status   int
+		}{
+			{"image", "cat.png", testPNG(t, 1, 1), http.StatusCreated},
+			{"text", "notes.txt", []byte("just some notes"), http.StatusCreated},
+			{"too large", "big.txt", bytes.Repeat([]byte("a"), maxAttachmentSize+1), http.StatusRequestEntityTooLarge},
+			{"empty", "empty.txt", nil, http.StatusBadRequest},
+			{"sniffed, not named", "page.png", []byte("<html><script>alert(1)</script></html>"), http.StatusUnsupportedMediaType},
+			{"too many pixels", "huge.png", testPNG(t, 10_000, 10_000), http.StatusBadRequest},
+			{"not an image", "broken.png", append(testPNG(t, 1, 1)[:20], 0, 0), http.StatusBadRequest},
+		}
+
+		for _, test := range tests {
+			t.Run(test.name, func(t *testing.T) {
+				alice.upload(t, channelID, test.filename, test.data, test.status)
+			})
+		}
+
+		carol.upload(t, channelID, "notes.txt", []byte("let me in"), http.StatusUnauthorized)
+	})
+
+	t.Run("too many for a message", func(t *testing.T) {
+		var attachmentIDs []string
+		for i := 0; i <= maxMessageAttachments; i++ {
+			attachmentIDs = append(attachmentIDs, fmt.Sprintf("attachment-%d", i))
+		}
+
+		body := map[string]any{"body": "lots", "attachmentIDs": attachmentIDs}
+		alice.do(t, http.MethodPost, fmt.Sprintf("/api/v1/channels/%s/messages", channelID), body, http.StatusBadRequest, nil)
+	})
+
+	t.Run("access", func(t *testing.T) {
+		attachmentID := alice.upload(t, channelID, "notes.txt", []byte("for the channel"), http.StatusCreated)
+		attachmentPath := fmt.Sprintf("/api/v1/attachments/%s", attachmentID)
+		expect := func(t *testing.T, statuses map[*testClient]int) {
+			t.Helper()
+
+			for c, status := range statuses {
+				c.do(t, http.MethodGet, attachmentPath, nil, status, nil)
+			}
+		}
+
+		// Unsent, it's only the uploader's
+		expect(t, map[*testClient]int{alice: http.StatusOK, bob: http.StatusNotFound, carol: http.StatusNotFound})
+
+		var sent struct{ Message testMessage }
+		body := map[string]any{"body": "see attached", "attachmentIDs": []string{attachmentID}}
+		alice.do(t, http.MethodPost, fmt.Sprintf("/api/v1/channels/%s/messages", channelID), body, http.StatusCreated, &sent)
+		expect(t, map[*testClient]int{alice: http.StatusOK, bob: http.StatusOK, carol: http.StatusNotFound})
+
+		// Deleting the message takes its attachments with it
+		alice.do(t, http.MethodDelete, fmt.Sprintf("/api/v1/channels/%s/messages/%s", channelID, sent.Message.ID), nil, http.StatusOK, nil)
+		expect(t, map[*testClient]int{alice: http.StatusNotFound, bob: http.StatusNotFound, carol: http.StatusNotFound})
+	})
+}
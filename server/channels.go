@@ -7,9 +7,9 @@ import (
 	"strings"
 	"time"
 
-	"cloud.google.com/go/firestore"
 	"github.com/broothie/slink.chat/async/job"
 	"github.com/broothie/slink.chat/db"
+	"github.com/broothie/slink.chat/event"
 	"github.com/broothie/slink.chat/model"
 	"github.com/broothie/slink.chat/util"
 	"github.com/go-chi/chi/v5"
@@ -17,6 +17,7 @@ import (
 	"github.com/rs/xid"
 	"github.com/samber/lo"
 	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
 )
 
 func (s *Server) createChannel(w http.ResponseWriter, r *http.Request) {
@@ -37,11 +38,14 @@ func (s *Server) createChannel(w http.ResponseWriter, r *http.Request) {
 		UpdatedAt: now,
 		Name:      params.Name,
 		UserID:    user.ID,
-		UserIDs:   []string{user.ID},
 		Private:   params.Private,
 	}
 
-	if _, err := s.DB.CollectionFor(channel.Type()).Doc(channel.ID).Create(r.Context(), channel); err != nil {
+	subscription := model.NewSubscription(user.ID, channel.ID, model.RoleOwner, now)
+	batch := s.DB.Batch()
+	batch.Create(s.DB.CollectionFor(channel.Type()).Doc(channel.ID), channel)
+	batch.Create(s.DB.CollectionFor(subscription.Type()).Doc(subscription.ID), subscription)
+	if err := batch.Commit(r.Context()); err != nil {
 		logger.Error("failed to create channel", zap.Error(err))
 		s.render.JSON(w, http.StatusBadRequest, errorMap(err))
 		return
@@ -71,9 +75,9 @@ func (s *Server) upsertChat(w http.ResponseWriter, r *http.Request) {
 		userIDs = append(userIDs, user.ID)
 	}
 
-	sort.Slice(userIDs, func(i, j int) bool { return userIDs[i] < userIDs[j] })
-	if channel, err := db.NewFetcher[model.Channel](s.DB).FetchFirst(r.Context(), func(query firestore.Query) firestore.Query {
-		return query.Where("user_ids", "==", userIDs).Where("private", "==", true)
+	chatKey := model.ChatKey(userIDs)
+	if channel, err := db.NewFetcher[model.Channel](s.DB).FetchFirst(r.Context(), func(query db.Query) db.Query {
+		return query.Where("chat_key", "==", chatKey).Where("private", "==", true)
 	}); err == nil {
 		logger.Info("chat already exists")
 		s.render.JSON(w, http.StatusOK, util.Map{"channel": channel})
@@ -97,11 +101,18 @@ func (s *Server) upsertChat(w http.ResponseWriter, r *http.Request) {
 		UpdatedAt: now,
 		Name:      name,
 		UserID:    user.ID,
-		UserIDs:   userIDs,
+		ChatKey:   chatKey,
 		Private:   true,
 	}
 
-	if _, err := s.DB.CollectionFor(channel.Type()).Doc(channel.ID).Create(r.Context(), channel); err != nil {
+	batch := s.DB.Batch()
+	batch.Create(s.DB.CollectionFor(channel.Type()).Doc(channel.ID), channel)
+	for _, member := range users {
+		subscription := model.NewSubscription(member.ID, channel.ID, lo.Ternary(member.ID == user.ID, model.RoleOwner, model.RoleMember), now)
+		batch.Create(s.DB.CollectionFor(subscription.Type()).Doc(subscription.ID), subscription)
+	}
+
+	if err := batch.Commit(r.Context()); err != nil {
 		logger.Error("failed to create channel", zap.Error(err))
 		s.render.JSON(w, http.StatusBadRequest, errorMap(err))
 		return
@@ -114,9 +125,17 @@ func (s *Server) indexChannels(w http.ResponseWriter, r *http.Request) {
 	logger := ctxzap.Extract(r.Context())
 
 	user, _ := model.UserFromContext(r.Context())
-	channelSlice, err := db.NewFetcher[model.Channel](s.DB).Query(r.Context(), func(query firestore.Query) firestore.Query {
-		return query.Where("user_ids", "array-contains", user.ID)
+	subscriptionSlice, err := db.NewFetcher[model.Subscription](s.DB).Query(r.Context(), func(query db.Query) db.Query {
+		return query.Where("user_id", "==", user.ID)
 	})
+	if err != nil {
+		logger.Error("failed to fetch subscriptions", zap.Error(err))
+		s.render.JSON(w, http.StatusInternalServerError, errorMap(err))
+		return
+	}
+
+	channelIDs := lo.Map(subscriptionSlice, func(subscription model.Subscription, _ int) string { return subscription.ChannelID })
+	channelSlice, err := db.NewFetcher[model.Channel](s.DB).FetchMany(r.Context(), channelIDs...)
 	if err != nil {
 		logger.Error("failed to fetch channels", zap.Error(err))
 		s.render.JSON(w, http.StatusInternalServerError, errorMap(err))
@@ -124,7 +143,28 @@ func (s *Server) indexChannels(w http.ResponseWriter, r *http.Request) {
 	}
 
 	channels := lo.Associate(channelSlice, func(channel model.Channel) (string, model.Channel) { return channel.ID, channel })
-	s.render.JSON(w, http.StatusOK, util.Map{"channels": channels})
+	subscriptions := lo.Associate(subscriptionSlice, func(subscription model.Subscription) (string, model.Subscription) {
+		return subscription.ChannelID, subscription
+	})
+
+	unreadSlice := make([]event.Unread, len(subscriptionSlice))
+	group, ctx := errgroup.WithContext(r.Context())
+	for i, subscription := range subscriptionSlice {
+		i, subscription := i, subscription
+		group.Go(func() (err error) {
+			unreadSlice[i], err = s.unread(ctx, user, subscription)
+			return err
+		})
+	}
+
+	if err := group.Wait(); err != nil {
+		logger.Error("failed to count unread messages", zap.Error(err))
+		s.render.JSON(w, http.StatusInternalServerError, errorMap(err))
+		return
+	}
+
+	unreads := lo.Associate(unreadSlice, func(unread event.Unread) (string, event.Unread) { return unread.ChannelID, unread })
+	s.render.JSON(w, http.StatusOK, util.Map{"channels": channels, "subscriptions": subscriptions, "unreads": unreads})
 }
 
 func (s *Server) showChannel(w http.ResponseWriter, r *http.Request) {
@@ -148,6 +188,12 @@ func (s *Server) showChannel(w http.ResponseWriter, r *http.Request) {
 func (s *Server) searchChannels(w http.ResponseWriter, r *http.Request) {
 	logger := ctxzap.Extract(r.Context())
 
+	params, err := pageParams(r)
+	if err != nil {
+		s.render.JSON(w, http.StatusBadRequest, errorMap(err))
+		return
+	}
+
 	query := r.URL.Query().Get("query")
 	if query == "" {
 		s.render.JSON(w, http.StatusOK, util.Map{"channels": []model.Channel{}})
@@ -161,7 +207,13 @@ func (s *Server) searchChannels(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	s.render.JSON(w, http.StatusOK, util.Map{"channels": channels})
+	page, err := db.PageSlice(channels, func(channel model.Channel) string { return channel.ID }, "name", params)
+	if err != nil {
+		s.render.JSON(w, http.StatusBadRequest, errorMap(err))
+		return
+	}
+
+	s.render.JSON(w, http.StatusOK, util.Map{"channels": page.Items, "next": page.Next, "prev": page.Prev})
 }
 
 func (s *Server) joinChannel(w http.ResponseWriter, r *http.Request) {
@@ -169,8 +221,19 @@ func (s *Server) joinChannel(w http.ResponseWriter, r *http.Request) {
 
 	user, _ := model.UserFromContext(r.Context())
 	channelID := chi.URLParam(r, "channel_id")
-	updates := []firestore.Update{{Path: "user_ids", Value: firestore.ArrayUnion(user.ID)}}
-	if _, err := s.DB.CollectionFor(model.TypeChannel).Doc(channelID).Update(r.Context(), updates); err != nil {
+	if _, err := db.NewFetcher[model.Channel](s.DB).Fetch(r.Context(), channelID); err != nil {
+		if err == db.NotFound {
+			s.render.JSON(w, http.StatusBadRequest, errorMap(err))
+			return
+		}
+
+		logger.Error("failed to fetch channel", zap.Error(err))
+		s.render.JSON(w, http.StatusInternalServerError, errorMap(err))
+		return
+	}
+
+	subscription := model.NewSubscription(user.ID, channelID, model.RoleMember, time.Now())
+	if err := s.DB.CollectionFor(subscription.Type()).Doc(subscription.ID).Create(r.Context(), subscription); err != nil && err != db.AlreadyExists {
 		logger.Error("failed to create subscription", zap.Error(err))
 		s.render.JSON(w, http.StatusInternalServerError, errorMap(err))
 		return
@@ -184,8 +247,7 @@ func (s *Server) leaveChannel(w http.ResponseWriter, r *http.Request) {
 
 	user, _ := model.UserFromContext(r.Context())
 	channelID := chi.URLParam(r, "channel_id")
-	updates := []firestore.Update{{Path: "user_ids", Value: firestore.ArrayRemove(user.ID)}}
-	if _, err := s.DB.CollectionFor(model.TypeChannel).Doc(channelID).Update(r.Context(), updates); err != nil {
+	if err := s.DB.CollectionFor(model.TypeSubscription).Doc(model.SubscriptionID(user.ID, channelID)).Delete(r.Context()); err != nil {
 		logger.Error("failed to delete subscription", zap.Error(err))
 		s.render.JSON(w, http.StatusInternalServerError, errorMap(err))
 		return
@@ -209,7 +271,17 @@ func (s *Server) indexChannelUsers(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userSlice, err := db.NewFetcher[model.User](s.DB).FetchMany(r.Context(), channel.UserIDs...)
+	subscriptions, err := db.NewFetcher[model.Subscription](s.DB).Query(r.Context(), func(query db.Query) db.Query {
+		return query.Where("channel_id", "==", channel.ID)
+	})
+	if err != nil {
+		logger.Error("failed to fetch subscriptions", zap.Error(err))
+		s.render.JSON(w, http.StatusInternalServerError, errorMap(err))
+		return
+	}
+
+	userIDs := lo.Map(subscriptions, func(subscription model.Subscription, _ int) string { return subscription.UserID })
+	userSlice, err := db.NewFetcher[model.User](s.DB).FetchMany(r.Context(), userIDs...)
 	if err != nil {
 		logger.Error("failed to fetch users", zap.Error(err))
 		s.render.JSON(w, http.StatusInternalServerError, errorMap(err))
@@ -1,24 +1,24 @@
 package server
 
 import (
-	"encoding/json"
+	"context"
 	"net/http"
+	"time"
 
-	"cloud.google.com/go/firestore"
+	"github.com/broothie/slink.chat/db"
+	"github.com/broothie/slink.chat/event"
 	"github.com/broothie/slink.chat/model"
 	"github.com/gorilla/websocket"
 	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"github.com/pkg/errors"
 	"github.com/samber/lo"
 	"go.uber.org/zap"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
 )
 
 func (s *Server) channelsSocket(w http.ResponseWriter, r *http.Request) {
 	logger := ctxzap.Extract(r.Context()).With(zap.String("at", "channelsSocket"))
 
-	upgrader := &websocket.Upgrader{ReadBufferSize: 1024, WriteBufferSize: 1024}
-	conn, err := upgrader.Upgrade(w, r, nil)
+	sock, err := s.upgrade(w, r, logger)
 	if err != nil {
 		logger.Error("failed to upgrade request", zap.Error(err))
 		s.render.JSON(w, http.StatusInternalServerError, errorMap(err))
@@ -26,19 +26,23 @@ func (s *Server) channelsSocket(w http.ResponseWriter, r *http.Request) {
 	}
 
 	defer func() {
-		if err := conn.Close(); err != nil {
+		if err := sock.close(); err != nil {
 			logger.Error("failed to close connection", zap.Error(err))
 		}
 	}()
 
-	socketCloseChan := make(chan struct{})
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
 	go func() {
-		defer close(socketCloseChan)
+		defer cancel()
 
 		for {
-			if _, _, err := conn.NextReader(); err != nil {
-				if _, isCloseErr := err.(*websocket.CloseError); !isCloseErr {
-					logger.Error("next reader error", zap.Error(err))
+			if _, _, err := sock.nextReader(); err != nil {
+				if _, isCloseErr := err.(*websocket.CloseError); isCloseErr {
+					logger.Info("client closed socket")
+				} else {
+					logger.Info("next reader error", zap.Error(err))
 				}
 
 				return
@@ -46,81 +50,202 @@ func (s *Server) channelsSocket(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
-	user, _ := model.UserFromContext(r.Context())
-	dbCloseChan := make(chan struct{})
-	channels := make(chan model.Channel)
-	go func() {
-		defer close(dbCloseChan)
+	user, _ := model.UserFromContext(ctx)
+	logger.Debug("channels socket opened")
+	sock.stream(ctx, s.chatsFeed(user, time.Time{}))
+}
 
-		logger.Debug("listening for channel updates")
-		snapshots := s.DB.CollectionFor(model.TypeChannel).
-			Where("private", "==", true).
-			Where("user_ids", "array-contains", user.ID).
-			Snapshots(r.Context())
-		defer snapshots.Stop()
+// watchSubscriptions watches each channel the user is subscribed to, starting and stopping as they join and leave,
+// until ctx is done or the listener ends. Channel changes and unread counts are sent down frames.
+func (s *Server) watchSubscriptions(ctx context.Context, user model.User, frames chan<- event.Envelope, dropped func()) {
+	logger := ctxzap.Extract(ctx).With(zap.String("at", "watchSubscriptions"))
 
-		for {
-			snapshot, err := snapshots.Next()
-			if err != nil {
-				if status.Code(err) == codes.DeadlineExceeded {
-					logger.Debug("db listen timeout", zap.Error(err))
-					return
-				} else if status.Code(err) == codes.Canceled {
-					return
-				}
+	logger.Debug("listening for subscription updates")
+	snapshots := s.DB.CollectionFor(model.TypeSubscription).
+		Where("user_id", "==", user.ID).
+		Snapshots(ctx)
+	defer snapshots.Stop()
+
+	watches := make(map[string]chatWatch)
+	defer func() {
+		for _, watch := range watches {
+			watch.cancel()
+		}
+	}()
 
-				logger.Error("next snapshot error", zap.Error(err))
+	for {
+		changes, err := snapshots.Next()
+		if err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				logger.Debug("db listen timeout", zap.Error(err))
+				return
+			} else if errors.Is(err, context.Canceled) {
 				return
 			}
 
-			if snapshot == nil {
+			logger.Error("next snapshot error", zap.Error(err))
+			return
+		}
+
+		for _, change := range changes {
+			var subscription model.Subscription
+			if err := change.Doc.DataTo(&subscription); err != nil {
+				logger.Error("failed to read subscription change", zap.Error(err))
 				continue
 			}
 
-			change, found := lo.Find(snapshot.Changes, func(change firestore.DocumentChange) bool {
-				return change.Kind == firestore.DocumentModified
-			})
+			switch change.Kind {
+			case db.DocumentAdded:
+				if _, ok := watches[subscription.ChannelID]; ok {
+					continue
+				}
 
-			if !found {
-				continue
-			}
+				ctx, cancel := context.WithCancel(ctx)
+				watch := chatWatch{cancel: cancel, subscriptions: make(chan model.Subscription, 1)}
+				watches[subscription.ChannelID] = watch
+				go s.watchChat(ctx, user, subscription, watch.subscriptions, frames, dropped)
 
-			var channel model.Channel
-			if err := change.Doc.DataTo(&channel); err != nil {
-				logger.Error("failed to read channel change", zap.Error(err))
-				continue
-			}
+			case db.DocumentModified:
+				if watch, ok := watches[subscription.ChannelID]; ok {
+					watch.update(subscription)
+				}
 
-			channels <- channel
+			case db.DocumentRemoved:
+				if watch, ok := watches[subscription.ChannelID]; ok {
+					watch.cancel()
+					delete(watches, subscription.ChannelID)
+				}
+			}
 		}
-	}()
+	}
+}
 
-	logger.Debug("channels socket opened")
+// chatWatch is a running watchChat, and where to hand it the user's changes to their subscription.
+type chatWatch struct {
+	cancel        context.CancelFunc
+	subscriptions chan model.Subscription
+}
+
+// update hands the watch subscription without blocking, replacing any it hasn't picked up yet.
+func (w chatWatch) update(subscription model.Subscription) {
 	for {
 		select {
-		case <-socketCloseChan:
-			logger.Info("client closed socket")
+		case w.subscriptions <- subscription:
 			return
+		case <-w.subscriptions:
+		}
+	}
+}
 
-		case <-dbCloseChan:
-			logger.Info("db closed stream")
-			return
+// watchChat sends a channel down frames each time it's modified, if it's an unmuted private chat, followed by the
+// user's unread counts for it if it has new messages. New messages are counted from what the channel says about
+// its last one, so a message costs subscribers no reads. Unread counts are only recounted when the user's
+// subscription changes, from subscriptions. dropped is called if the hub drops the watch for falling behind.
+func (s *Server) watchChat(ctx context.Context, user model.User, subscription model.Subscription, subscriptions <-chan model.Subscription, frames chan<- event.Envelope, dropped func()) {
+	logger := ctxzap.Extract(ctx).With(zap.String("at", "watchChat"), zap.String("channel_id", subscription.ChannelID))
 
-		case channel := <-channels:
-			socketWriter, err := conn.NextWriter(websocket.TextMessage)
-			if err != nil {
-				logger.Error("failed to get writer", zap.Error(err))
+	client := s.hub.Subscribe(channelTopic(subscription.ChannelID), s.channelSource(subscription.ChannelID))
+	defer client.Close()
+
+	send := func(envelope event.Envelope) bool {
+		select {
+		case frames <- envelope:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	unread := chatUnread{user: user, subscription: subscription}
+	for {
+		var envelope any
+		var ok bool
+		select {
+		case envelope, ok = <-client.Events():
+		case subscription := <-subscriptions:
+			if err := unread.recount(ctx, s, subscription); err != nil {
+				logger.Error("failed to count unread messages", zap.Error(err))
 				continue
 			}
 
-			if err := json.NewEncoder(socketWriter).Encode(channel); err != nil {
-				logger.Error("failed to write json to socket", zap.Error(err))
-				continue
+			if !send(event.New(event.UnreadUpdated, subscription.ChannelID, unread.unread)) {
+				return
+			}
+
+			continue
+		case <-ctx.Done():
+			return
+		}
+
+		if !ok {
+			if client.Dropped() {
+				dropped()
 			}
 
-			if err := socketWriter.Close(); err != nil {
-				logger.Error("failed to close socket writer", zap.Error(err))
+			return
+		}
+
+		update := envelope.(event.Envelope)
+		channel := update.Payload.(model.Channel)
+		if channel.Private && !unread.subscription.Muted {
+			if !send(update) {
+				return
 			}
 		}
+
+		changed, err := unread.count(ctx, s, channel)
+		if err != nil {
+			logger.Error("failed to count unread messages", zap.Error(err))
+			continue
+		}
+
+		if changed && !send(event.New(event.UnreadUpdated, channel.ID, unread.unread)) {
+			return
+		}
+	}
+}
+
+// chatUnread is a watched channel's unread counts for user, as of the channel's message seq.
+type chatUnread struct {
+	user         model.User
+	subscription model.Subscription
+	unread       event.Unread
+	seq          int64
+	counted      bool
+}
+
+// recount counts the unread messages afresh for subscription, as the user's read marker may have moved.
+func (c *chatUnread) recount(ctx context.Context, s *Server, subscription model.Subscription) error {
+	unread, seq, err := s.countUnread(ctx, c.user, subscription)
+	if err != nil {
+		return err
+	}
+
+	c.subscription, c.unread, c.seq, c.counted = subscription, unread, seq, true
+	return nil
+}
+
+// count brings the unread counts up to channel's last message, reporting whether they changed. A single new
+// message is counted from what the channel says about it. Anything else, like messages arriving faster than channel
+// updates or counts not yet made, is recounted.
+func (c *chatUnread) count(ctx context.Context, s *Server, channel model.Channel) (bool, error) {
+	if c.counted && channel.LastMessageSeq <= c.seq {
+		return false, nil
 	}
+
+	if !c.counted || channel.LastMessageSeq != c.seq+1 || channel.LastMessageUserID == "" {
+		return true, c.recount(ctx, s, c.subscription)
+	}
+
+	c.seq = channel.LastMessageSeq
+	if channel.LastMessageUserID == c.user.ID || (c.subscription.LastReadSeq == 0 && channel.LastMessageSentAt.Before(c.subscription.JoinedAt)) {
+		return false, nil
+	}
+
+	c.unread.UnreadCount = lo.Min([]int{c.unread.UnreadCount + 1, maxUnreadCount})
+	if lo.Contains(channel.LastMessageMentions, c.user.ID) {
+		c.unread.MentionCount = lo.Min([]int{c.unread.MentionCount + 1, maxUnreadCount})
+	}
+
+	return true, nil
 }
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/broothie/slink.chat/event"
+	"github.com/broothie/slink.chat/model"
+)
+
+// TestChatUnread_Count counts single new messages from the channel alone; the nil server would panic on a read.
+func TestChatUnread_Count(t *testing.T) {
+	joined := time.Now()
+	user := model.User{ID: "alice"}
+	tests := []struct {
+		name        string
+		lastReadSeq int64
+		channel     model.Channel
+		changed     bool
+		want        event.Unread
+	}{
+		{"no new messages", 1, model.Channel{LastMessageSeq: 3, LastMessageUserID: "bob"}, false, event.Unread{UnreadCount: 2}},
+		{"new message", 1, model.Channel{LastMessageSeq: 4, LastMessageUserID: "bob"}, true, event.Unread{UnreadCount: 3}},
+		{"mention", 1, model.Channel{LastMessageSeq: 4, LastMessageUserID: "bob", LastMessageMentions: []string{"alice"}}, true, event.Unread{UnreadCount: 3, MentionCount: 1}},
+		{"own message", 1, model.Channel{LastMessageSeq: 4, LastMessageUserID: "alice"}, false, event.Unread{UnreadCount: 2}},
+		{"from before joining", 0, model.Channel{LastMessageSeq: 4, LastMessageUserID: "bob", LastMessageSentAt: joined.Add(-time.Minute)}, false, event.Unread{UnreadCount: 2}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			unread := chatUnread{
+				user:         user,
+				subscription: model.Subscription{LastReadSeq: test.lastReadSeq, JoinedAt: joined},
+				unread:       event.Unread{UnreadCount: 2},
+				seq:          3,
+				counted:      true,
+			}
+
+			changed, err := unread.count(context.Background(), nil, test.channel)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if changed != test.changed || unread.unread != test.want || unread.seq != test.channel.LastMessageSeq {
+				t.Errorf("got %t %+v at %d, want %t %+v at %d", changed, unread.unread, unread.seq, test.changed, test.want, test.channel.LastMessageSeq)
+			}
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package server
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/broothie/slink.chat/db"
+	"github.com/broothie/slink.chat/event"
+	"github.com/broothie/slink.chat/model"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+)
+
+// Feeds end with these when it's not the client that went away.
+var (
+	errFeedDropped = errors.New("client fell behind")
+	errFeedEnded   = errors.New("db closed stream")
+)
+
+// A feed hands a client's events to send, in order, until ctx is done, send returns false, or the feed ends. Feeds
+// are the delivery core every transport shares; the transport only decides how an event gets to the client.
+type feed func(ctx context.Context, send func(event.Envelope) bool) error
+
+// channelFeed is what a client following a channel sees: what it missed since after and since, if it's resuming,
+// then the channel's live events. The user's own typing is left out.
+func (s *Server) channelFeed(user model.User, channelID, after string, since time.Time) feed {
+	return func(ctx context.Context, send func(event.Envelope) bool) error {
+		client := s.hub.Subscribe(eventsTopic(channelID), s.eventSource(channelID))
+		defer client.Close()
+
+		replay, err := s.replay(ctx, channelID, after, since)
+		if err != nil {
+			ctxzap.Extract(ctx).Error("failed to replay messages", zap.Error(err), zap.String("channel_id", channelID))
+			replay = []event.Envelope{refetchRequired(channelID, "replay failed")}
+		}
+
+		for _, envelope := range replay {
+			if !send(envelope) {
+				return nil
+			}
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+
+			case envelope, ok := <-client.Events():
+				if !ok {
+					if client.Dropped() {
+						return errFeedDropped
+					}
+
+					return errFeedEnded
+				}
+
+				if fromSelf(envelope.(event.Envelope), user.ID) || replayed(envelope.(event.Envelope), replay) {
+					continue
+				}
+
+				if !send(envelope.(event.Envelope)) {
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// chatsFeed is what a client following the user's channel list sees: updates to their private chats, unread
+// counts for every channel they're in, and their notifications. A client resuming from since gets what changed
+// after it first.
+func (s *Server) chatsFeed(user model.User, since time.Time) feed {
+	return func(ctx context.Context, send func(event.Envelope) bool) error {
+		ctx, cancel := context.WithCancel(ctx)
+
+		frames := make(chan event.Envelope)
+		slowChan := make(chan struct{})
+		var slowOnce sync.Once
+		dropped := func() { slowOnce.Do(func() { close(slowChan) }) }
+
+		dbCloseChan := make(chan struct{})
+		go func() {
+			defer close(dbCloseChan)
+			s.watchSubscriptions(ctx, user, frames, dropped)
+		}()
+
+		// Stop listening before returning, so the listener doesn't outlive a vanished client
+		defer func() {
+			cancel()
+			<-dbCloseChan
+		}()
+
+		go s.watchNotifications(ctx, user, frames)
+
+		if !since.IsZero() {
+			missed, err := s.chatsSince(ctx, user, since)
+			if err != nil {
+				ctxzap.Extract(ctx).Error("failed to catch up chats", zap.Error(err))
+				missed = []event.Envelope{refetchRequired("", "catch up failed")}
+			}
+
+			for _, envelope := range missed {
+				if !send(envelope) {
+					return nil
+				}
+			}
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+
+			case <-dbCloseChan:
+				return errFeedEnded
+
+			case <-slowChan:
+				return errFeedDropped
+
+			case frame := <-frames:
+				if !send(frame) {
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// chatsSince is what changed in the user's channel list after since: their private chats, unread counts for
+// channels with new messages or reads, and their notifications. Events are stamped with when their change
+// happened, and come in that order.
+func (s *Server) chatsSince(ctx context.Context, user model.User, since time.Time) ([]event.Envelope, error) {
+	subscriptions, err := db.NewFetcher[model.Subscription](s.DB).Query(ctx, func(query db.Query) db.Query {
+		return query.Where("user_id", "==", user.ID)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch subscriptions")
+	}
+
+	channelIDs := lo.Map(subscriptions, func(subscription model.Subscription, _ int) string { return subscription.ChannelID })
+	channelSlice, err := db.NewFetcher[model.Channel](s.DB).FetchMany(ctx, channelIDs...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch channels")
+	}
+
+	channels := lo.Associate(channelSlice, func(channel model.Channel) (string, model.Channel) { return channel.ID, channel })
+
+	var missed []event.Envelope
+	for _, subscription := range subscriptions {
+		channel, ok := channels[subscription.ChannelID]
+		if !ok || !(channel.UpdatedAt.After(since) || subscription.UpdatedAt.After(since)) {
+			continue
+		}
+
+		if channel.Private && !subscription.Muted && channel.UpdatedAt.After(since) {
+			missed = append(missed, stamped(event.New(event.ChannelUpdated, channel.ID, channel), channel.UpdatedAt))
+		}
+
+		unread, err := s.unread(ctx, user, subscription)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to count unread messages")
+		}
+
+		changedAt := lo.Ternary(channel.UpdatedAt.After(subscription.UpdatedAt), channel.UpdatedAt, subscription.UpdatedAt)
+		missed = append(missed, stamped(event.New(event.UnreadUpdated, channel.ID, unread), changedAt))
+	}
+
+	notifications, err := db.NewFetcher[model.Notification](s.DB).Query(ctx, func(query db.Query) db.Query {
+		return query.
+			Where("user_id", "==", user.ID).
+			Where("updated_at", ">", since).
+			OrderBy("updated_at", db.Asc).
+			Limit(maxPollEvents)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch notifications")
+	}
+
+	for _, notification := range notifications {
+		missed = append(missed, stamped(notificationEvent(notification), notification.UpdatedAt))
+	}
+
+	sort.SliceStable(missed, func(i, j int) bool { return missed[i].TS.Before(missed[j].TS) })
+	return missed, nil
+}
+
+func stamped(envelope event.Envelope, at time.Time) event.Envelope {
+	envelope.TS = at
+	return envelope
+}
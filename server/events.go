@@ -0,0 +1,17 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/broothie/slink.chat/event"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+)
+
+// eventSchema serves the JSON schema of everything the realtime sockets write.
+func (s *Server) eventSchema(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/schema+json")
+	if _, err := w.Write(event.Schema); err != nil {
+		ctxzap.Extract(r.Context()).Error("failed to write event schema", zap.Error(err))
+	}
+}
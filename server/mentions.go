@@ -0,0 +1,60 @@
+package server
+
+import (
+	"context"
+
+	"github.com/broothie/slink.chat/db"
+	"github.com/broothie/slink.chat/format"
+	"github.com/broothie/slink.chat/model"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+)
+
+// maxInValues is the most values an in filter takes on Firestore.
+const maxInValues = 10
+
+// resolveMentions returns the IDs of the members of a channel that body mentions. Formatting doesn't count, so
+// mentioning someone in bold still mentions them. Only users whose screennames body could mention are looked up.
+func (s *Server) resolveMentions(ctx context.Context, channelID, body string) ([]string, error) {
+	plain := format.Plain(format.Parse(body))
+	candidates := model.MentionCandidates(plain)
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	var users []model.User
+	for _, keys := range lo.Chunk(candidates, maxInValues) {
+		found, err := db.NewFetcher[model.User](s.DB).Query(ctx, func(query db.Query) db.Query {
+			return query.Where("screenname_key", "in", keys)
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to fetch mentioned users")
+		}
+
+		users = append(users, found...)
+	}
+
+	subscriptionIDs := lo.Map(users, func(user model.User, _ int) string { return model.SubscriptionID(user.ID, channelID) })
+	subscriptions, err := db.NewFetcher[model.Subscription](s.DB).FetchMany(ctx, subscriptionIDs...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch subscriptions")
+	}
+
+	// Only members can be mentioned
+	memberIDs := lo.Map(subscriptions, func(subscription model.Subscription, _ int) string { return subscription.UserID })
+	screennames := make([]string, 0, len(subscriptions))
+	byScreenname := make(map[string]string, len(subscriptions))
+	for _, user := range users {
+		if lo.Contains(memberIDs, user.ID) {
+			screennames = append(screennames, user.Screenname)
+			byScreenname[user.Screenname] = user.ID
+		}
+	}
+
+	var mentions []string
+	for _, screenname := range model.MentionedScreennames(plain, screennames) {
+		mentions = append(mentions, byScreenname[screenname])
+	}
+
+	return mentions, nil
+}
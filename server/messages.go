@@ -1,14 +1,20 @@
 package server
 
 import (
+	"context"
+	"encoding/json"
 	"net/http"
+	"time"
 
-	"cloud.google.com/go/firestore"
+	"github.com/broothie/slink.chat/async/job"
 	"github.com/broothie/slink.chat/db"
+	"github.com/broothie/slink.chat/event"
 	"github.com/broothie/slink.chat/model"
 	"github.com/broothie/slink.chat/util"
 	"github.com/go-chi/chi/v5"
 	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"github.com/pkg/errors"
+	"github.com/rs/xid"
 	"github.com/samber/lo"
 	"go.uber.org/zap"
 )
@@ -16,21 +22,402 @@ import (
 func (s *Server) indexMessages(w http.ResponseWriter, r *http.Request) {
 	logger := ctxzap.Extract(r.Context())
 
-	messageSlice, err := db.NewFetcher[model.Message](s.DB).Query(r.Context(), func(query firestore.Query) firestore.Query {
-		return query.
-			Where("channel_id", "==", chi.URLParam(r, "channel_id")).
-			OrderBy("created_at", firestore.Asc).
-			LimitToLast(100)
+	user, _ := model.UserFromContext(r.Context())
+	channelID := chi.URLParam(r, "channel_id")
+	if !s.requireSubscription(w, r, user, channelID) {
+		return
+	}
+
+	params, err := pageParams(r)
+	if err != nil {
+		s.render.JSON(w, http.StatusBadRequest, errorMap(err))
+		return
+	}
+
+	// Replies are left to their threads, unless asked for
+	replies := r.URL.Query().Get("replies") == "true"
+	params.FromEnd = true
+	page, err := db.NewFetcher[model.Message](s.DB).Page(r.Context(), "seq", params, func(query db.Query) db.Query {
+		query = query.Where("channel_id", "==", channelID)
+		if !replies {
+			query = query.Where("parent_id", "==", "")
+		}
+
+		return query
 	})
 	if err != nil {
+		if errors.Is(err, db.InvalidCursor) {
+			s.render.JSON(w, http.StatusBadRequest, errorMap(err))
+			return
+		}
+
 		logger.Error("failed to get messages", zap.Error(err))
 		s.render.JSON(w, http.StatusInternalServerError, errorMap(err))
 		return
 	}
 
-	messages := lo.Associate(messageSlice, func(message model.Message) (string, model.Message) {
-		return message.ID, message
+	s.render.JSON(w, http.StatusOK, util.Map{"messages": page.Items, "next": page.Next, "prev": page.Prev})
+}
+
+// indexReplies lists a thread's replies, oldest first.
+func (s *Server) indexReplies(w http.ResponseWriter, r *http.Request) {
+	logger := ctxzap.Extract(r.Context())
+
+	params, err := pageParams(r)
+	if err != nil {
+		s.render.JSON(w, http.StatusBadRequest, errorMap(err))
+		return
+	}
+
+	// Threads outside the user's channels are as good as missing
+	user, _ := model.UserFromContext(r.Context())
+	channelID := chi.URLParam(r, "channel_id")
+	if err := s.checkSubscription(r.Context(), user, channelID); err != nil {
+		if err == errNotInChannel {
+			s.render.JSON(w, http.StatusNotFound, errorMap(db.NotFound))
+			return
+		}
+
+		logger.Error("failed to check subscription", zap.Error(err))
+		s.render.JSON(w, http.StatusInternalServerError, errorMap(err))
+		return
+	}
+
+	parent, err := db.NewFetcher[model.Message](s.DB).Fetch(r.Context(), chi.URLParam(r, "message_id"))
+	if err == nil && parent.ChannelID != channelID {
+		err = db.NotFound
+	}
+
+	if err != nil {
+		if err == db.NotFound {
+			s.render.JSON(w, http.StatusNotFound, errorMap(err))
+			return
+		}
+
+		logger.Error("failed to fetch parent message", zap.Error(err))
+		s.render.JSON(w, http.StatusInternalServerError, errorMap(err))
+		return
+	}
+
+	page, err := db.NewFetcher[model.Message](s.DB).Page(r.Context(), "seq", params, func(query db.Query) db.Query {
+		return query.Where("channel_id", "==", channelID).Where("parent_id", "==", parent.ID)
 	})
+	if err != nil {
+		if errors.Is(err, db.InvalidCursor) {
+			s.render.JSON(w, http.StatusBadRequest, errorMap(err))
+			return
+		}
+
+		logger.Error("failed to get replies", zap.Error(err))
+		s.render.JSON(w, http.StatusInternalServerError, errorMap(err))
+		return
+	}
+
+	s.render.JSON(w, http.StatusOK, util.Map{"parent": parent, "replies": page.Items, "next": page.Next, "prev": page.Prev})
+}
+
+const (
+	// maxNonceLength caps the nonces clients attach to sends.
+	maxNonceLength = 64
+
+	// maxBodyLength caps message bodies, which is also what keeps formatting them cheap.
+	maxBodyLength = 4096
+)
+
+var (
+	errNonceTooLong = errors.New("nonce too long")
+	errBodyTooLong  = errors.Errorf("body can't be longer than %d bytes", maxBodyLength)
+)
+
+// draft is a message as a client sends it. A nonce makes retrying the send safe, a parent ID makes it a reply, and
+// attachment IDs send attachments uploaded to the channel with it.
+type draft struct {
+	Body          string   `json:"body"`
+	Nonce         string   `json:"nonce"`
+	ParentID      string   `json:"parentID"`
+	AttachmentIDs []string `json:"attachmentIDs"`
+}
+
+// send posts a draft to a channel as user, whichever transport it came in on.
+func (s *Server) send(ctx context.Context, user model.User, channelID string, draft draft) (model.Message, error) {
+	if len(draft.Nonce) > maxNonceLength {
+		return model.Message{}, errNonceTooLong
+	}
+
+	if len(draft.Body) > maxBodyLength {
+		return model.Message{}, errBodyTooLong
+	}
+
+	draft.AttachmentIDs = lo.Uniq(draft.AttachmentIDs)
+	if len(draft.AttachmentIDs) > maxMessageAttachments {
+		return model.Message{}, errTooManyAttachments
+	}
+
+	if err := s.checkSubscription(ctx, user, channelID); err != nil {
+		return model.Message{}, err
+	}
+
+	s.typing.stop(channelID, user.ID)
+	return s.createMessage(ctx, user, channelID, draft)
+}
+
+// sendReply is the frame acking a send, or reporting why it failed. Either references the nonce it was sent with,
+// so the client knows which of its sends it's about.
+func sendReply(logger *zap.Logger, channelID, nonce string, message model.Message, err error) event.Envelope {
+	switch err {
+	case nil:
+		return event.New(event.Ack, channelID, event.AckPayload{Nonce: nonce, MessageID: message.ID, Seq: message.Seq})
+	case errNonceTooLong, errBodyTooLong, errTooManyAttachments, errNotInChannel, db.InvalidParent, db.InvalidAttachment:
+		return event.NewSendError(channelID, nonce, err.Error())
+	}
+
+	logger.Error("failed to send message", zap.Error(err))
+	return event.NewSendError(channelID, nonce, "failed to send message")
+}
+
+// postMessage is send for clients that can't send over a socket.
+func (s *Server) postMessage(w http.ResponseWriter, r *http.Request) {
+	logger := ctxzap.Extract(r.Context())
+
+	var params draft
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		logger.Error("failed to decode params", zap.Error(err))
+		s.render.JSON(w, http.StatusBadRequest, errorMap(err))
+		return
+	}
+
+	user, _ := model.UserFromContext(r.Context())
+	message, err := s.send(r.Context(), user, chi.URLParam(r, "channel_id"), params)
+	if err != nil {
+		switch err {
+		case errNonceTooLong, errBodyTooLong, errTooManyAttachments, db.InvalidParent, db.InvalidAttachment:
+			s.render.JSON(w, http.StatusBadRequest, errorMap(err))
+		case errNotInChannel:
+			s.render.JSON(w, http.StatusUnauthorized, errorMap(err))
+		default:
+			logger.Error("failed to send message", zap.Error(err))
+			s.render.JSON(w, http.StatusInternalServerError, errorMap(err))
+		}
+
+		return
+	}
+
+	s.render.JSON(w, http.StatusCreated, util.Map{"message": message})
+}
+
+// createMessage posts a draft to a channel as user. Messages to private chats are queued for away auto-replies,
+// members the message mentions are notified, and links in it are previewed. With a nonce, sending the same message
+// again returns the one already stored.
+func (s *Server) createMessage(ctx context.Context, user model.User, channelID string, draft draft) (model.Message, error) {
+	mentions, err := s.resolveMentions(ctx, channelID, draft.Body)
+	if err != nil {
+		return model.Message{}, err
+	}
+
+	now := time.Now()
+	message := model.Message{
+		ID:        xid.New().String(),
+		CreatedAt: now,
+		UpdatedAt: now,
+		UserID:    user.ID,
+		ChannelID: channelID,
+		Body:      draft.Body,
+		HTML:      model.RenderBody(draft.Body),
+		ParentID:  draft.ParentID,
+		Mentions:  mentions,
+	}
+
+	if draft.Nonce != "" {
+		message.ID = model.NonceMessageID(channelID, user.ID, draft.Nonce)
+	}
+
+	message, channel, err := db.CreateMessage(ctx, s.DB, message, draft.AttachmentIDs...)
+	if err != nil {
+		if err == db.AlreadyExists && draft.Nonce != "" {
+			return db.NewFetcher[model.Message](s.DB).Fetch(ctx, model.NonceMessageID(channelID, user.ID, draft.Nonce))
+		}
+
+		return model.Message{}, err
+	}
+
+	if channel.Private {
+		if err := s.Async.Do(ctx, job.AutoReplyJob{MessageID: message.ID}); err != nil {
+			ctxzap.Extract(ctx).Error("failed to queue AutoReplyJob", zap.Error(err))
+		}
+	}
+
+	if len(message.Mentions) > 0 {
+		if err := s.Async.Do(ctx, job.MentionJob{MessageID: message.ID}); err != nil {
+			ctxzap.Extract(ctx).Error("failed to queue MentionJob", zap.Error(err))
+		}
+	}
+
+	if len(message.Links()) > 0 {
+		s.queueUnfurl(ctx, message.ID)
+	}
+
+	return message, nil
+}
+
+var (
+	errNotMessageAuthor = errors.New("only the author or a moderator can change a message")
+	errMessageDeleted   = errors.New("message was deleted")
+	errEmptyBody        = errors.New("body can't be empty")
+)
+
+func (s *Server) updateMessage(w http.ResponseWriter, r *http.Request) {
+	var params struct {
+		Body string `json:"body"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		ctxzap.Extract(r.Context()).Error("failed to decode params", zap.Error(err))
+		s.render.JSON(w, http.StatusBadRequest, errorMap(err))
+		return
+	}
+
+	if params.Body == "" {
+		s.render.JSON(w, http.StatusBadRequest, errorMap(errEmptyBody))
+		return
+	}
+
+	if len(params.Body) > maxBodyLength {
+		s.render.JSON(w, http.StatusBadRequest, errorMap(errBodyTooLong))
+		return
+	}
+
+	var edited model.Message
+	s.changeMessage(w, r, func(user model.User, subscription model.Subscription, message *model.Message) (bool, error) {
+		if err := checkAuthor(subscription, *message); err != nil {
+			return false, err
+		}
+
+		if message.Deleted() {
+			return false, errMessageDeleted
+		}
+
+		if message.Body == params.Body {
+			return false, nil
+		}
+
+		message.Edit(user.ID, params.Body, time.Now())
+		edited = *message
+		return true, nil
+	})
+
+	// Previews of links the message already had are kept, so only new links get fetched
+	if edited.ID != "" && len(edited.Links()) > 0 {
+		s.queueUnfurl(r.Context(), edited.ID)
+	}
+}
+
+func (s *Server) queueUnfurl(ctx context.Context, messageID string) {
+	if err := s.Async.Do(ctx, job.UnfurlJob{MessageID: messageID}); err != nil {
+		ctxzap.Extract(ctx).Error("failed to queue UnfurlJob", zap.Error(err))
+	}
+}
+
+// deleteMessage leaves a tombstone in the message's place. Deleting it again changes nothing.
+func (s *Server) deleteMessage(w http.ResponseWriter, r *http.Request) {
+	s.changeMessage(w, r, func(_ model.User, subscription model.Subscription, message *model.Message) (bool, error) {
+		if err := checkAuthor(subscription, *message); err != nil {
+			return false, err
+		}
+
+		if message.Deleted() {
+			return false, nil
+		}
+
+		message.Delete(time.Now())
+		return true, nil
+	})
+}
+
+// indexMessageEdits lists the bodies a message had before each of its edits, oldest first.
+func (s *Server) indexMessageEdits(w http.ResponseWriter, r *http.Request) {
+	logger := ctxzap.Extract(r.Context())
+
+	user, _ := model.UserFromContext(r.Context())
+	channelID := chi.URLParam(r, "channel_id")
+	if !s.requireSubscription(w, r, user, channelID) {
+		return
+	}
+
+	message, err := db.NewFetcher[model.Message](s.DB).Fetch(r.Context(), chi.URLParam(r, "message_id"))
+	if err != nil {
+		if err == db.NotFound {
+			s.render.JSON(w, http.StatusNotFound, errorMap(err))
+			return
+		}
+
+		logger.Error("failed to fetch message", zap.Error(err))
+		s.render.JSON(w, http.StatusInternalServerError, errorMap(err))
+		return
+	}
+
+	if message.ChannelID != channelID {
+		s.render.JSON(w, http.StatusNotFound, errorMap(db.NotFound))
+		return
+	}
+
+	edits := message.Edits
+	if edits == nil {
+		edits = []model.Edit{}
+	}
+
+	s.render.JSON(w, http.StatusOK, util.Map{"edits": edits})
+}
+
+// checkAuthor returns errNotMessageAuthor unless the subscriber wrote message or moderates its channel.
+func checkAuthor(subscription model.Subscription, message model.Message) error {
+	if message.UserID != subscription.UserID && !subscription.Moderates() {
+		return errNotMessageAuthor
+	}
+
+	return nil
+}
+
+// changeMessage applies change to the message in the URL for a member of its channel, rendering the result. Like
+// db.UpdateMessage, change reports whether it changed anything.
+func (s *Server) changeMessage(w http.ResponseWriter, r *http.Request, change func(user model.User, subscription model.Subscription, message *model.Message) (bool, error)) {
+	logger := ctxzap.Extract(r.Context())
+
+	user, _ := model.UserFromContext(r.Context())
+	channelID := chi.URLParam(r, "channel_id")
+	subscription, err := s.fetchSubscription(r.Context(), user, channelID)
+	if err != nil {
+		if err == errNotInChannel {
+			s.render.JSON(w, http.StatusUnauthorized, errorMap(err))
+			return
+		}
+
+		logger.Error("failed to fetch subscription", zap.Error(err))
+		s.render.JSON(w, http.StatusInternalServerError, errorMap(err))
+		return
+	}
+
+	message, err := db.UpdateMessage(r.Context(), s.DB, chi.URLParam(r, "message_id"), func(message *model.Message) (bool, error) {
+		if message.ChannelID != channelID {
+			return false, db.NotFound
+		}
+
+		return change(user, subscription, message)
+	})
+	if err != nil {
+		switch err {
+		case db.NotFound:
+			s.render.JSON(w, http.StatusNotFound, errorMap(err))
+		case errNotMessageAuthor:
+			s.render.JSON(w, http.StatusForbidden, errorMap(err))
+		case errMessageDeleted, errTooManyReactions:
+			s.render.JSON(w, http.StatusConflict, errorMap(err))
+		default:
+			logger.Error("failed to update message", zap.Error(err))
+			s.render.JSON(w, http.StatusInternalServerError, errorMap(err))
+		}
+
+		return
+	}
 
-	s.render.JSON(w, http.StatusOK, util.Map{"messages": messages})
+	s.render.JSON(w, http.StatusOK, util.Map{"message": message})
 }
@@ -0,0 +1,10 @@
+package server
+
+import "expvar"
+
+// Socket metrics, served with the rest of expvar's by DebugHandler, on the internal debug listener.
+var (
+	socketsOpen   = expvar.NewInt("sockets_open")
+	socketsOpened = expvar.NewInt("sockets_opened")
+	socketsReaped = expvar.NewMap("sockets_reaped")
+)
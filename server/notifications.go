@@ -0,0 +1,166 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/broothie/slink.chat/db"
+	"github.com/broothie/slink.chat/event"
+	"github.com/broothie/slink.chat/model"
+	"github.com/broothie/slink.chat/util"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// maxReadNotifications is how many notifications one request can mark read, kept under Firestore's batch limit.
+const maxReadNotifications = 400
+
+// indexNotifications lists the user's notifications, oldest first, ending with the newest. With unread=true, only
+// unread ones are listed.
+func (s *Server) indexNotifications(w http.ResponseWriter, r *http.Request) {
+	logger := ctxzap.Extract(r.Context())
+
+	params, err := pageParams(r)
+	if err != nil {
+		s.render.JSON(w, http.StatusBadRequest, errorMap(err))
+		return
+	}
+
+	user, _ := model.UserFromContext(r.Context())
+	unread := r.URL.Query().Get("unread") == "true"
+	params.FromEnd = true
+	page, err := db.NewFetcher[model.Notification](s.DB).Page(r.Context(), "created_at", params, func(query db.Query) db.Query {
+		query = query.Where("user_id", "==", user.ID)
+		if unread {
+			query = query.Where("read", "==", false)
+		}
+
+		return query
+	})
+	if err != nil {
+		if errors.Is(err, db.InvalidCursor) {
+			s.render.JSON(w, http.StatusBadRequest, errorMap(err))
+			return
+		}
+
+		logger.Error("failed to get notifications", zap.Error(err))
+		s.render.JSON(w, http.StatusInternalServerError, errorMap(err))
+		return
+	}
+
+	s.render.JSON(w, http.StatusOK, util.Map{"notifications": page.Items, "next": page.Next, "prev": page.Prev})
+}
+
+// readNotifications marks the given notifications of the user's read, or all of them if none are given.
+func (s *Server) readNotifications(w http.ResponseWriter, r *http.Request) {
+	logger := ctxzap.Extract(r.Context())
+
+	var params struct {
+		NotificationIDs []string `json:"notificationIDs"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil && err != io.EOF {
+		logger.Error("failed to decode params", zap.Error(err))
+		s.render.JSON(w, http.StatusBadRequest, errorMap(err))
+		return
+	}
+
+	if len(params.NotificationIDs) > maxReadNotifications {
+		s.render.JSON(w, http.StatusBadRequest, errorMap(errors.Errorf("can't mark more than %d notifications read at once", maxReadNotifications)))
+		return
+	}
+
+	user, _ := model.UserFromContext(r.Context())
+	fetcher := db.NewFetcher[model.Notification](s.DB)
+	var notifications []model.Notification
+	var err error
+	if len(params.NotificationIDs) > 0 {
+		notifications, err = fetcher.FetchMany(r.Context(), params.NotificationIDs...)
+	} else {
+		notifications, err = fetcher.Query(r.Context(), func(query db.Query) db.Query {
+			return query.Where("user_id", "==", user.ID).Where("read", "==", false).Limit(maxReadNotifications)
+		})
+	}
+
+	if err != nil {
+		logger.Error("failed to fetch notifications", zap.Error(err))
+		s.render.JSON(w, http.StatusInternalServerError, errorMap(err))
+		return
+	}
+
+	now := time.Now()
+	read := []model.Notification{}
+	batch := s.DB.Batch()
+	for _, notification := range notifications {
+		if notification.UserID != user.ID || notification.Read {
+			continue
+		}
+
+		notification.Read = true
+		notification.ReadAt = now
+		notification.UpdatedAt = now
+		batch.Update(s.DB.CollectionFor(notification.Type()).Doc(notification.ID), []db.Update{
+			{Path: "read", Value: notification.Read},
+			{Path: "read_at", Value: notification.ReadAt},
+			{Path: "updated_at", Value: notification.UpdatedAt},
+		})
+
+		read = append(read, notification)
+	}
+
+	if len(read) > 0 {
+		if err := batch.Commit(r.Context()); err != nil {
+			logger.Error("failed to update notifications", zap.Error(err))
+			s.render.JSON(w, http.StatusInternalServerError, errorMap(err))
+			return
+		}
+	}
+
+	s.render.JSON(w, http.StatusOK, util.Map{"notifications": read})
+}
+
+// watchNotifications sends the user's notifications down frames as they're created and read, until ctx is done or
+// the listener ends.
+func (s *Server) watchNotifications(ctx context.Context, user model.User, frames chan<- event.Envelope) {
+	logger := ctxzap.Extract(ctx).With(zap.String("at", "watchNotifications"))
+
+	snapshots := s.DB.CollectionFor(model.TypeNotification).
+		Where("user_id", "==", user.ID).
+		Where("updated_at", ">", time.Now()).
+		Snapshots(ctx)
+	defer snapshots.Stop()
+
+	err := eachChange(snapshots, func(change db.DocumentChange) {
+		if change.Kind == db.DocumentRemoved {
+			return
+		}
+
+		var notification model.Notification
+		if err := change.Doc.DataTo(&notification); err != nil {
+			logger.Error("failed to read notification", zap.Error(err))
+			return
+		}
+
+		select {
+		case frames <- notificationEvent(notification):
+		case <-ctx.Done():
+		}
+	})
+	if err != nil && !errors.Is(err, context.Canceled) {
+		logger.Error("next snapshot error", zap.Error(err))
+	}
+}
+
+// notificationEvent announces notification, which is an update once it's been read.
+func notificationEvent(notification model.Notification) event.Envelope {
+	eventType := event.NotificationCreated
+	if notification.Read {
+		eventType = event.NotificationUpdated
+	}
+
+	return event.New(eventType, notification.ChannelID, notification)
+}
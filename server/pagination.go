@@ -0,0 +1,34 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/broothie/slink.chat/db"
+)
+
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 200
+)
+
+// pageParams reads before, after and limit from the query string.
+func pageParams(r *http.Request) (db.PageParams, error) {
+	query := r.URL.Query()
+	params := db.PageParams{Before: query.Get("before"), After: query.Get("after"), Limit: defaultPageLimit}
+	if params.Before != "" && params.After != "" {
+		return db.PageParams{}, fmt.Errorf("before and after can't be used together")
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxPageLimit {
+			return db.PageParams{}, fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+		}
+
+		params.Limit = n
+	}
+
+	return params, nil
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/broothie/slink.chat/event"
+	"github.com/broothie/slink.chat/model"
+	"github.com/broothie/slink.chat/util"
+	"github.com/go-chi/chi/v5"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	// pollTimeout is how long a poll waits for an event before returning empty, kept under common proxy timeouts.
+	pollTimeout = 25 * time.Second
+
+	// pollLinger is how long a poll keeps collecting after its first event, so bursts come back together.
+	pollLinger = 100 * time.Millisecond
+
+	// maxPollEvents is the most events returned by one poll.
+	maxPollEvents = 100
+)
+
+// pollChannel long-polls a channel's events. Clients pass the last message ID they've seen as after, and the since
+// the last poll returned, so messages sent and changed between polls are replayed rather than lost.
+func (s *Server) pollChannel(w http.ResponseWriter, r *http.Request) {
+	user, _ := model.UserFromContext(r.Context())
+	channelID := chi.URLParam(r, "channel_id")
+	if !s.requireSubscription(w, r, user, channelID) {
+		return
+	}
+
+	since, err := sinceParam(r)
+	if err != nil {
+		s.render.JSON(w, http.StatusBadRequest, errorMap(err))
+		return
+	}
+
+	events, until := poll(r.Context(), s.channelFeed(user, channelID, r.URL.Query().Get("after"), since))
+	s.render.JSON(w, http.StatusOK, util.Map{"events": events, "since": until})
+}
+
+// pollChats long-polls the user's channel list events. Each poll returns since, which clients pass to the next one
+// so that changes between polls come back rather than being lost.
+func (s *Server) pollChats(w http.ResponseWriter, r *http.Request) {
+	since, err := sinceParam(r)
+	if err != nil {
+		s.render.JSON(w, http.StatusBadRequest, errorMap(err))
+		return
+	}
+
+	user, _ := model.UserFromContext(r.Context())
+	events, until := poll(r.Context(), s.chatsFeed(user, since))
+	s.render.JSON(w, http.StatusOK, util.Map{"events": events, "since": until})
+}
+
+// sinceParam reads the since a previous poll returned, if there was one.
+func sinceParam(r *http.Request) (time.Time, error) {
+	param := r.URL.Query().Get("since")
+	if param == "" {
+		return time.Time{}, nil
+	}
+
+	since, err := time.Parse(time.RFC3339Nano, param)
+	if err != nil {
+		return time.Time{}, errors.New("since must be an RFC 3339 time")
+	}
+
+	return since, nil
+}
+
+// poll runs f until it has events to hand back, or for pollTimeout if it doesn't. It also returns when it stopped
+// listening, or when its last event happened if it had too many to return, which is where a next poll picks up.
+func poll(ctx context.Context, f feed) ([]event.Envelope, time.Time) {
+	logger := ctxzap.Extract(ctx).With(zap.String("at", "poll"))
+
+	ctx, cancel := context.WithTimeout(ctx, pollTimeout)
+	defer cancel()
+
+	var mu sync.Mutex
+	events := []event.Envelope{}
+	first := make(chan struct{})
+	var firstOnce sync.Once
+
+	feedDone := make(chan struct{})
+	go func() {
+		defer close(feedDone)
+
+		err := f(ctx, func(envelope event.Envelope) bool {
+			mu.Lock()
+			defer mu.Unlock()
+
+			events = append(events, envelope)
+			firstOnce.Do(func() { close(first) })
+			return len(events) < maxPollEvents
+		})
+
+		switch err {
+		case errFeedDropped:
+			logger.Info("poll fell behind")
+		case errFeedEnded:
+			logger.Info("db closed stream")
+		}
+	}()
+
+	select {
+	case <-first:
+		timer := time.NewTimer(pollLinger)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+		case <-feedDone:
+		}
+
+	case <-feedDone:
+	case <-ctx.Done():
+	}
+
+	until := time.Now()
+	cancel()
+	<-feedDone
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) >= maxPollEvents {
+		until = events[len(events)-1].TS
+	}
+
+	return events, until
+}
@@ -0,0 +1,371 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/broothie/slink.chat/db"
+	"github.com/broothie/slink.chat/event"
+	"github.com/broothie/slink.chat/hub"
+	"github.com/broothie/slink.chat/model"
+	"github.com/broothie/slink.chat/util"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"github.com/pkg/errors"
+	"github.com/rs/xid"
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// presenceTTL is how long a socket counts as live without being refreshed.
+	presenceTTL = 75 * time.Second
+
+	// presenceRefreshInterval is how often an open socket refreshes its presence.
+	presenceRefreshInterval = 30 * time.Second
+
+	// presenceSweepInterval is how often expired presences are deleted, which is what tells everyone a user whose
+	// server died has gone offline.
+	presenceSweepInterval = 30 * time.Second
+)
+
+func presenceTopic(userID string) string {
+	return "presence:" + userID
+}
+
+func (s *Server) updateAway(w http.ResponseWriter, r *http.Request) {
+	logger := ctxzap.Extract(r.Context())
+
+	var params struct {
+		AwayMessage string `json:"awayMessage"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		logger.Error("failed to decode params", zap.Error(err))
+		s.render.JSON(w, http.StatusBadRequest, errorMap(err))
+		return
+	}
+
+	user, _ := model.UserFromContext(r.Context())
+	now := time.Now()
+	if params.AwayMessage == "" {
+		user.AwaySince = time.Time{}
+	} else if user.AwayMessage == "" {
+		// Changing the message while away doesn't start a new away session
+		user.AwaySince = now
+	}
+
+	user.AwayMessage = params.AwayMessage
+	user.UpdatedAt = now
+	if err := s.DB.CollectionFor(user.Type()).Doc(user.ID).Update(r.Context(), []db.Update{
+		{Path: "away_message", Value: user.AwayMessage},
+		{Path: "away_since", Value: user.AwaySince},
+		{Path: "updated_at", Value: user.UpdatedAt},
+	}); err != nil {
+		logger.Error("failed to update user", zap.Error(err))
+		s.render.JSON(w, http.StatusInternalServerError, errorMap(err))
+		return
+	}
+
+	s.render.JSON(w, http.StatusOK, util.Map{"user": user})
+}
+
+// connect records a socket of user's as live, returning the presence to refresh and disconnect it with.
+func (s *Server) connect(ctx context.Context, user model.User) (model.Presence, error) {
+	now := time.Now()
+	presence := model.Presence{
+		ID:        xid.New().String(),
+		CreatedAt: now,
+		UpdatedAt: now,
+		UserID:    user.ID,
+		ExpiresAt: now.Add(presenceTTL),
+	}
+
+	if err := s.DB.CollectionFor(presence.Type()).Doc(presence.ID).Create(ctx, presence); err != nil {
+		return model.Presence{}, errors.Wrap(err, "failed to create presence")
+	}
+
+	return presence, nil
+}
+
+// refreshPresence saves presence with a new expiry, recreating it if it was swept in the meantime.
+func (s *Server) refreshPresence(ctx context.Context, presence *model.Presence) error {
+	now := time.Now()
+	presence.UpdatedAt = now
+	presence.ExpiresAt = now.Add(presenceTTL)
+	if err := s.DB.CollectionFor(presence.Type()).Doc(presence.ID).Set(ctx, *presence); err != nil {
+		return errors.Wrap(err, "failed to save presence")
+	}
+
+	return nil
+}
+
+// disconnect deletes presence. It runs as the socket closes, so it doesn't use the request's context.
+func (s *Server) disconnect(presence model.Presence) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := s.DB.CollectionFor(presence.Type()).Doc(presence.ID).Delete(ctx); err != nil {
+		return errors.Wrap(err, "failed to delete presence")
+	}
+
+	return nil
+}
+
+// presence is where userID stands now.
+func (s *Server) presence(ctx context.Context, userID string) (event.Presence, error) {
+	user, err := db.NewFetcher[model.User](s.DB).Fetch(ctx, userID)
+	if err != nil {
+		return event.Presence{}, errors.Wrap(err, "failed to fetch user")
+	}
+
+	presences, err := db.NewFetcher[model.Presence](s.DB).Query(ctx, func(query db.Query) db.Query {
+		return query.Where("user_id", "==", userID)
+	})
+	if err != nil {
+		return event.Presence{}, errors.Wrap(err, "failed to fetch presences")
+	}
+
+	status := model.Status(user, presences, time.Now())
+	return event.Presence{
+		UserID:      userID,
+		Status:      status,
+		AwayMessage: lo.Ternary(status == model.StatusAway, user.AwayMessage, ""),
+	}, nil
+}
+
+// presenceSource publishes a user's presence whenever it changes, watching both their sockets and their away
+// message.
+func (s *Server) presenceSource(userID string) hub.Source {
+	return func(ctx context.Context, publish func(any)) error {
+		// Subscribers are sent where the user stands when they join, so only changes from here on are published
+		var mu sync.Mutex
+		last, err := s.presence(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		update := func() {
+			mu.Lock()
+			defer mu.Unlock()
+
+			presence, err := s.presence(ctx, userID)
+			if err != nil {
+				if ctx.Err() == nil {
+					s.Logger.Error("failed to get presence", zap.Error(err), zap.String("user_id", userID))
+				}
+
+				return
+			}
+
+			if presence != last {
+				last = presence
+				publish(event.New(event.PresenceUpdated, "", presence))
+			}
+		}
+
+		watch := func(snapshots db.Listener) error {
+			defer snapshots.Stop()
+
+			for {
+				if _, err := snapshots.Next(); err != nil {
+					return listenError(err)
+				}
+
+				update()
+			}
+		}
+
+		group, ctx := errgroup.WithContext(ctx)
+		group.Go(func() error {
+			return watch(s.DB.CollectionFor(model.TypePresence).Where("user_id", "==", userID).Snapshots(ctx))
+		})
+		group.Go(func() error {
+			return watch(s.DB.CollectionFor(model.TypeUser).Where("id", "==", userID).Snapshots(ctx))
+		})
+
+		return group.Wait()
+	}
+}
+
+// watchBuddies sends the presence of user and of everyone they share a private chat with down frames, starting
+// with where each of them stands now.
+func (s *Server) watchBuddies(ctx context.Context, user model.User, frames chan<- event.Envelope, dropped func()) {
+	logger := ctxzap.Extract(ctx).With(zap.String("at", "watchBuddies"))
+
+	snapshots := s.DB.CollectionFor(model.TypeSubscription).Where("user_id", "==", user.ID).Snapshots(ctx)
+	defer snapshots.Stop()
+
+	// Buddies are counted by how many chats they share with user, and watched while they share any
+	counts := map[string]int{user.ID: 1}
+	watches := map[string]context.CancelFunc{}
+	chatMembers := map[string][]string{}
+	defer func() {
+		for _, cancel := range watches {
+			cancel()
+		}
+	}()
+
+	watch := func(userID string) {
+		ctx, cancel := context.WithCancel(ctx)
+		watches[userID] = cancel
+		go s.watchPresence(ctx, userID, frames, dropped)
+	}
+
+	watch(user.ID)
+	for {
+		changes, err := snapshots.Next()
+		if err != nil {
+			if !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+				logger.Error("next snapshot error", zap.Error(err))
+			}
+
+			return
+		}
+
+		for _, change := range changes {
+			var subscription model.Subscription
+			if err := change.Doc.DataTo(&subscription); err != nil {
+				logger.Error("failed to read subscription change", zap.Error(err))
+				continue
+			}
+
+			switch change.Kind {
+			case db.DocumentAdded:
+				members, err := s.chatMembers(ctx, subscription.ChannelID)
+				if err != nil {
+					logger.Error("failed to get chat members", zap.Error(err), zap.String("channel_id", subscription.ChannelID))
+					continue
+				}
+
+				members = lo.Without(members, user.ID)
+				chatMembers[subscription.ChannelID] = members
+				for _, userID := range members {
+					if counts[userID]++; counts[userID] == 1 {
+						watch(userID)
+					}
+				}
+
+			case db.DocumentRemoved:
+				for _, userID := range chatMembers[subscription.ChannelID] {
+					if counts[userID]--; counts[userID] == 0 {
+						delete(counts, userID)
+						watches[userID]()
+						delete(watches, userID)
+					}
+				}
+
+				delete(chatMembers, subscription.ChannelID)
+			}
+		}
+	}
+}
+
+// chatMembers lists the members of a private chat, or nothing for a public channel.
+func (s *Server) chatMembers(ctx context.Context, channelID string) ([]string, error) {
+	channel, err := db.NewFetcher[model.Channel](s.DB).Fetch(ctx, channelID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch channel")
+	}
+
+	if !channel.Private {
+		return nil, nil
+	}
+
+	subscriptions, err := db.NewFetcher[model.Subscription](s.DB).Query(ctx, func(query db.Query) db.Query {
+		return query.Where("channel_id", "==", channelID)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch subscriptions")
+	}
+
+	return lo.Map(subscriptions, func(subscription model.Subscription, _ int) string { return subscription.UserID }), nil
+}
+
+// watchPresence sends userID's presence down frames, then again each time it changes.
+func (s *Server) watchPresence(ctx context.Context, userID string, frames chan<- event.Envelope, dropped func()) {
+	client := s.hub.Subscribe(presenceTopic(userID), s.presenceSource(userID))
+	defer client.Close()
+
+	presence, err := s.presence(ctx, userID)
+	if err != nil {
+		ctxzap.Extract(ctx).Error("failed to get presence", zap.Error(err), zap.String("user_id", userID))
+		return
+	}
+
+	select {
+	case frames <- event.New(event.PresenceUpdated, "", presence):
+	case <-ctx.Done():
+		return
+	}
+
+	for {
+		select {
+		case envelope, ok := <-client.Events():
+			if !ok {
+				if client.Dropped() {
+					dropped()
+				}
+
+				return
+			}
+
+			select {
+			case frames <- envelope.(event.Envelope):
+			case <-ctx.Done():
+				return
+			}
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// SweepPresences deletes expired presences until ctx is done. Every server runs it, so users on a server that died
+// still go offline.
+func (s *Server) SweepPresences(ctx context.Context) {
+	ticker := time.NewTicker(presenceSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.sweepPresences(ctx); err != nil {
+				s.Logger.Error("failed to sweep presences", zap.Error(err))
+			}
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *Server) sweepPresences(ctx context.Context) error {
+	snapshots, err := s.DB.CollectionFor(model.TypePresence).
+		Where("expires_at", "<", time.Now()).
+		Limit(400).
+		Documents(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to query expired presences")
+	}
+
+	if len(snapshots) == 0 {
+		return nil
+	}
+
+	batch := s.DB.Batch()
+	for _, snapshot := range snapshots {
+		batch.Delete(s.DB.CollectionFor(model.TypePresence).Doc(snapshot.ID()))
+	}
+
+	if err := batch.Commit(ctx); err != nil {
+		return errors.Wrap(err, "failed to delete expired presences")
+	}
+
+	s.Logger.Info("swept expired presences", zap.Int("count", len(snapshots)))
+	return nil
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/broothie/slink.chat/model"
+	"github.com/go-chi/chi/v5"
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxEmojiLength fits the longest emoji sequences, like flags and families, with room to spare.
+	maxEmojiLength = 32
+
+	// maxReactions is how many different emoji a message can be reacted with.
+	maxReactions = 20
+)
+
+var (
+	errInvalidEmoji     = errors.New("reaction must be an emoji")
+	errTooManyReactions = errors.New("message has too many different reactions")
+)
+
+// addReaction reacts to a message with the emoji in the URL. Reacting again changes nothing.
+func (s *Server) addReaction(w http.ResponseWriter, r *http.Request) {
+	emoji, ok := s.emojiParam(w, r)
+	if !ok {
+		return
+	}
+
+	s.changeMessage(w, r, func(user model.User, _ model.Subscription, message *model.Message) (bool, error) {
+		if message.Deleted() {
+			return false, errMessageDeleted
+		}
+
+		if _, reacted := message.Reactions[emoji]; !reacted && len(message.Reactions) >= maxReactions {
+			return false, errTooManyReactions
+		}
+
+		return message.React(user.ID, emoji, time.Now()), nil
+	})
+}
+
+// removeReaction takes back the user's reaction to a message with the emoji in the URL, if they had one.
+func (s *Server) removeReaction(w http.ResponseWriter, r *http.Request) {
+	emoji, ok := s.emojiParam(w, r)
+	if !ok {
+		return
+	}
+
+	s.changeMessage(w, r, func(user model.User, _ model.Subscription, message *model.Message) (bool, error) {
+		return message.Unreact(user.ID, emoji, time.Now()), nil
+	})
+}
+
+func (s *Server) emojiParam(w http.ResponseWriter, r *http.Request) (string, bool) {
+	emoji, err := url.PathUnescape(chi.URLParam(r, "emoji"))
+	if err != nil || !validEmoji(emoji) {
+		s.render.JSON(w, http.StatusBadRequest, errorMap(errInvalidEmoji))
+		return "", false
+	}
+
+	return emoji, true
+}
+
+// validEmoji loosely checks that s is an emoji rather than text: short, without letters or spaces, and with at least
+// one character outside ASCII. Keycaps like 1️⃣ are why digits and punctuation get through.
+func validEmoji(s string) bool {
+	if s == "" || len(s) > maxEmojiLength || !utf8.ValidString(s) {
+		return false
+	}
+
+	nonASCII := false
+	for _, r := range s {
+		if unicode.IsLetter(r) || unicode.IsSpace(r) || unicode.IsControl(r) {
+			return false
+		}
+
+		if r > unicode.MaxASCII {
+			nonASCII = true
+		}
+	}
+
+	return nonASCII
+}
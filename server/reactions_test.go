@@ -0,0 +1,98 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/broothie/slink.chat/model"
+)
+
+func TestValidEmoji(t *testing.T) {
+	tests := []struct {
+		emoji string
+		want  bool
+	}{
+		{"👍", true},
+		{"👍🏽", true},
+		{"❤️", true},
+		{"👨‍👩‍👧‍👦", true},
+		{"🇯🇵", true},
+		{"1️⃣", true},
+		{"#️⃣", true},
+		{"", false},
+		{"1", false},
+		{":)", false},
+		{"a👍", false},
+		{"é", false},
+		{"ж", false},
+		{"漢", false},
+		{"👍 👍", false},
+		{"👍\n", false},
+		{strings.Repeat("👍", maxEmojiLength/4+1), false},
+		{"\xff", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.emoji, func(t *testing.T) {
+			if got := validEmoji(test.emoji); got != test.want {
+				t.Errorf("validEmoji(%q) = %t, want %t", test.emoji, got, test.want)
+			}
+		})
+	}
+}
+
+func TestServer_Reactions(t *testing.T) {
+	server := newTestServer(t)
+	alice := newTestClient(t, server, "alice")
+
+	var created struct {
+		Channel struct {
+			ID string `json:"channelID"`
+		}
+	}
+	alice.do(t, http.MethodPost, "/api/v1/channels", map[string]any{"name": "reactions"}, http.StatusCreated, &created)
+	channelID := created.Channel.ID
+	react := func(t *testing.T, messageID, emoji string, status int) []model.Reaction {
+		t.Helper()
+
+		var response struct {
+			Message struct{ Reactions []model.Reaction }
+		}
+		path := fmt.Sprintf("/api/v1/channels/%s/messages/%s/reactions/%s", channelID, messageID, url.PathEscape(emoji))
+		alice.do(t, http.MethodPut, path, nil, status, &response)
+		return response.Message.Reactions
+	}
+
+	t.Run("twice", func(t *testing.T) {
+		message := alice.send(t, channelID, "react to me")
+		react(t, message.ID, "👍", http.StatusOK)
+		if reactions := react(t, message.ID, "👍", http.StatusOK); len(reactions) != 1 || reactions[0].Count != 1 {
+			t.Errorf("got %v, want one reaction from alice", reactions)
+		}
+	})
+
+	t.Run("not an emoji", func(t *testing.T) {
+		message := alice.send(t, channelID, "react to me")
+		alice.do(t, http.MethodPut, fmt.Sprintf("/api/v1/channels/%s/messages/%s/reactions/lol", channelID, message.ID), nil, http.StatusBadRequest, nil)
+	})
+
+	t.Run("too many", func(t *testing.T) {
+		message := alice.send(t, channelID, "react to me")
+		for i := 0; i < maxReactions; i++ {
+			react(t, message.ID, string(rune(0x1F600+i)), http.StatusOK)
+		}
+
+		// Emoji it already has can still be added to
+		react(t, message.ID, string(rune(0x1F600)), http.StatusOK)
+		alice.do(t, http.MethodPut, fmt.Sprintf("/api/v1/channels/%s/messages/%s/reactions/%s", channelID, message.ID, url.PathEscape("🎉")), nil, http.StatusConflict, nil)
+	})
+
+	t.Run("deleted", func(t *testing.T) {
+		message := alice.send(t, channelID, "react to me")
+		alice.do(t, http.MethodDelete, fmt.Sprintf("/api/v1/channels/%s/messages/%s", channelID, message.ID), nil, http.StatusOK, nil)
+		alice.do(t, http.MethodPut, fmt.Sprintf("/api/v1/channels/%s/messages/%s/reactions/%s", channelID, message.ID, url.PathEscape("👍")), nil, http.StatusConflict, nil)
+	})
+}
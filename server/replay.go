@@ -0,0 +1,113 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/broothie/slink.chat/db"
+	"github.com/broothie/slink.chat/event"
+	"github.com/broothie/slink.chat/model"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+)
+
+// maxReplay is the most messages replayed to a resuming client before it's told to refetch instead.
+const maxReplay = 100
+
+// replay is what a client resuming from lastMessageID missed in a channel: edits and deletions of the messages it
+// had, then each message since, in order, or a refetch.required event if that's too many or lastMessageID can't be
+// placed. Clients that aren't resuming pass an empty lastMessageID and get nothing. since is when the client last
+// heard from the channel, if it knows, so changes it already has aren't sent again.
+func (s *Server) replay(ctx context.Context, channelID, lastMessageID string, since time.Time) ([]event.Envelope, error) {
+	if lastMessageID == "" {
+		return nil, nil
+	}
+
+	refetch := func(reason string) []event.Envelope {
+		return []event.Envelope{refetchRequired(channelID, reason)}
+	}
+
+	last, err := db.NewFetcher[model.Message](s.DB).Fetch(ctx, lastMessageID)
+	if err != nil {
+		if err == db.NotFound {
+			return refetch("last message not found"), nil
+		}
+
+		return nil, errors.Wrap(err, "failed to fetch last message")
+	}
+
+	if last.ChannelID != channelID {
+		return refetch("last message not in channel"), nil
+	}
+
+	// The client had its last message from when it was sent, so any change since is one it may have missed
+	changedSince := last.CreatedAt
+	if since.After(changedSince) {
+		changedSince = since
+	}
+
+	changed, err := db.NewFetcher[model.Message](s.DB).Query(ctx, func(query db.Query) db.Query {
+		return query.
+			Where("channel_id", "==", channelID).
+			Where("updated_at", ">", changedSince).
+			OrderBy("updated_at", db.Asc).
+			Limit(maxReplay + 1)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch changed messages")
+	}
+
+	messages, err := db.NewFetcher[model.Message](s.DB).Query(ctx, func(query db.Query) db.Query {
+		return query.
+			Where("channel_id", "==", channelID).
+			Where("seq", ">", last.Seq).
+			OrderBy("seq", db.Asc).
+			Limit(maxReplay + 1)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch missed messages")
+	}
+
+	changed = lo.Filter(changed, func(message model.Message, _ int) bool { return message.Seq <= last.Seq })
+	if len(changed)+len(messages) > maxReplay {
+		return refetch("too many missed messages"), nil
+	}
+
+	replay := lo.Map(changed, func(message model.Message, _ int) event.Envelope {
+		if message.Deleted() {
+			return messageDeleted(message)
+		}
+
+		return event.New(event.MessageUpdated, channelID, message)
+	})
+
+	return append(replay, lo.Map(messages, func(message model.Message, _ int) event.Envelope {
+		if message.Deleted() {
+			return messageDeleted(message)
+		}
+
+		return event.New(event.MessageCreated, channelID, message)
+	})...), nil
+}
+
+func messageDeleted(message model.Message) event.Envelope {
+	return event.New(event.MessageDeleted, message.ChannelID, event.MessageRef{MessageID: message.ID, ChannelID: message.ChannelID})
+}
+
+func refetchRequired(channelID, reason string) event.Envelope {
+	return event.New(event.RefetchRequired, channelID, event.Refetch{ChannelID: channelID, Reason: reason})
+}
+
+// replayed reports whether envelope is the live copy of a message that was already replayed. Replay runs after
+// subscribing, so a message sent in between arrives both ways.
+func replayed(envelope event.Envelope, replay []event.Envelope) bool {
+	message, ok := envelope.Payload.(model.Message)
+	if !ok || envelope.Type != event.MessageCreated {
+		return false
+	}
+
+	return lo.ContainsBy(replay, func(replayed event.Envelope) bool {
+		replayedMessage, ok := replayed.Payload.(model.Message)
+		return ok && replayedMessage.ID == message.ID
+	})
+}
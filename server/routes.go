@@ -26,6 +26,9 @@ func (s *Server) routes() chi.Router {
 
 		r.Route("/v1", func(r chi.Router) {
 			r.With(s.requireUser).Get("/user", s.showCurrentUser)
+			r.With(s.requireUser).Put("/user/away", s.updateAway)
+			r.With(s.requireUser).Get("/socket", s.sessionSocket)
+			r.Get("/events/schema.json", s.eventSchema)
 
 			r.Route("/session", func(r chi.Router) {
 				r.Post("/", s.createSession)
@@ -49,6 +52,21 @@ func (s *Server) routes() chi.Router {
 				})
 			})
 
+			r.Route("/attachments/{attachment_id}", func(r chi.Router) {
+				r.Use(s.requireUser)
+				r.Use(injectResourceIDLog("attachment"))
+
+				r.Get("/", s.showAttachment)
+				r.Get("/thumbnail", s.showThumbnail)
+			})
+
+			r.Route("/notifications", func(r chi.Router) {
+				r.Use(s.requireUser)
+
+				r.Get("/", s.indexNotifications)
+				r.Post("/read", s.readNotifications)
+			})
+
 			r.Route("/channels", func(r chi.Router) {
 				r.Use(s.requireUser)
 
@@ -59,6 +77,8 @@ func (s *Server) routes() chi.Router {
 				r.Route("/chats", func(r chi.Router) {
 					r.Post("/", s.upsertChat)
 					r.Get("/messages", s.channelsSocket)
+					r.Get("/events", s.chatsEvents)
+					r.Get("/poll", s.pollChats)
 				})
 
 				r.Route("/{channel_id}", func(r chi.Router) {
@@ -67,11 +87,27 @@ func (s *Server) routes() chi.Router {
 					r.Get("/", s.showChannel)
 					r.Post("/join", s.joinChannel)
 					r.Delete("/leave", s.leaveChannel)
+					r.Post("/read", s.markChannelRead)
 					r.Get("/users", s.indexChannelUsers)
+					r.Post("/attachments", s.uploadAttachment)
 
 					r.Route("/messages", func(r chi.Router) {
 						r.Get("/", s.indexMessages)
+						r.Post("/", s.postMessage)
 						r.Get("/subscribe", s.channelSocket)
+						r.Get("/events", s.channelEvents)
+						r.Get("/poll", s.pollChannel)
+
+						r.Route("/{message_id}", func(r chi.Router) {
+							r.Use(injectResourceIDLog("message"))
+
+							r.Patch("/", s.updateMessage)
+							r.Delete("/", s.deleteMessage)
+							r.Get("/edits", s.indexMessageEdits)
+							r.Get("/replies", s.indexReplies)
+							r.Put("/reactions/{emoji}", s.addReaction)
+							r.Delete("/reactions/{emoji}", s.removeReaction)
+						})
 					})
 				})
 			})
@@ -1,9 +1,12 @@
 package server
 
 import (
+	"expvar"
 	"net/http"
 
+	"github.com/broothie/slink.chat/config"
 	"github.com/broothie/slink.chat/core"
+	"github.com/broothie/slink.chat/hub"
 	"github.com/gorilla/sessions"
 	"github.com/unrolled/render"
 )
@@ -12,9 +15,13 @@ type Server struct {
 	core.Core
 	sessions *sessions.CookieStore
 	render   *render.Render
+	hub      *hub.Hub
+	typing   *typing
 }
 
 func New(core core.Core) (*Server, error) {
+	core.Config = withSocketDefaults(*core.Config)
+	eventHub := hub.New(core.Logger, hub.DefaultBufferSize)
 	return &Server{
 		Core:     core,
 		sessions: sessions.NewCookieStore([]byte(core.Config.Secret)),
@@ -25,9 +32,41 @@ func New(core core.Core) (*Server, error) {
 			RenderPartialsWithoutPrefix: true,
 			StreamingJSON:               true,
 		}),
+		hub:    eventHub,
+		typing: newTyping(eventHub, core.Bus, core.Logger),
 	}, nil
 }
 
+// withSocketDefaults fills in socket settings left zero, as they are in configs built by hand rather than read
+// from the environment. Zero would panic tickers and make every client a slow consumer.
+func withSocketDefaults(cfg config.Config) *config.Config {
+	if cfg.SocketPingInterval <= 0 {
+		cfg.SocketPingInterval = config.DefaultSocketPingInterval
+	}
+
+	if cfg.SocketPongTimeout <= 0 {
+		cfg.SocketPongTimeout = config.DefaultSocketPongTimeout
+	}
+
+	if cfg.SocketWriteTimeout <= 0 {
+		cfg.SocketWriteTimeout = config.DefaultSocketWriteTimeout
+	}
+
+	if cfg.SocketQueueSize <= 0 {
+		cfg.SocketQueueSize = config.DefaultSocketQueueSize
+	}
+
+	return &cfg
+}
+
 func (s *Server) Handler() http.Handler {
 	return s.routes()
 }
+
+// DebugHandler serves expvar's metrics at /debug/vars. It's for an internal listener only, since the metrics include
+// the process's command line and memory stats.
+func (s *Server) DebugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	return mux
+}
@@ -0,0 +1,327 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	stdhtml "html"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/broothie/slink.chat/config"
+	"github.com/broothie/slink.chat/core"
+	"github.com/broothie/slink.chat/event"
+	"github.com/unrolled/render"
+	"go.uber.org/zap"
+)
+
+// newTestServer serves the app over TLS, since the CSRF cookie is secure, with a memory store and jobs skipped.
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	c, err := core.New(&config.Config{
+		Environment: "development",
+		Secret:      "secret",
+		Database:    config.DatabaseMemory,
+		Broadcast:   config.BroadcastMemory,
+		BlobStore:   config.BlobStoreLocal,
+		BlobDir:     t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.Logger = zap.NewNop()
+	s, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Templates are found from the repo root, where the app runs
+	s.render = render.New(render.Options{Directory: "../templates", Layout: "layout", RenderPartialsWithoutPrefix: true})
+
+	server := httptest.NewTLSServer(s.Handler())
+	t.Cleanup(func() {
+		server.Close()
+		c.DB.Close()
+	})
+
+	return server
+}
+
+var csrfTokenPattern = regexp.MustCompile(`name="gorilla.csrf.Token" content="([^"]+)"`)
+
+// testClient is a signed up user's browser, keeping their session and sending the CSRF token the page gave it.
+type testClient struct {
+	server *httptest.Server
+	client *http.Client
+	token  string
+}
+
+func newTestClient(t *testing.T, server *httptest.Server, screenname string) *testClient {
+	t.Helper()
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The server's client is shared, so each user gets a copy with their own cookies
+	client := *server.Client()
+	client.Jar = jar
+
+	response, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer response.Body.Close()
+	var page bytes.Buffer
+	if _, err := page.ReadFrom(response.Body); err != nil {
+		t.Fatal(err)
+	}
+
+	match := csrfTokenPattern.FindStringSubmatch(page.String())
+	if match == nil {
+		t.Fatalf("no csrf token in index page: %d %s", response.StatusCode, page.String())
+	}
+
+	c := &testClient{server: server, client: &client, token: stdhtml.UnescapeString(match[1])}
+	c.do(t, http.MethodPost, "/api/v1/users", map[string]string{"screenname": screenname, "password": "password"}, http.StatusCreated, nil)
+	return c
+}
+
+// do makes a request, failing the test unless it gets status back, and decodes the response into out if given.
+func (c *testClient) do(t *testing.T, method, path string, body any, status int, out any) {
+	t.Helper()
+
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	request, err := http.NewRequest(method, c.server.URL+path, &payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Csrf-Token", c.token)
+	response, err := c.client.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer response.Body.Close()
+	var raw bytes.Buffer
+	if _, err := raw.ReadFrom(response.Body); err != nil {
+		t.Fatal(err)
+	}
+
+	if response.StatusCode != status {
+		t.Fatalf("%s %s got %d, want %d: %s", method, path, response.StatusCode, status, raw.String())
+	}
+
+	if out != nil {
+		if err := json.Unmarshal(raw.Bytes(), out); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+type testMessage struct {
+	ID   string `json:"messageID"`
+	Body string `json:"body"`
+}
+
+func (c *testClient) send(t *testing.T, channelID, body string) testMessage {
+	t.Helper()
+
+	var response struct{ Message testMessage }
+	c.do(t, http.MethodPost, fmt.Sprintf("/api/v1/channels/%s/messages", channelID), map[string]string{"body": body}, http.StatusCreated, &response)
+	return response.Message
+}
+
+// polledEvent is an event as a polling client reads it.
+type polledEvent struct {
+	Type      event.Type      `json:"type"`
+	ChannelID string          `json:"channelID"`
+	Payload   json.RawMessage `json:"payload"`
+}
+
+type pollResponse struct {
+	Events []polledEvent
+	Since  time.Time
+}
+
+func pollMessages(t *testing.T, c *testClient, messagesPath, after string, since time.Time) pollResponse {
+	t.Helper()
+
+	query := url.Values{"after": {after}}
+	if !since.IsZero() {
+		query.Set("since", since.Format(time.RFC3339Nano))
+	}
+
+	var p pollResponse
+	c.do(t, http.MethodGet, fmt.Sprintf("%s/poll?%s", messagesPath, query.Encode()), nil, http.StatusOK, &p)
+	return p
+}
+
+// events lists each polled message event's type and message ID.
+func (p pollResponse) events(t *testing.T) []string {
+	t.Helper()
+
+	var events []string
+	for _, e := range p.Events {
+		var message testMessage
+		if err := json.Unmarshal(e.Payload, &message); err != nil {
+			t.Fatal(err)
+		}
+
+		events = append(events, fmt.Sprintf("%s %s", e.Type, message.ID))
+	}
+
+	return events
+}
+
+func TestServer_Smoke(t *testing.T) {
+	server := newTestServer(t)
+	alice := newTestClient(t, server, "alice")
+	bob := newTestClient(t, server, "bob")
+	start := time.Now()
+
+	var created struct {
+		Channel struct {
+			ID string `json:"channelID"`
+		}
+	}
+	alice.do(t, http.MethodPost, "/api/v1/channels", map[string]any{"name": "smoke"}, http.StatusCreated, &created)
+	channelID := created.Channel.ID
+	messagesPath := fmt.Sprintf("/api/v1/channels/%s/messages", channelID)
+
+	first := alice.send(t, channelID, "first")
+	second := alice.send(t, channelID, "second")
+	gone := alice.send(t, channelID, "gone")
+
+	t.Run("messages", func(t *testing.T) {
+		var page struct{ Messages []testMessage }
+		alice.do(t, http.MethodGet, messagesPath, nil, http.StatusOK, &page)
+
+		if len(page.Messages) != 3 || page.Messages[0].ID != first.ID || page.Messages[2].ID != gone.ID {
+			t.Errorf("got %+v, want the three messages sent, in order", page.Messages)
+		}
+	})
+
+	t.Run("outsiders", func(t *testing.T) {
+		bob.do(t, http.MethodGet, messagesPath, nil, http.StatusUnauthorized, nil)
+		bob.do(t, http.MethodGet, messagesPath+"?replies=true", nil, http.StatusUnauthorized, nil)
+		bob.do(t, http.MethodGet, fmt.Sprintf("%s/%s/replies", messagesPath, first.ID), nil, http.StatusNotFound, nil)
+		bob.do(t, http.MethodPost, messagesPath, map[string]string{"body": "let me in"}, http.StatusUnauthorized, nil)
+	})
+
+	t.Run("resume", func(t *testing.T) {
+		alice.do(t, http.MethodPatch, fmt.Sprintf("%s/%s", messagesPath, first.ID), map[string]string{"body": "first, edited"}, http.StatusOK, nil)
+		alice.do(t, http.MethodDelete, fmt.Sprintf("%s/%s", messagesPath, gone.ID), nil, http.StatusOK, nil)
+
+		// Resuming from the first message replays the edit to it and what came after
+		polled := pollMessages(t, alice, messagesPath, first.ID, time.Time{})
+		want := []string{
+			fmt.Sprintf("%s %s", event.MessageUpdated, first.ID),
+			fmt.Sprintf("%s %s", event.MessageCreated, second.ID),
+			fmt.Sprintf("%s %s", event.MessageDeleted, gone.ID),
+		}
+
+		if got := polled.events(t); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+
+		// Resuming from the poll's since leaves out the changes it already returned
+		alice.do(t, http.MethodPatch, fmt.Sprintf("%s/%s", messagesPath, second.ID), map[string]string{"body": "second, edited"}, http.StatusOK, nil)
+		polled = pollMessages(t, alice, messagesPath, gone.ID, polled.Since)
+		want = []string{fmt.Sprintf("%s %s", event.MessageUpdated, second.ID)}
+		if got := polled.events(t); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("got %v, want only the change since the last poll, %v", got, want)
+		}
+	})
+
+	t.Run("chats poll", func(t *testing.T) {
+		var polled pollResponse
+		alice.do(t, http.MethodGet, "/api/v1/channels/chats/poll?since="+url.QueryEscape(start.Format(time.RFC3339Nano)), nil, http.StatusOK, &polled)
+
+		unread := false
+		for _, e := range polled.Events {
+			unread = unread || (e.Type == event.UnreadUpdated && e.ChannelID == channelID)
+		}
+
+		if !unread {
+			t.Errorf("got %+v, want the channel's unread counts from since", polled.Events)
+		}
+
+		if !polled.Since.After(start) {
+			t.Errorf("got since %v, want a later one to resume from", polled.Since)
+		}
+	})
+
+	t.Run("event stream", func(t *testing.T) {
+		// The test config leaves the socket settings zero, which the server must default rather than panic on
+		response, err := alice.client.Get(server.URL + "/api/v1/channels/chats/events")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer response.Body.Close()
+		if contentType := response.Header.Get("Content-Type"); response.StatusCode != http.StatusOK || !strings.HasPrefix(contentType, "text/event-stream") {
+			t.Errorf("got %d %q, want an event stream", response.StatusCode, contentType)
+		}
+	})
+
+	t.Run("mentions", func(t *testing.T) {
+		carol := newTestClient(t, server, "Carol Smith")
+		carol.do(t, http.MethodPost, fmt.Sprintf("/api/v1/channels/%s/join", channelID), nil, http.StatusCreated, nil)
+
+		var current struct {
+			User struct {
+				ID string `json:"userID"`
+			}
+		}
+		carol.do(t, http.MethodGet, "/api/v1/user", nil, http.StatusOK, &current)
+
+		// Bob isn't in the channel, and there's no one by the last name
+		var sent struct{ Message struct{ Mentions []string } }
+		alice.do(t, http.MethodPost, messagesPath, map[string]string{"body": "hi @CAROL SMITH, @bob and @nobody"}, http.StatusCreated, &sent)
+		if want := []string{current.User.ID}; fmt.Sprint(sent.Message.Mentions) != fmt.Sprint(want) {
+			t.Errorf("got mentions %v, want %v", sent.Message.Mentions, want)
+		}
+	})
+	t.Run("resend", func(t *testing.T) {
+		resend := func(body string) testMessage {
+			var response struct{ Message testMessage }
+			alice.do(t, http.MethodPost, messagesPath, map[string]string{"body": body, "nonce": "retry-1"}, http.StatusCreated, &response)
+			return response.Message
+		}
+
+		var before struct{ Messages []testMessage }
+		alice.do(t, http.MethodGet, messagesPath, nil, http.StatusOK, &before)
+
+		// The retry's body is ignored: it's the same send, whatever the client has since
+		original := resend("sent once")
+		retried := resend("sent once, edited")
+		if retried.ID != original.ID || retried.Body != "sent once" {
+			t.Errorf("got %+v, want the original %+v", retried, original)
+		}
+
+		var after struct{ Messages []testMessage }
+		alice.do(t, http.MethodGet, messagesPath, nil, http.StatusOK, &after)
+		if len(after.Messages) != len(before.Messages)+1 {
+			t.Errorf("got %d messages, want one more than %d", len(after.Messages), len(before.Messages))
+		}
+	})
+}
@@ -0,0 +1,314 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/broothie/slink.chat/db"
+	"github.com/broothie/slink.chat/event"
+	"github.com/broothie/slink.chat/model"
+	"github.com/gorilla/websocket"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	frameSubscribe   = "subscribe"
+	frameUnsubscribe = "unsubscribe"
+	frameSend        = "send"
+	frameTypingStart = "typing.start"
+	frameTypingStop  = "typing.stop"
+	frameHeartbeat   = "heartbeat"
+)
+
+// clientFrame is read from the session socket. The server writes event.Envelopes back.
+type clientFrame struct {
+	Type      string `json:"type"`
+	ChannelID string `json:"channelID"`
+	Body      string `json:"body,omitempty"`
+	Idle      bool   `json:"idle,omitempty"`
+
+	// Nonce identifies a send, so that retrying it doesn't post the message twice. Acks and errors echo it back.
+	Nonce string `json:"nonce,omitempty"`
+
+	// ParentID sends the message as a reply in that message's thread.
+	ParentID string `json:"parentID,omitempty"`
+
+	// AttachmentIDs sends uploaded attachments with the message.
+	AttachmentIDs []string `json:"attachmentIDs,omitempty"`
+
+	// After resumes a subscription from the last message the client saw, replaying what it missed.
+	After string `json:"after,omitempty"`
+
+	// Since is when the client last heard from the channel, so a resumed subscription only replays changes after it.
+	Since time.Time `json:"since"`
+}
+
+var errNotInChannel = errors.New("user not in channel")
+
+// sessionSocket is one socket per session, multiplexing every channel the client subscribes to along with updates
+// to the user's channel list and their buddies' presence. The socket being open is what puts the user online.
+func (s *Server) sessionSocket(w http.ResponseWriter, r *http.Request) {
+	logger := ctxzap.Extract(r.Context()).With(zap.String("at", "sessionSocket"))
+
+	sock, err := s.upgrade(w, r, logger)
+	if err != nil {
+		logger.Error("failed to upgrade request", zap.Error(err))
+		s.render.JSON(w, http.StatusInternalServerError, errorMap(err))
+		return
+	}
+
+	defer func() {
+		if err := sock.close(); err != nil {
+			logger.Error("failed to close connection", zap.Error(err))
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	user, _ := model.UserFromContext(ctx)
+	presence, err := s.connect(ctx, user)
+	if err != nil {
+		logger.Error("failed to connect presence", zap.Error(err))
+		return
+	}
+
+	defer func() {
+		if err := s.disconnect(presence); err != nil {
+			logger.Error("failed to disconnect presence", zap.Error(err))
+		}
+	}()
+
+	idleChan := make(chan bool)
+	frames := make(chan event.Envelope)
+	slowChan := make(chan struct{})
+	var slowOnce sync.Once
+	dropped := func() { slowOnce.Do(func() { close(slowChan) }) }
+
+	socketCloseChan := make(chan struct{})
+	go func() {
+		defer close(socketCloseChan)
+
+		feeds := make(map[string]subscribedFeed)
+		defer func() {
+			for channelID, feed := range feeds {
+				feed.cancel()
+				s.typing.stop(channelID, user.ID)
+			}
+		}()
+
+		reply := func(frame event.Envelope) bool {
+			select {
+			case frames <- frame:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		for {
+			messageType, socketReader, err := sock.nextReader()
+			if err != nil {
+				if _, isCloseErr := err.(*websocket.CloseError); !isCloseErr {
+					logger.Info("next reader error", zap.Error(err))
+				}
+
+				return
+			}
+
+			if messageType != websocket.TextMessage {
+				logger.Info("reader received non-text message type", zap.Int("message_type", messageType))
+				continue
+			}
+
+			// Forget feeds that ended on their own, so the client can subscribe to them again
+			for channelID, feed := range feeds {
+				if feed.ended() {
+					delete(feeds, channelID)
+					s.typing.stop(channelID, user.ID)
+				}
+			}
+
+			var frame clientFrame
+			if err := json.NewDecoder(socketReader).Decode(&frame); err != nil {
+				logger.Error("failed to decode frame", zap.Error(err))
+				reply(event.NewError("", "malformed frame"))
+				continue
+			}
+
+			switch frame.Type {
+			case frameSubscribe:
+				if _, ok := feeds[frame.ChannelID]; ok {
+					reply(event.New(event.Subscribed, frame.ChannelID, nil))
+					continue
+				}
+
+				if err := s.checkSubscription(ctx, user, frame.ChannelID); err != nil {
+					reply(subscribeErrorFrame(logger, frame.ChannelID, err))
+					continue
+				}
+
+				feedCtx, cancel := context.WithCancel(ctx)
+				subscribed := subscribedFeed{cancel: cancel, done: make(chan struct{})}
+				feeds[frame.ChannelID] = subscribed
+				reply(event.New(event.Subscribed, frame.ChannelID, nil))
+				go func(channelID string, f feed) {
+					err := f(feedCtx, reply)
+					close(subscribed.done)
+
+					switch {
+					case err == errFeedDropped:
+						dropped()
+
+					case feedCtx.Err() == nil:
+						// The feed ended without the client unsubscribing, which it's told so it can subscribe again
+						reply(event.New(event.Unsubscribed, channelID, nil))
+					}
+				}(frame.ChannelID, s.channelFeed(user, frame.ChannelID, frame.After, frame.Since))
+
+			case frameUnsubscribe:
+				if feed, ok := feeds[frame.ChannelID]; ok {
+					feed.cancel()
+					delete(feeds, frame.ChannelID)
+					s.typing.stop(frame.ChannelID, user.ID)
+				}
+
+				reply(event.New(event.Unsubscribed, frame.ChannelID, nil))
+
+			case frameSend:
+				message, err := s.send(ctx, user, frame.ChannelID, frame.draft())
+				reply(sendReply(logger, frame.ChannelID, frame.Nonce, message, err))
+
+			case frameTypingStart, frameTypingStop:
+				// Typing only goes to a channel the socket is subscribed to, which spares a membership check per keystroke
+				if _, ok := feeds[frame.ChannelID]; !ok {
+					reply(event.NewError(frame.ChannelID, "not subscribed to channel"))
+					continue
+				}
+
+				if frame.Type == frameTypingStart {
+					s.typing.start(frame.ChannelID, user.ID)
+				} else {
+					s.typing.stop(frame.ChannelID, user.ID)
+				}
+
+			case frameHeartbeat:
+				select {
+				case idleChan <- frame.Idle:
+				case <-ctx.Done():
+				}
+
+			default:
+				reply(event.NewError("", "unknown frame type"))
+			}
+		}
+	}()
+
+	dbCloseChan := make(chan struct{})
+	go func() {
+		defer close(dbCloseChan)
+		s.watchSubscriptions(ctx, user, frames, dropped)
+	}()
+
+	// Stop listening before the socket closes, so the listener doesn't outlive a vanished peer
+	defer func() {
+		cancel()
+		<-dbCloseChan
+	}()
+
+	go s.watchBuddies(ctx, user, frames, dropped)
+	go s.watchNotifications(ctx, user, frames)
+
+	refreshTicker := time.NewTicker(presenceRefreshInterval)
+	defer refreshTicker.Stop()
+
+	logger.Debug("session socket opened")
+	for {
+		select {
+		case <-socketCloseChan:
+			logger.Info("client closed socket")
+			return
+
+		case <-dbCloseChan:
+			logger.Info("db closed stream")
+			return
+
+		case <-sock.done():
+			return
+
+		case <-slowChan:
+			sock.reap(reapSlowConsumer)
+			return
+
+		case idle := <-idleChan:
+			presence.Idle = idle
+			if err := s.refreshPresence(ctx, &presence); err != nil {
+				logger.Error("failed to refresh presence", zap.Error(err))
+			}
+
+		case <-refreshTicker.C:
+			if err := s.refreshPresence(ctx, &presence); err != nil {
+				logger.Error("failed to refresh presence", zap.Error(err))
+			}
+
+		case frame := <-frames:
+			sock.send(frame)
+		}
+	}
+}
+
+// subscribedFeed is a channel feed running for a session socket. done closes when the feed returns, whether or not
+// it was cancelled.
+type subscribedFeed struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func (f subscribedFeed) ended() bool {
+	select {
+	case <-f.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// draft is the message a send frame carries.
+func (frame clientFrame) draft() draft {
+	return draft{Body: frame.Body, Nonce: frame.Nonce, ParentID: frame.ParentID, AttachmentIDs: frame.AttachmentIDs}
+}
+
+// subscribeErrorFrame is the error to reply with when a subscribe fails checkSubscription.
+func subscribeErrorFrame(logger *zap.Logger, channelID string, err error) event.Envelope {
+	if err != errNotInChannel {
+		logger.Error("failed to check subscription", zap.Error(err))
+		return event.NewError(channelID, "failed to check subscription")
+	}
+
+	return event.NewError(channelID, err.Error())
+}
+
+// checkSubscription returns errNotInChannel unless user is subscribed to channelID.
+func (s *Server) checkSubscription(ctx context.Context, user model.User, channelID string) error {
+	_, err := s.fetchSubscription(ctx, user, channelID)
+	return err
+}
+
+// fetchSubscription returns user's subscription to channelID, or errNotInChannel if they don't have one.
+func (s *Server) fetchSubscription(ctx context.Context, user model.User, channelID string) (model.Subscription, error) {
+	subscription, err := db.NewFetcher[model.Subscription](s.DB).Fetch(ctx, model.SubscriptionID(user.ID, channelID))
+	if err != nil {
+		if err == db.NotFound {
+			return model.Subscription{}, errNotInChannel
+		}
+
+		return model.Subscription{}, errors.Wrap(err, "failed to fetch subscription")
+	}
+
+	return subscription, nil
+}
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"net/http"
 
-	"cloud.google.com/go/firestore"
 	"github.com/broothie/slink.chat/db"
 	"github.com/broothie/slink.chat/model"
 	"github.com/broothie/slink.chat/util"
@@ -27,7 +26,7 @@ func (s *Server) createSession(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := db.NewFetcher[model.User](s.DB).FetchFirst(r.Context(), func(query firestore.Query) firestore.Query {
+	user, err := db.NewFetcher[model.User](s.DB).FetchFirst(r.Context(), func(query db.Query) db.Query {
 		return query.Where("screenname", "==", params.Screenname)
 	})
 	if err != nil {
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/broothie/slink.chat/event"
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+// Reasons a socket is reaped, as counted by the sockets_reaped metric.
+const (
+	reapPongTimeout  = "pong_timeout"
+	reapWriteFailed  = "write_failed"
+	reapSlowConsumer = "slow_consumer"
+)
+
+// socket is an upgraded websocket. It pings the peer and expects pongs back, puts a deadline on every write, and
+// queues outbound frames for a single writer. A peer that vanishes or stops reading gets reaped, rather than
+// holding its handler and the listeners behind it open forever.
+type socket struct {
+	conn         *websocket.Conn
+	logger       *zap.Logger
+	writeTimeout time.Duration
+	outbound     chan event.Envelope
+
+	stopOnce   sync.Once
+	stopped    chan struct{}
+	writerDone chan struct{}
+}
+
+// upgrade upgrades the request to a socket. Callers must close it.
+func (s *Server) upgrade(w http.ResponseWriter, r *http.Request, logger *zap.Logger) (*socket, error) {
+	upgrader := &websocket.Upgrader{ReadBufferSize: 1024, WriteBufferSize: 1024}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	pongTimeout := s.Config.SocketPongTimeout
+	if err := conn.SetReadDeadline(time.Now().Add(pongTimeout)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	conn.SetPongHandler(func(string) error { return conn.SetReadDeadline(time.Now().Add(pongTimeout)) })
+
+	sock := &socket{
+		conn:         conn,
+		logger:       logger,
+		writeTimeout: s.Config.SocketWriteTimeout,
+		outbound:     make(chan event.Envelope, s.Config.SocketQueueSize),
+		stopped:      make(chan struct{}),
+		writerDone:   make(chan struct{}),
+	}
+
+	socketsOpen.Add(1)
+	socketsOpened.Add(1)
+	go sock.write(s.Config.SocketPingInterval)
+	return sock, nil
+}
+
+// send queues frame for writing. If the queue stays full for the write timeout, the peer isn't keeping up and the
+// socket is reaped. It reports whether frame was queued.
+func (sock *socket) send(frame event.Envelope) bool {
+	select {
+	case sock.outbound <- frame:
+		return true
+	case <-sock.stopped:
+		return false
+	default:
+	}
+
+	timer := time.NewTimer(sock.writeTimeout)
+	defer timer.Stop()
+
+	select {
+	case sock.outbound <- frame:
+		return true
+	case <-sock.stopped:
+		return false
+	case <-timer.C:
+		sock.reap(reapSlowConsumer)
+		return false
+	}
+}
+
+// nextReader is the connection's NextReader, reaping the socket if the peer has stopped answering pings.
+func (sock *socket) nextReader() (int, io.Reader, error) {
+	messageType, reader, err := sock.conn.NextReader()
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		sock.reap(reapPongTimeout)
+	}
+
+	return messageType, reader, err
+}
+
+// stream runs f over the socket until one of them ends or ctx is done, reaping the socket if the client fell
+// behind.
+func (sock *socket) stream(ctx context.Context, f feed) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-sock.done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	switch err := f(ctx, sock.send); err {
+	case errFeedDropped:
+		sock.reap(reapSlowConsumer)
+	case errFeedEnded:
+		sock.logger.Info("db closed stream")
+	}
+}
+
+// done is closed once the socket has been reaped or closed.
+func (sock *socket) done() <-chan struct{} {
+	return sock.stopped
+}
+
+// reap tells the peer why it's being dropped, if it's still there to hear it, and stops the socket.
+func (sock *socket) reap(reason string) {
+	sock.stopOnce.Do(func() {
+		sock.logger.Info("reaping socket", zap.String("reason", reason))
+		socketsReaped.Add(reason, 1)
+
+		code := websocket.CloseGoingAway
+		if reason == reapSlowConsumer {
+			code = websocket.ClosePolicyViolation
+		}
+
+		message := websocket.FormatCloseMessage(code, reason)
+		if err := sock.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(sock.writeTimeout)); err != nil {
+			sock.logger.Debug("failed to write close message", zap.Error(err))
+		}
+
+		close(sock.stopped)
+	})
+}
+
+// close stops the writer and closes the connection, which also ends any read in progress.
+func (sock *socket) close() error {
+	sock.stopOnce.Do(func() { close(sock.stopped) })
+	<-sock.writerDone
+
+	socketsOpen.Add(-1)
+	return sock.conn.Close()
+}
+
+func (sock *socket) write(pingInterval time.Duration) {
+	defer close(sock.writerDone)
+
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case frame := <-sock.outbound:
+			if err := sock.conn.SetWriteDeadline(time.Now().Add(sock.writeTimeout)); err != nil {
+				sock.logger.Error("failed to set write deadline", zap.Error(err))
+			}
+
+			if err := sock.conn.WriteJSON(frame); err != nil {
+				sock.logger.Info("failed to write to socket", zap.Error(err))
+				sock.reap(reapWriteFailed)
+				return
+			}
+
+		case <-ticker.C:
+			if err := sock.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(sock.writeTimeout)); err != nil {
+				sock.logger.Info("failed to ping socket", zap.Error(err))
+				sock.reap(reapWriteFailed)
+				return
+			}
+
+		case <-sock.stopped:
+			return
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/broothie/slink.chat/event"
+	"github.com/broothie/slink.chat/model"
+	"github.com/go-chi/chi/v5"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// sseRetry is how long EventSource clients wait before reconnecting, in milliseconds.
+const sseRetry = 3000
+
+// channelEvents streams a channel's events as Server-Sent Events, for clients whose websockets don't make it
+// through. EventSource resends the last message ID it saw as Last-Event-ID, so reconnects pick up where they left
+// off.
+func (s *Server) channelEvents(w http.ResponseWriter, r *http.Request) {
+	user, _ := model.UserFromContext(r.Context())
+	channelID := chi.URLParam(r, "channel_id")
+	if !s.requireSubscription(w, r, user, channelID) {
+		return
+	}
+
+	after := r.Header.Get("Last-Event-ID")
+	if after == "" {
+		after = r.URL.Query().Get("after")
+	}
+
+	s.streamEvents(w, r, s.channelFeed(user, channelID, after, time.Time{}))
+}
+
+// chatsEvents streams the user's channel list events as Server-Sent Events.
+func (s *Server) chatsEvents(w http.ResponseWriter, r *http.Request) {
+	user, _ := model.UserFromContext(r.Context())
+	s.streamEvents(w, r, s.chatsFeed(user, time.Time{}))
+}
+
+func (s *Server) streamEvents(w http.ResponseWriter, r *http.Request, f feed) {
+	logger := ctxzap.Extract(r.Context()).With(zap.String("at", "streamEvents"))
+
+	stream, err := newSSEStream(w)
+	if err != nil {
+		logger.Error("failed to start event stream", zap.Error(err))
+		s.render.JSON(w, http.StatusInternalServerError, errorMap(err))
+		return
+	}
+
+	ctx := r.Context()
+	go func() {
+		ticker := time.NewTicker(s.Config.SocketPingInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := stream.ping(); err != nil {
+					logger.Info("failed to ping event stream", zap.Error(err))
+					return
+				}
+
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	logger.Debug("event stream opened")
+	switch err := f(ctx, stream.send); err {
+	case errFeedDropped:
+		logger.Info("event stream fell behind")
+	case errFeedEnded:
+		logger.Info("db closed stream")
+	}
+}
+
+// sseStream writes events to a text/event-stream response. Its methods are safe to call concurrently.
+type sseStream struct {
+	mu      sync.Mutex
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+func newSSEStream(w http.ResponseWriter) (*sseStream, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, errors.New("response writer can't flush")
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	stream := &sseStream{w: w, flusher: flusher}
+	if err := stream.write(fmt.Sprintf("retry: %d\n\n", sseRetry)); err != nil {
+		return nil, err
+	}
+
+	return stream, nil
+}
+
+// send writes envelope as an event, reporting whether it got out. Created messages carry their ID as the event ID.
+func (stream *sseStream) send(envelope event.Envelope) bool {
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		return false
+	}
+
+	var id string
+	if message, ok := envelope.Payload.(model.Message); ok && envelope.Type == event.MessageCreated {
+		id = fmt.Sprintf("id: %s\n", message.ID)
+	}
+
+	return stream.write(fmt.Sprintf("%sdata: %s\n\n", id, data)) == nil
+}
+
+// ping writes a comment, keeping proxies from timing out an idle stream.
+func (stream *sseStream) ping() error {
+	return stream.write(": ping\n\n")
+}
+
+func (stream *sseStream) write(chunk string) error {
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+
+	if _, err := fmt.Fprint(stream.w, chunk); err != nil {
+		return errors.Wrap(err, "failed to write to event stream")
+	}
+
+	stream.flusher.Flush()
+	return nil
+}
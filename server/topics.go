@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/broothie/slink.chat/db"
+	"github.com/broothie/slink.chat/event"
+	"github.com/broothie/slink.chat/hub"
+	"github.com/broothie/slink.chat/model"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+func eventsTopic(channelID string) string {
+	return "events:" + channelID
+}
+
+func channelTopic(channelID string) string {
+	return "channel:" + channelID
+}
+
+// eventSource publishes the message and membership events of a channel.
+func (s *Server) eventSource(channelID string) hub.Source {
+	return func(ctx context.Context, publish func(any)) error {
+		group, ctx := errgroup.WithContext(ctx)
+		group.Go(func() error { return s.publishMessages(ctx, channelID, publish) })
+		group.Go(func() error { return s.publishMembers(ctx, channelID, publish) })
+		return group.Wait()
+	}
+}
+
+// publishMessages publishes each message created, edited or deleted in a channel from now on. Tombstones are
+// published as deletions.
+func (s *Server) publishMessages(ctx context.Context, channelID string, publish func(any)) error {
+	snapshots := s.DB.
+		CollectionFor(model.TypeMessage).
+		Where("channel_id", "==", channelID).
+		Where("updated_at", ">", time.Now()).
+		Snapshots(ctx)
+	defer snapshots.Stop()
+
+	return eachChange(snapshots, func(change db.DocumentChange) {
+		var message model.Message
+		if err := change.Doc.DataTo(&message); err != nil {
+			s.Logger.Error("failed to read message", zap.Error(err), zap.String("channel_id", channelID))
+			return
+		}
+
+		switch {
+		case change.Kind == db.DocumentRemoved, message.Deleted():
+			publish(event.New(event.MessageDeleted, channelID, event.MessageRef{MessageID: change.Doc.ID(), ChannelID: channelID}))
+		case message.UpdatedAt.After(message.CreatedAt):
+			publish(event.New(event.MessageUpdated, channelID, message))
+		default:
+			publish(event.New(event.MessageCreated, channelID, message))
+		}
+	})
+}
+
+// publishMembers publishes each user joining or leaving a channel from now on.
+func (s *Server) publishMembers(ctx context.Context, channelID string, publish func(any)) error {
+	snapshots := s.DB.CollectionFor(model.TypeSubscription).Where("channel_id", "==", channelID).Snapshots(ctx)
+	defer snapshots.Stop()
+
+	// The first batch is everyone already in the channel
+	if _, err := snapshots.Next(); err != nil {
+		return listenError(err)
+	}
+
+	return eachChange(snapshots, func(change db.DocumentChange) {
+		var subscription model.Subscription
+		if err := change.Doc.DataTo(&subscription); err != nil {
+			s.Logger.Error("failed to read subscription", zap.Error(err), zap.String("channel_id", channelID))
+			return
+		}
+
+		member := event.Member{ChannelID: channelID, UserID: subscription.UserID, Role: subscription.Role}
+		switch change.Kind {
+		case db.DocumentAdded:
+			publish(event.New(event.MemberJoined, channelID, member))
+		case db.DocumentRemoved:
+			publish(event.New(event.MemberLeft, channelID, member))
+		}
+	})
+}
+
+// channelSource publishes a channel each time it's modified.
+func (s *Server) channelSource(channelID string) hub.Source {
+	return func(ctx context.Context, publish func(any)) error {
+		snapshots := s.DB.CollectionFor(model.TypeChannel).Where("id", "==", channelID).Snapshots(ctx)
+		defer snapshots.Stop()
+
+		return eachChange(snapshots, func(change db.DocumentChange) {
+			if change.Kind != db.DocumentModified {
+				return
+			}
+
+			var channel model.Channel
+			if err := change.Doc.DataTo(&channel); err != nil {
+				s.Logger.Error("failed to read channel change", zap.Error(err), zap.String("channel_id", channelID))
+				return
+			}
+
+			publish(event.New(event.ChannelUpdated, channelID, channel))
+		})
+	}
+}
+
+// eachChange calls f with each change until the listener stops.
+func eachChange(snapshots db.Listener, f func(db.DocumentChange)) error {
+	for {
+		changes, err := snapshots.Next()
+		if err != nil {
+			return listenError(err)
+		}
+
+		for _, change := range changes {
+			f(change)
+		}
+	}
+}
+
+// listenError treats a listen timeout as the listener ending cleanly.
+func listenError(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return nil
+	}
+
+	return err
+}
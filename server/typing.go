@@ -0,0 +1,146 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/broothie/slink.chat/bus"
+	"github.com/broothie/slink.chat/event"
+	"github.com/broothie/slink.chat/hub"
+	"go.uber.org/zap"
+)
+
+const (
+	// typingTimeout is how long someone shows as typing without hearing from them again.
+	typingTimeout = 6 * time.Second
+
+	// typingThrottle is the least time between typing.started events for one typist.
+	typingThrottle = 2 * time.Second
+
+	// typingBusTopic carries typing events to the other instances.
+	typingBusTopic = "typing"
+)
+
+// typing tracks who is typing in each channel, publishing to the channel's events topic as they start and stop.
+// None of it is persisted, so events are broadcast for other instances to publish to their own clients.
+type typing struct {
+	hub    *hub.Hub
+	bus    bus.Bus
+	logger *zap.Logger
+
+	mu      sync.Mutex
+	typists map[typistKey]*typist
+}
+
+type typistKey struct {
+	channelID string
+	userID    string
+}
+
+type typist struct {
+	timer       *time.Timer
+	expiresAt   time.Time
+	publishedAt time.Time
+}
+
+func newTyping(hub *hub.Hub, bus bus.Bus, logger *zap.Logger) *typing {
+	t := &typing{hub: hub, bus: bus, logger: logger, typists: make(map[typistKey]*typist)}
+	bus.Subscribe(typingBusTopic, t.receive)
+	return t
+}
+
+// start marks userID as typing in channelID until stop is called or typingTimeout passes without another start.
+func (t *typing) start(channelID, userID string) {
+	key := typistKey{channelID: channelID, userID: userID}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	current, ok := t.typists[key]
+	if !ok {
+		added := new(typist)
+		added.timer = time.AfterFunc(typingTimeout, func() { t.expire(key, added) })
+		t.typists[key] = added
+		current = added
+	} else {
+		current.timer.Reset(typingTimeout)
+	}
+
+	current.expiresAt = time.Now().Add(typingTimeout)
+
+	// Clients expire typists too, so refresh them every so often
+	if time.Since(current.publishedAt) >= typingThrottle {
+		current.publishedAt = time.Now()
+		t.publish(event.TypingStarted, key)
+	}
+}
+
+// stop clears userID typing in channelID, if they were.
+func (t *typing) stop(channelID, userID string) {
+	key := typistKey{channelID: channelID, userID: userID}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if current, ok := t.typists[key]; ok {
+		t.remove(key, current)
+	}
+}
+
+// expire removes a typist when its timer fires, unless it was restarted or replaced in the meantime.
+func (t *typing) expire(key typistKey, expiring *typist) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if current, ok := t.typists[key]; ok && current == expiring && !time.Now().Before(current.expiresAt) {
+		t.remove(key, current)
+	}
+}
+
+// remove must be called with t.mu held.
+func (t *typing) remove(key typistKey, current *typist) {
+	current.timer.Stop()
+	delete(t.typists, key)
+	t.publish(event.TypingStopped, key)
+}
+
+func (t *typing) publish(eventType event.Type, key typistKey) {
+	envelope := event.New(eventType, key.channelID, event.Typing{ChannelID: key.channelID, UserID: key.userID})
+	t.hub.Publish(eventsTopic(key.channelID), envelope)
+
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		t.logger.Error("failed to marshal typing event", zap.Error(err))
+		return
+	}
+
+	go func() {
+		if err := t.bus.Publish(context.Background(), typingBusTopic, data); err != nil {
+			t.logger.Error("failed to broadcast typing event", zap.Error(err))
+		}
+	}()
+}
+
+// receive publishes another instance's typing event to this one's clients.
+func (t *typing) receive(data []byte) {
+	var envelope struct {
+		event.Envelope
+		Payload event.Typing `json:"payload"`
+	}
+
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		t.logger.Error("failed to unmarshal typing event", zap.Error(err))
+		return
+	}
+
+	envelope.Envelope.Payload = envelope.Payload
+	t.hub.Publish(eventsTopic(envelope.ChannelID), envelope.Envelope)
+}
+
+// fromSelf reports whether envelope is userID's own typing, which isn't echoed back to them.
+func fromSelf(envelope event.Envelope, userID string) bool {
+	typing, ok := envelope.Payload.(event.Typing)
+	return ok && typing.UserID == userID
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/broothie/slink.chat/db"
+	"github.com/broothie/slink.chat/event"
+	"github.com/broothie/slink.chat/model"
+	"github.com/broothie/slink.chat/util"
+	"github.com/go-chi/chi/v5"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+)
+
+// maxUnreadCount caps how many messages are counted, so a long-neglected channel costs the same as a busy one.
+const maxUnreadCount = 100
+
+func (s *Server) markChannelRead(w http.ResponseWriter, r *http.Request) {
+	logger := ctxzap.Extract(r.Context())
+
+	var params struct {
+		MessageID string `json:"messageID"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		logger.Error("failed to decode params", zap.Error(err))
+		s.render.JSON(w, http.StatusBadRequest, errorMap(err))
+		return
+	}
+
+	user, _ := model.UserFromContext(r.Context())
+	channelID := chi.URLParam(r, "channel_id")
+	subscription, err := db.NewFetcher[model.Subscription](s.DB).Fetch(r.Context(), model.SubscriptionID(user.ID, channelID))
+	if err != nil {
+		if err == db.NotFound {
+			s.render.JSON(w, http.StatusUnauthorized, errorMap(errors.New("user not in channel")))
+			return
+		}
+
+		logger.Error("failed to fetch subscription", zap.Error(err))
+		s.render.JSON(w, http.StatusInternalServerError, errorMap(err))
+		return
+	}
+
+	var message model.Message
+	if params.MessageID != "" {
+		message, err = db.NewFetcher[model.Message](s.DB).Fetch(r.Context(), params.MessageID)
+	} else {
+		message, err = db.NewFetcher[model.Message](s.DB).FetchFirst(r.Context(), func(query db.Query) db.Query {
+			return query.Where("channel_id", "==", channelID).OrderBy("seq", db.Desc)
+		})
+	}
+
+	if err != nil && err != db.NotFound {
+		logger.Error("failed to fetch message", zap.Error(err))
+		s.render.JSON(w, http.StatusInternalServerError, errorMap(err))
+		return
+	}
+
+	if err == db.NotFound || message.ChannelID != channelID {
+		s.render.JSON(w, http.StatusBadRequest, errorMap(errors.New("message not in channel")))
+		return
+	}
+
+	// Read markers only move forward, so an old tab can't mark newer messages unread
+	if message.Seq > subscription.LastReadSeq {
+		subscription.LastReadMessageID = message.ID
+		subscription.LastReadSeq = message.Seq
+		subscription.LastReadAt = message.CreatedAt
+		subscription.UpdatedAt = time.Now()
+
+		if err := s.DB.CollectionFor(subscription.Type()).Doc(subscription.ID).Update(r.Context(), []db.Update{
+			{Path: "last_read_message_id", Value: subscription.LastReadMessageID},
+			{Path: "last_read_seq", Value: subscription.LastReadSeq},
+			{Path: "last_read_at", Value: subscription.LastReadAt},
+			{Path: "updated_at", Value: subscription.UpdatedAt},
+		}); err != nil {
+			logger.Error("failed to update subscription", zap.Error(err))
+			s.render.JSON(w, http.StatusInternalServerError, errorMap(err))
+			return
+		}
+	}
+
+	unread, err := s.unread(r.Context(), user, subscription)
+	if err != nil {
+		logger.Error("failed to count unread messages", zap.Error(err))
+		s.render.JSON(w, http.StatusInternalServerError, errorMap(err))
+		return
+	}
+
+	s.render.JSON(w, http.StatusOK, util.Map{"subscription": subscription, "unread": unread})
+}
+
+// unread counts messages from other users since subscription was last read, and how many of those mention user.
+// Until something's been read, messages from before the user joined don't count.
+func (s *Server) unread(ctx context.Context, user model.User, subscription model.Subscription) (event.Unread, error) {
+	unread, _, err := s.countUnread(ctx, user, subscription)
+	return unread, err
+}
+
+// countUnread is unread, also returning the seq of the last message it counted up to, so later messages can be
+// counted from there.
+func (s *Server) countUnread(ctx context.Context, user model.User, subscription model.Subscription) (event.Unread, int64, error) {
+	messages, err := db.NewFetcher[model.Message](s.DB).Query(ctx, func(query db.Query) db.Query {
+		return query.
+			Where("channel_id", "==", subscription.ChannelID).
+			Where("seq", ">", subscription.LastReadSeq).
+			OrderBy("seq", db.Desc).
+			Limit(maxUnreadCount)
+	})
+	if err != nil {
+		return event.Unread{}, 0, errors.Wrap(err, "failed to fetch unread messages")
+	}
+
+	seq := subscription.LastReadSeq
+	if len(messages) > 0 {
+		seq = messages[0].Seq
+	}
+
+	messages = lo.Reject(messages, func(message model.Message, _ int) bool {
+		return message.UserID == user.ID || (subscription.LastReadSeq == 0 && message.CreatedAt.Before(subscription.JoinedAt))
+	})
+	return event.Unread{
+		ChannelID:         subscription.ChannelID,
+		LastReadMessageID: subscription.LastReadMessageID,
+		UnreadCount:       len(messages),
+		MentionCount:      lo.CountBy(messages, func(message model.Message) bool { return message.MentionsUser(user.ID) }),
+	}, seq, nil
+}
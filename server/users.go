@@ -3,11 +3,9 @@ package server
 import (
 	"encoding/json"
 	"net/http"
-	"sort"
 	"strings"
 	"time"
 
-	"cloud.google.com/go/firestore"
 	"github.com/broothie/slink.chat/async/job"
 	"github.com/broothie/slink.chat/db"
 	"github.com/broothie/slink.chat/model"
@@ -36,7 +34,7 @@ func (s *Server) createUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	logger = logger.With(zap.String("screenname", params.Screenname))
-	if _, err := db.NewFetcher[model.User](s.DB).FetchFirst(r.Context(), func(query firestore.Query) firestore.Query {
+	if _, err := db.NewFetcher[model.User](s.DB).FetchFirst(r.Context(), func(query db.Query) db.Query {
 		return query.Where("screenname", "==", params.Screenname)
 	}); err == nil {
 		logger.Info("screenname is taken")
@@ -50,10 +48,11 @@ func (s *Server) createUser(w http.ResponseWriter, r *http.Request) {
 
 	now := time.Now()
 	user := model.User{
-		ID:         xid.New().String(),
-		CreatedAt:  now,
-		UpdatedAt:  now,
-		Screenname: params.Screenname,
+		ID:            xid.New().String(),
+		CreatedAt:     now,
+		UpdatedAt:     now,
+		Screenname:    params.Screenname,
+		ScreennameKey: model.ScreennameKey(params.Screenname),
 	}
 
 	if err := user.UpdatePassword(params.Password); err != nil {
@@ -62,7 +61,7 @@ func (s *Server) createUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if _, err := s.DB.CollectionFor(user.Type()).Doc(user.ID).Create(r.Context(), user); err != nil {
+	if err := s.DB.CollectionFor(user.Type()).Doc(user.ID).Create(r.Context(), user); err != nil {
 		logger.Error("failed to create user", zap.Error(err))
 		s.render.JSON(w, http.StatusInternalServerError, errorMap(err))
 		return
@@ -142,6 +141,12 @@ func (s *Server) showUsers(w http.ResponseWriter, r *http.Request) {
 func (s *Server) searchUsers(w http.ResponseWriter, r *http.Request) {
 	logger := ctxzap.Extract(r.Context())
 
+	params, err := pageParams(r)
+	if err != nil {
+		s.render.JSON(w, http.StatusBadRequest, errorMap(err))
+		return
+	}
+
 	query := r.URL.Query().Get("query")
 	if query == "" {
 		s.render.JSON(w, http.StatusOK, util.Map{"users": []model.User{}})
@@ -157,6 +162,11 @@ func (s *Server) searchUsers(w http.ResponseWriter, r *http.Request) {
 
 	user, _ := model.UserFromContext(r.Context())
 	users = lo.Reject(users, func(u model.User, _ int) bool { return u.ID == user.ID })
-	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
-	s.render.JSON(w, http.StatusOK, util.Map{"users": users})
+	page, err := db.PageSlice(users, func(user model.User) string { return user.ID }, "screenname", params)
+	if err != nil {
+		s.render.JSON(w, http.StatusBadRequest, errorMap(err))
+		return
+	}
+
+	s.render.JSON(w, http.StatusOK, util.Map{"users": page.Items, "next": page.Next, "prev": page.Prev})
 }
@@ -0,0 +1,77 @@
+package unfurl
+
+import (
+	"net"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+var errPrivateAddress = errors.New("address isn't public")
+
+// reservedNetworks are the ranges, beyond what net.IP can tell on its own, that aren't on the public internet.
+var reservedNetworks = parseCIDRs(
+	"0.0.0.0/8",       // This network
+	"100.64.0.0/10",   // Carrier-grade NAT
+	"192.0.0.0/24",    // IETF protocol assignments
+	"192.0.2.0/24",    // Documentation
+	"198.18.0.0/15",   // Benchmarking
+	"198.51.100.0/24", // Documentation
+	"203.0.113.0/24",  // Documentation
+	"240.0.0.0/4",     // Reserved, and broadcast
+	"::/96",           // IPv4-compatible IPv6
+	"64:ff9b::/96",    // IPv4/IPv6 translation, which can reach IPv4 private addresses
+	"64:ff9b:1::/48",  // Local-use IPv4/IPv6 translation
+	"2001:db8::/32",   // Documentation
+	"2002::/16",       // 6to4, which can embed IPv4 private addresses
+	"fec0::/10",       // Site-local
+)
+
+// checkAddress is a net.Dialer control function, refusing connections to anything but public unicast addresses.
+// It runs on the address the host resolved to, right before connecting.
+func checkAddress(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return errors.Wrap(err, "failed to split address")
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !isPublic(ip) {
+		return errors.Wrapf(errPrivateAddress, "%s", host)
+	}
+
+	return nil
+}
+
+func isPublic(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() || ip.IsMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsLinkLocalMulticast() {
+		return false
+	}
+
+	for _, network := range reservedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+
+		networks = append(networks, network)
+	}
+
+	return networks
+}
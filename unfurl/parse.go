@@ -0,0 +1,127 @@
+package unfurl
+
+import (
+	"io"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/broothie/slink.chat/model"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const (
+	maxTitleLength       = 200
+	maxDescriptionLength = 500
+	maxSiteNameLength    = 100
+	maxImageURLLength    = 2048
+)
+
+// Parse reads a preview from a page's head, preferring OpenGraph tags, then Twitter's, then the page's title and
+// description. Relative image URLs are resolved against base. Parse stops at the page's body, and the preview it
+// returns has no URL of its own.
+func Parse(r io.Reader, base *url.URL) model.Preview {
+	meta := make(map[string]string)
+	var title string
+
+	tokenizer := html.NewTokenizer(r)
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			break
+		}
+
+		token := tokenizer.Token()
+		if tokenType == html.EndTagToken && token.DataAtom == atom.Head {
+			break
+		}
+
+		if tokenType != html.StartTagToken && tokenType != html.SelfClosingTagToken {
+			continue
+		}
+
+		if token.DataAtom == atom.Body {
+			break
+		}
+
+		switch token.DataAtom {
+		case atom.Meta:
+			key := strings.ToLower(attr(token, "property"))
+			if key == "" {
+				key = strings.ToLower(attr(token, "name"))
+			}
+
+			// The first of a repeated tag is the one that counts
+			if _, ok := meta[key]; key != "" && !ok {
+				meta[key] = attr(token, "content")
+			}
+
+		case atom.Title:
+			if title == "" && tokenizer.Next() == html.TextToken {
+				title = tokenizer.Token().Data
+			}
+		}
+	}
+
+	return model.Preview{
+		Title:       clean(first(meta["og:title"], meta["twitter:title"], title), maxTitleLength),
+		Description: clean(first(meta["og:description"], meta["twitter:description"], meta["description"]), maxDescriptionLength),
+		Image:       imageURL(first(meta["og:image"], meta["og:image:url"], meta["og:image:secure_url"], meta["twitter:image"]), base),
+		SiteName:    clean(meta["og:site_name"], maxSiteNameLength),
+	}
+}
+
+func attr(token html.Token, key string) string {
+	for _, attr := range token.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+
+	return ""
+}
+
+func first(values ...string) string {
+	for _, value := range values {
+		if strings.TrimSpace(value) != "" {
+			return value
+		}
+	}
+
+	return ""
+}
+
+// clean collapses whitespace and cuts text to at most max runes, ending cut text with an ellipsis.
+func clean(text string, max int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if utf8.RuneCountInString(text) <= max {
+		return text
+	}
+
+	runes := []rune(text)
+	return strings.TrimSpace(string(runes[:max-1])) + "…"
+}
+
+// imageURL resolves an image's URL against the page's, keeping only http and https ones.
+func imageURL(raw string, base *url.URL) string {
+	raw = strings.TrimSpace(raw)
+	if raw == "" || len(raw) > maxImageURLLength {
+		return ""
+	}
+
+	image, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+
+	if base != nil {
+		image = base.ResolveReference(image)
+	}
+
+	if (image.Scheme != "http" && image.Scheme != "https") || image.Host == "" {
+		return ""
+	}
+
+	return image.String()
+}
@@ -0,0 +1,94 @@
+package unfurl
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/broothie/slink.chat/model"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		page string
+		want model.Preview
+	}{
+		{
+			name: "open graph",
+			page: `<html><head>
+				<title>Page title</title>
+				<meta name="description" content="Page description">
+				<meta property="og:title" content="OG title">
+				<meta property="og:description" content="OG description">
+				<meta property="og:image" content="https://cdn.example.com/a.png">
+				<meta property="og:site_name" content="Example">
+			</head></html>`,
+			want: model.Preview{Title: "OG title", Description: "OG description", Image: "https://cdn.example.com/a.png", SiteName: "Example"},
+		},
+		{
+			name: "twitter",
+			page: `<meta name="twitter:title" content="Tweet title"><meta name="twitter:description" content="Tweet description"><meta name="twitter:image" content="/t.png">`,
+			want: model.Preview{Title: "Tweet title", Description: "Tweet description", Image: "https://example.com/t.png"},
+		},
+		{
+			name: "title and description",
+			page: `<head><title>  Just a
+				title  </title><meta name="Description" content="Just a description"></head>`,
+			want: model.Preview{Title: "Just a title", Description: "Just a description"},
+		},
+		{
+			name: "blank open graph falls back",
+			page: `<meta property="og:title" content=" "><title>Fallback</title>`,
+			want: model.Preview{Title: "Fallback"},
+		},
+		{
+			name: "first of repeated tags",
+			page: `<meta property="og:title" content="First"><meta property="og:title" content="Second">`,
+			want: model.Preview{Title: "First"},
+		},
+		{
+			name: "relative image",
+			page: `<title>Hi</title><meta property="og:image" content="../img/a.png">`,
+			want: model.Preview{Title: "Hi", Image: "https://example.com/img/a.png"},
+		},
+		{
+			name: "unsafe image",
+			page: `<title>Hi</title><meta property="og:image" content="javascript:alert(1)">`,
+			want: model.Preview{Title: "Hi"},
+		},
+		{
+			name: "entities",
+			page: `<title>Tom &amp; Jerry</title><meta property="og:description" content="&lt;b&gt;">`,
+			want: model.Preview{Title: "Tom & Jerry", Description: "<b>"},
+		},
+		{
+			name: "stops at body",
+			page: `<head></head><body><title>Not the title</title></body>`,
+			want: model.Preview{},
+		},
+		{
+			name: "stops at end of head",
+			page: `<title>Title</title></head><meta property="og:title" content="Too late">`,
+			want: model.Preview{Title: "Title"},
+		},
+	}
+
+	base, _ := url.Parse("https://example.com/posts/1")
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Parse(strings.NewReader(test.page), base); got != test.want {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParse_Truncates(t *testing.T) {
+	page := `<title>` + strings.Repeat("é", maxTitleLength*2) + `</title>`
+
+	title := Parse(strings.NewReader(page), nil).Title
+	if want := strings.Repeat("é", maxTitleLength-1) + "…"; title != want {
+		t.Errorf("got %q, want %q", title, want)
+	}
+}
@@ -0,0 +1,137 @@
+package unfurl
+
+import (
+	"context"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+
+	"github.com/broothie/slink.chat/model"
+	"github.com/pkg/errors"
+	"golang.org/x/net/html/charset"
+)
+
+const (
+	// timeout bounds a whole fetch, redirects included. The others bound its steps, so that one slow step can't
+	// use all of it.
+	timeout             = 5 * time.Second
+	dialTimeout         = 2 * time.Second
+	tlsHandshakeTimeout = 2 * time.Second
+	headerTimeout       = 3 * time.Second
+
+	// maxBodySize is as much of a page as is read. Meta tags belong in the head, which is nearly always well
+	// within it.
+	maxBodySize = 512 << 10
+
+	maxRedirects = 5
+
+	userAgent = "Mozilla/5.0 (compatible; slinkbot/1.0; +https://slink.chat)"
+)
+
+var (
+	// ErrNotHTML is returned for links to things other than web pages, which have nothing to preview.
+	ErrNotHTML = errors.New("not an html page")
+
+	errScheme       = errors.New("only http and https links can be unfurled")
+	errTooManyHops  = errors.New("too many redirects")
+	errBadStatus    = errors.New("unexpected response status")
+	errEmptyPreview = errors.New("page has nothing to preview")
+)
+
+// Unfurler gets previews of pages.
+type Unfurler struct {
+	Client *http.Client
+}
+
+// New returns an Unfurler that only connects to public addresses, checked after DNS resolution so that neither
+// redirects nor DNS tricks can reach the private network. It ignores proxy settings, which would connect on its
+// behalf.
+func New() *Unfurler {
+	return &Unfurler{Client: newClient(checkAddress)}
+}
+
+// newClient returns a client for fetching pages, calling control on each address it's about to connect to.
+func newClient(control func(network, address string, conn syscall.RawConn) error) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy: nil,
+			DialContext: (&net.Dialer{
+				Timeout: dialTimeout,
+				Control: control,
+			}).DialContext,
+			TLSHandshakeTimeout:   tlsHandshakeTimeout,
+			ResponseHeaderTimeout: headerTimeout,
+			MaxIdleConns:          16,
+			IdleConnTimeout:       30 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return errTooManyHops
+			}
+
+			return checkScheme(req.URL)
+		},
+	}
+}
+
+// Fetch gets a preview of the page at rawURL.
+func (u *Unfurler) Fetch(ctx context.Context, rawURL string) (model.Preview, error) {
+	pageURL, err := url.Parse(rawURL)
+	if err != nil {
+		return model.Preview{}, errors.Wrap(err, "failed to parse url")
+	}
+
+	if err := checkScheme(pageURL); err != nil {
+		return model.Preview{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL.String(), nil)
+	if err != nil {
+		return model.Preview{}, errors.Wrap(err, "failed to create request")
+	}
+
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+
+	response, err := u.Client.Do(req)
+	if err != nil {
+		return model.Preview{}, errors.Wrap(err, "failed to fetch page")
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return model.Preview{}, errors.Wrapf(errBadStatus, "got %d", response.StatusCode)
+	}
+
+	contentType := response.Header.Get("Content-Type")
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return model.Preview{}, ErrNotHTML
+	}
+
+	body, err := charset.NewReader(io.LimitReader(response.Body, maxBodySize), contentType)
+	if err != nil {
+		return model.Preview{}, errors.Wrap(err, "failed to decode page")
+	}
+
+	// The preview is of the page that was linked, though its details come from wherever that redirected to
+	preview := Parse(body, response.Request.URL)
+	if preview.Title == "" && preview.Description == "" {
+		return model.Preview{}, errEmptyPreview
+	}
+
+	preview.URL = rawURL
+	return preview, nil
+}
+
+func checkScheme(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errScheme
+	}
+
+	return nil
+}
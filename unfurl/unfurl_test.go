@@ -0,0 +1,294 @@
+package unfurl
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/broothie/slink.chat/model"
+	"github.com/pkg/errors"
+)
+
+// testUnfurler returns an Unfurler that can reach server, despite it being on loopback, and is otherwise as
+// guarded as New's.
+func testUnfurler(server *httptest.Server) *Unfurler {
+	allowed := server.Listener.Addr().String()
+	return &Unfurler{Client: newClient(func(network, address string, conn syscall.RawConn) error {
+		if address == allowed {
+			return nil
+		}
+
+		return checkAddress(network, address, conn)
+	})}
+}
+
+func page(w http.ResponseWriter, body string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, body)
+}
+
+func TestFetch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("User-Agent") != userAgent {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		page(w, `<meta property="og:title" content="Hello"><meta property="og:image" content="/a.png">`)
+	}))
+	defer server.Close()
+
+	preview, err := testUnfurler(server).Fetch(context.Background(), server.URL+"/post")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := model.Preview{URL: server.URL + "/post", Title: "Hello", Image: server.URL + "/a.png"}
+	if preview != want {
+		t.Errorf("got %+v, want %+v", preview, want)
+	}
+}
+
+func TestFetch_Redirect(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/short", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/posts/long", http.StatusFound)
+	})
+	mux.HandleFunc("/posts/long", func(w http.ResponseWriter, r *http.Request) {
+		page(w, `<title>Long</title><meta property="og:image" content="img.png">`)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	preview, err := testUnfurler(server).Fetch(context.Background(), server.URL+"/short")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The preview is of the link, with details from where it led
+	want := model.Preview{URL: server.URL + "/short", Title: "Long", Image: server.URL + "/posts/img.png"}
+	if preview != want {
+		t.Errorf("got %+v, want %+v", preview, want)
+	}
+}
+
+func TestFetch_Charset(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=windows-1252")
+		w.Write([]byte("<title>Caf\xe9</title>"))
+	}))
+	defer server.Close()
+
+	preview, err := testUnfurler(server).Fetch(context.Background(), server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if preview.Title != "Café" {
+		t.Errorf("got %q, want decoded title", preview.Title)
+	}
+}
+
+func TestFetch_Skips(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    error
+	}{
+		{
+			name: "not html",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/pdf")
+				w.Write([]byte("%PDF-1.4"))
+			},
+			want: ErrNotHTML,
+		},
+		{
+			name: "bad status",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNotFound)
+				page(w, "<title>Not found</title>")
+			},
+			want: errBadStatus,
+		},
+		{
+			name:    "nothing to preview",
+			handler: func(w http.ResponseWriter, r *http.Request) { page(w, `<p>Just a body</p>`) },
+			want:    errEmptyPreview,
+		},
+		{
+			name: "title past the size cap",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				page(w, strings.Repeat(" ", maxBodySize)+"<title>Too far</title>")
+			},
+			want: errEmptyPreview,
+		},
+		{
+			name: "too many redirects",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Redirect(w, r, r.URL.Path+"x", http.StatusFound)
+			},
+			want: errTooManyHops,
+		},
+		{
+			name: "redirect to another scheme",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Redirect(w, r, "ftp://example.com/file", http.StatusFound)
+			},
+			want: errScheme,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(test.handler)
+			defer server.Close()
+
+			_, err := testUnfurler(server).Fetch(context.Background(), server.URL+"/")
+			if !errors.Is(err, test.want) {
+				t.Errorf("got %v, want %v", err, test.want)
+			}
+		})
+	}
+}
+
+func TestFetch_SizeCap(t *testing.T) {
+	var written int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page(w, "<title>Endless</title>")
+
+		// Keep going until the client hangs up
+		chunk := []byte(strings.Repeat("<p>more</p>", 1024))
+		for r.Context().Err() == nil {
+			n, err := w.Write(chunk)
+			atomic.AddInt64(&written, int64(n))
+			if err != nil {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	preview, err := testUnfurler(server).Fetch(context.Background(), server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if preview.Title != "Endless" {
+		t.Errorf("got %q, want title", preview.Title)
+	}
+
+	// Fetch returns after reading its fill, while the server is still writing
+	if written := atomic.LoadInt64(&written); written > 64*maxBodySize {
+		t.Errorf("server wrote %d bytes before fetch returned", written)
+	}
+}
+
+func TestFetch_Timeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+
+	unfurler := testUnfurler(server)
+	unfurler.Client.Timeout = 100 * time.Millisecond
+
+	start := time.Now()
+	if _, err := unfurler.Fetch(context.Background(), server.URL); err == nil {
+		t.Error("got no error from a server that never responds")
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("fetch took %v", elapsed)
+	}
+}
+
+func TestFetch_PrivateAddresses(t *testing.T) {
+	var hits int32
+	private := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		page(w, "<title>Internal</title>")
+	}))
+	defer private.Close()
+
+	public := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, r.URL.Query().Get("to"), http.StatusFound)
+	}))
+	defer public.Close()
+
+	tests := []struct {
+		name string
+		url  string
+	}{
+		{"loopback", private.URL},
+		{"localhost", strings.Replace(private.URL, "127.0.0.1", "localhost", 1)},
+		{"link-local", "http://169.254.169.254/latest/meta-data/"},
+		{"rfc1918", "http://10.0.0.1/"},
+		{"redirect to loopback", public.URL + "/?to=" + private.URL},
+		{"redirect to link-local", public.URL + "/?to=http://169.254.169.254/latest/meta-data/"},
+		{"redirect to rfc1918", public.URL + "/?to=http://192.168.0.1/"},
+	}
+
+	// Only the public server is reachable, standing in for a site on the internet
+	unfurler := testUnfurler(public)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := unfurler.Fetch(context.Background(), test.url); !errors.Is(err, errPrivateAddress) {
+				t.Errorf("got %v, want %v", err, errPrivateAddress)
+			}
+		})
+	}
+
+	if hits := atomic.LoadInt32(&hits); hits != 0 {
+		t.Errorf("private server was hit %d times", hits)
+	}
+
+	if _, err := New().Fetch(context.Background(), public.URL); !errors.Is(err, errPrivateAddress) {
+		t.Errorf("got %v from New's unfurler, want %v", err, errPrivateAddress)
+	}
+}
+
+func TestCheckAddress(t *testing.T) {
+	tests := map[string]bool{
+		"93.184.216.34":        true,
+		"2606:2800:220:1::":    true,
+		"127.0.0.1":            false,
+		"::1":                  false,
+		"::ffff:127.0.0.1":     false,
+		"0.0.0.0":              false,
+		"10.1.2.3":             false,
+		"172.16.0.1":           false,
+		"192.168.1.1":          false,
+		"169.254.169.254":      false,
+		"fe80::1":              false,
+		"fc00::1":              false,
+		"100.64.0.1":           false,
+		"224.0.0.1":            false,
+		"255.255.255.255":      false,
+		"64:ff9b::a00:1":       false,
+		"2002:a00:1::":         false,
+		"::a00:1":              false,
+		"fd00:ec2::254":        false,
+		"2001:db8::1":          false,
+		"198.18.0.1":           false,
+		"::ffff:169.254.0.1":   false,
+		"::ffff:93.184.216.34": true,
+	}
+
+	for address, want := range tests {
+		if err := checkAddress("tcp", net.JoinHostPort(address, "80"), nil); (err == nil) != want {
+			t.Errorf("checkAddress(%q) = %v, want public: %v", address, err, want)
+		}
+	}
+}
@@ -1,13 +1,17 @@
 import * as React from 'react'
 import { useAppDispatch, useAppSelector } from "../hooks";
-import { destroySession } from "../store/userSlice";
+import { destroySession, updateAway } from "../store/userSlice";
 import { useEffect } from "react";
 import { destroyChannel, fetchChannels } from "../store/channelsSlice";
 import * as _ from 'lodash'
 import TitleBar from "./TitleBar";
-import {playDoorSlam} from "../audio";
-import {Channel} from "../model/model";
-import useSocket from "../useSocket";
+import {playDoorOpen, playDoorSlam} from "../audio";
+import {useSessionSocket} from "../sessionSocket";
+import {receiveUnread} from "../store/unreadsSlice";
+import {receivePresence} from "../store/presencesSlice";
+import {fetchUser} from "../store/usersSlice";
+import {fetchNotifications, readNotifications, receiveNotification} from "../store/notificationsSlice";
+import {Notification} from "../model/model";
 
 export default function ChannelList({ addChannel, openCreateChannel, openCreateChat, openSearchChannels }: {
 	addChannel: { (channelID: string, ring?: boolean) },
@@ -18,6 +22,10 @@ export default function ChannelList({ addChannel, openCreateChannel, openCreateC
 	const dispatch = useAppDispatch()
 	const user = useAppSelector(state => state.user.user)
 	const channels = useAppSelector(state => state.channels)
+	const unreads = useAppSelector(state => state.unreads)
+	const presences = useAppSelector(state => state.presences)
+	const users = useAppSelector(state => state.users)
+	const notifications = useAppSelector(state => state.notifications)
 
 	function signOff() {
 		dispatch(destroySession())
@@ -25,16 +33,67 @@ export default function ChannelList({ addChannel, openCreateChannel, openCreateC
 			.then(playDoorSlam)
 	}
 
+	function toggleAway() {
+		if (user.awayMessage) {
+			dispatch(updateAway(''))
+			return
+		}
+
+		const awayMessage = window.prompt('Away message', "I'm away from my computer right now.")
+		if (awayMessage) dispatch(updateAway(awayMessage))
+	}
+
 	function removeChannel(channelID) {
 		dispatch(destroyChannel(channelID))
 	}
 
+	function openNotification(notification: Notification) {
+		addChannel(notification.channelID)
+		dispatch(readNotifications([notification.notificationID]))
+	}
+
 	useEffect(() => { dispatch(fetchChannels()) }, [])
+	useEffect(() => { dispatch(fetchNotifications()) }, [])
+
+	useSessionSocket(event => {
+		switch (event.type) {
+			case 'channel.updated':
+				if (event.payload.private) addChannel(event.payload.channelID, true)
+				break
+
+			case 'unread.updated':
+				dispatch(receiveUnread(event.payload))
+				break
+
+			case 'notification.created':
+				if (!users[event.payload.actorID]) dispatch(fetchUser(event.payload.actorID))
+				dispatch(receiveNotification(event.payload))
+				break
 
-	useSocket('ChannelList', 'api/v1/channels/chats/messages', (channel: Channel) => {
-		addChannel(channel.channelID, true)
+			case 'notification.updated':
+				dispatch(receiveNotification(event.payload))
+				break
+
+			case 'presence.updated': {
+				const presence = event.payload
+				const previous = presences[presence.userID]
+				if (presence.userID !== user.userID && previous) {
+					if (previous.status === 'offline' && presence.status !== 'offline') playDoorOpen().catch(console.error)
+					if (previous.status !== 'offline' && presence.status === 'offline') playDoorSlam().catch(console.error)
+				}
+
+				if (!users[presence.userID]) dispatch(fetchUser(presence.userID))
+				dispatch(receivePresence(presence))
+				break
+			}
+		}
 	})
 
+	const buddies = _.sortBy(
+		_.filter(presences, presence => presence.userID !== user?.userID && presence.status !== 'offline' && !!users[presence.userID]),
+		presence => users[presence.userID].screenname,
+	)
+	const unreadNotifications = _.sortBy(_.reject(notifications, 'read'), 'createdAt')
 	const privateChannels = _.filter(channels, 'private')
 	const publicChannels = _.reject(channels, 'private')
 
@@ -47,7 +106,10 @@ export default function ChannelList({ addChannel, openCreateChannel, openCreateC
 			<div className="px-2 py-1 font-sans flex-grow flex flex-col">
 				<div className="text-sm flex flex-row justify-between">
 					<p>Welcome, {user.screenname}!</p>
-					<a className="link" onClick={signOff}>Sign Off</a>
+					<div className="space-x-2">
+						<a className="link" onClick={toggleAway}>{user.awayMessage ? "I'm Back" : 'Away'}</a>
+						<a className="link" onClick={signOff}>Sign Off</a>
+					</div>
 				</div>
 
 				<div className="hr mb-1"></div>
@@ -67,6 +129,50 @@ export default function ChannelList({ addChannel, openCreateChannel, openCreateC
 				<div className="hr my-0.5"/>
 
 				<div className="bg-white inset px-2 py-1 text-sm flex-grow h-0 overflow-y-scroll">
+					{unreadNotifications.length > 0 && (
+						<div>
+							<div className="p-1 border-b border-black flex flex-row justify-between">
+								<p>Mentions ({unreadNotifications.length})</p>
+
+								<div>
+									<a className="link" onClick={() => dispatch(readNotifications([]))}>Clear</a>
+								</div>
+							</div>
+
+							<div>
+								{unreadNotifications.map(notification => (
+									<div
+										key={notification.notificationID}
+										className="pl-3 pr-0.5 py-0.5 cursor-pointer"
+										onDoubleClick={() => openNotification(notification)}
+									>
+										<p className="hover:bg-logo-tile hover:text-white p-0.5 select-none">
+											{users[notification.actorID]?.screenname ?? 'Someone'} mentioned you
+											{channels[notification.channelID] && ` in ${channels[notification.channelID].name}`}
+										</p>
+									</div>
+								))}
+							</div>
+						</div>
+					)}
+
+					<div>
+						<div className="p-1 border-b border-black">
+							<p>Buddies ({buddies.length})</p>
+						</div>
+
+						<div>
+							{buddies.map(presence => (
+								<div key={presence.userID} className="pl-3 pr-0.5 py-0.5 select-none" title={presence.awayMessage}>
+									<p className={presence.status === 'online' ? '' : 'text-gray-500 italic'}>
+										{users[presence.userID].screenname}
+										{presence.status !== 'online' && ` (${presence.status})`}
+									</p>
+								</div>
+							))}
+						</div>
+					</div>
+
 					<div>
 						<div className="p-1 border-b border-black flex flex-row justify-between">
 							<p>Chats</p>
@@ -84,7 +190,10 @@ export default function ChannelList({ addChannel, openCreateChannel, openCreateC
 									onDoubleClick={() => addChannel(channel.channelID)}
 								>
 									<div className="hover:bg-logo-tile hover:text-white p-0.5 select-none flex flex-row justify-between">
-										<p>{channel.name}</p>
+										<p className={unreads[channel.channelID]?.unreadCount > 0 ? 'font-bold' : ''}>
+											{channel.name}
+											{unreads[channel.channelID]?.mentionCount > 0 && ` (@${unreads[channel.channelID].mentionCount})`}
+										</p>
 										<button className="button px-1 cursor-pointer" onClick={() => removeChannel(channel.channelID)}>-</button>
 									</div>
 								</div>
@@ -2,14 +2,17 @@ import * as React from 'react'
 import {useEffect, useRef, useState} from "react";
 import * as _ from "lodash";
 import {useAppDispatch, useAppSelector} from "../hooks";
-import {Message} from "../model/model";
+import {Attachment, Message, Preview, Reaction} from "../model/model";
 import {fetchUser} from "../store/usersSlice";
 import TitleBar from "./TitleBar";
 import {playMessageReceive, playMessageSend} from "../audio";
 import {createChat, fetchChannel, fetchChannelUsers} from "../store/channelsSlice";
-import {fetchMessages, receiveMessage} from "../store/messagesSlice";
-import useSocket from "../useSocket";
+import {addReaction, deleteMessage, editMessage, fetchMessages, fetchReplies, receiveMessage, removeReaction, tombstoneMessage, uploadAttachment} from "../store/messagesSlice";
+import {markChannelRead} from "../store/unreadsSlice";
+import sessionSocket, {useSessionSocket} from "../sessionSocket";
 import {DateTime} from "luxon";
+import {shallowEqual} from "react-redux";
+import {useTypists} from "../typing";
 
 export default function Chat({ channelID, close, addChannel }: {
 	channelID: string,
@@ -23,9 +26,38 @@ export default function Chat({ channelID, close, addChannel }: {
 	const dispatch = useAppDispatch()
 
 	const [message, setMessage] = useState('')
+	const [attachments, setAttachments] = useState<Attachment[]>([])
+	const fileInputRef = useRef<HTMLInputElement>()
+	const typists = useTypists(channelID)
+	const typistNames = useAppSelector(state => typists.map(userID => state.users[userID]?.screenname).filter(Boolean), shallowEqual)
 
-	const socket = useSocket('Chat', `api/v1/channels/${channelID}/messages/subscribe`, (message: Message) => {
-		addMessage(message)
+	const lastMessageIDRef = useRef<string>()
+	useEffect(() => sessionSocket.subscribe(channelID, () => lastMessageIDRef.current), [channelID])
+	useSessionSocket(event => {
+		if (event.channelID !== channelID) return
+
+		switch (event.type) {
+			case 'message.created':
+				addMessage(event.payload)
+				break
+
+			case 'message.updated':
+				dispatch(receiveMessage(event.payload))
+				break
+
+			case 'message.deleted':
+				dispatch(tombstoneMessage({ messageID: event.payload.messageID, deletedAt: event.ts }))
+				break
+
+			case 'member.joined':
+			case 'member.left':
+				dispatch(fetchChannelUsers(channelID))
+				break
+
+			case 'refetch.required':
+				dispatch(fetchMessages(channelID))
+				break
+		}
 	})
 
 	function onTextareaKeyDown(event) {
@@ -35,9 +67,28 @@ export default function Chat({ channelID, close, addChannel }: {
 		}
 	}
 
+	function onMessageChange(value: string) {
+		setMessage(value)
+		sessionSocket.typing(channelID, value !== '')
+	}
+
 	function sendMessage() {
-		socket.send(JSON.stringify({ body: message }))
+		if (message === '' && attachments.length === 0) return
+
+		sessionSocket.send(channelID, message, undefined, attachments.map(attachment => attachment.attachmentID))
 		setMessage('')
+		setAttachments([])
+	}
+
+	function onFileChange(files: FileList | null) {
+		_.forEach(files, file => {
+			dispatch(uploadAttachment({ channelID, file }))
+				.unwrap()
+				.then(attachment => setAttachments(attachments => [...attachments, attachment]))
+				.catch(error => alert(`Couldn't attach ${file.name}: ${error.message}`))
+		})
+
+		fileInputRef.current.value = ''
 	}
 
 	function addMessage(message: Message) {
@@ -56,6 +107,14 @@ export default function Chat({ channelID, close, addChannel }: {
 			.then(() => dispatch(fetchMessages(channelID)))
 	}, [])
 
+	const lastMessage = _.last(_.sortBy(messages, 'seq'))
+	lastMessageIDRef.current = lastMessage?.messageID
+	useEffect(() => {
+		if (lastMessage && lastMessage.userID !== user.userID) {
+			dispatch(markChannelRead({ channelID, messageID: lastMessage.messageID }))
+		}
+	}, [lastMessage?.messageID])
+
 	useEffect(() => {
 		if (!!windowRef.current) {
 			const window = windowRef.current as HTMLElement
@@ -76,7 +135,7 @@ export default function Chat({ channelID, close, addChannel }: {
 					className="bg-white inset w-80 h-52 font-serif text-sm p-1 overflow-y-auto whitespace-pre-wrap"
 					ref={windowRef}
 				>
-					{_.sortBy(messages, 'createdAt').map(message => (
+					{_.sortBy(messages.filter(message => !message.parentID), 'seq').map(message => (
 						<MessageItem key={message.messageID} message={message} addChannel={addChannel}/>
 					))}
 				</div>
@@ -91,19 +150,53 @@ export default function Chat({ channelID, close, addChannel }: {
 						className="bg-white inset resize-none w-full p-1 outline-0 font-serif text-sm overflow-y-auto"
 						autoFocus={true}
 						value={message}
-						onChange={e => setMessage(e.target.value)}
+						onChange={e => onMessageChange(e.target.value)}
 						onKeyDown={onTextareaKeyDown}
 					/>
 
-					<div className="flex flex-row justify-end pb-2">
-						<button
-							type="submit"
-							className="button px-1 py-0.5 text-sm"
-							disabled={message === ''}
-							onClick={sendMessage}
-						>
-							Send
-						</button>
+					{attachments.length > 0 && (
+						<div className="flex flex-row flex-wrap gap-1 text-xs pb-1">
+							{attachments.map(attachment => (
+								<span key={attachment.attachmentID} className="px-1 border border-gray-400">
+									{attachment.name}
+									&nbsp;<a className="cursor-pointer" onClick={() => setAttachments(attachments.filter(other => other !== attachment))}>×</a>
+								</span>
+							))}
+						</div>
+					)}
+
+					<div className="flex flex-row justify-between items-center pb-2">
+						<p className="text-xs italic">
+							{typistNames.length > 0 && `${typistNames.join(', ')} ${typistNames.length > 1 ? 'are' : 'is'} typing…`}
+						</p>
+
+						<div className="flex flex-row gap-1">
+							<input
+								type="file"
+								className="hidden"
+								multiple={true}
+								accept="image/png,image/jpeg,image/gif,application/pdf,text/plain"
+								ref={fileInputRef}
+								onChange={e => onFileChange(e.target.files)}
+							/>
+
+							<button
+								type="button"
+								className="button px-1 py-0.5 text-sm"
+								onClick={() => fileInputRef.current.click()}
+							>
+								Attach
+							</button>
+
+							<button
+								type="submit"
+								className="button px-1 py-0.5 text-sm"
+								disabled={message === '' && attachments.length === 0}
+								onClick={sendMessage}
+							>
+								Send
+							</button>
+						</div>
 					</div>
 				</div>
 			</div>
@@ -118,6 +211,7 @@ function MessageItem({ message, addChannel }: {
 	const currentUser = useAppSelector(state => state.user.user)
 	const messageUser = useAppSelector(state => state.users[message.userID])
 	const dispatch = useAppDispatch()
+	const [threadOpen, setThreadOpen] = useState(false)
 
 	useEffect(() => {
 		if (!messageUser) {
@@ -131,9 +225,40 @@ function MessageItem({ message, addChannel }: {
 			.then(channel => { addChannel(channel.channelID) })
 	}
 
+	function onThreadClick() {
+		if (!threadOpen) dispatch(fetchReplies({ channelID: message.channelID, messageID: message.messageID }))
+		setThreadOpen(!threadOpen)
+	}
+
+	function onEditClick() {
+		const body = prompt('Edit message', message.body)
+		if (body && body !== message.body) {
+			dispatch(editMessage({ channelID: message.channelID, messageID: message.messageID, body }))
+		}
+	}
+
+	function onDeleteClick() {
+		if (confirm('Delete this message?')) {
+			dispatch(deleteMessage({ channelID: message.channelID, messageID: message.messageID }))
+		}
+	}
+
+	function onReactClick() {
+		const emoji = prompt('React with')
+		if (emoji) {
+			dispatch(addReaction({ channelID: message.channelID, messageID: message.messageID, emoji: emoji.trim() }))
+		}
+	}
+
+	function onReactionClick(reaction: Reaction) {
+		const params = { channelID: message.channelID, messageID: message.messageID, emoji: reaction.emoji }
+		dispatch(reaction.userIDs.includes(currentUser.userID) ? removeReaction(params) : addReaction(params))
+	}
+
+	const isOwn = message.userID === currentUser.userID
 	return messageUser && (
-		<p title={DateTime.fromISO(message.createdAt).toLocaleString(DateTime.DATETIME_FULL)}>
-			{message.userID === currentUser.userID ? (
+		<div className={`group ${message.mentions?.includes(currentUser.userID) ? 'bg-yellow-100' : ''}`} title={DateTime.fromISO(message.createdAt).toLocaleString(DateTime.DATETIME_FULL)}>
+			{isOwn ? (
 				<span className="text-indigo-700">{messageUser.screenname}:</span>
 			) : (
 				<a className="text-red-500 cursor-pointer" onClick={onScreennameClick}>
@@ -141,7 +266,130 @@ function MessageItem({ message, addChannel }: {
 				</a>
 			)}
 
-			<span>&nbsp;{message.body}</span>
-		</p>
+			{message.autoReply && <span className="italic">&nbsp;(Auto-Response)</span>}
+			{message.deletedAt ? (
+				<span className="italic text-gray-500">&nbsp;message deleted</span>
+			) : (
+				<>
+					&nbsp;
+					{/* html is sanitized by the server */}
+					{message.html !== undefined ? <span dangerouslySetInnerHTML={{ __html: message.html }}/> : <span>{message.body}</span>}
+					{message.editedAt && <span className="text-xs text-gray-500">&nbsp;(edited)</span>}
+					{!message.parentID && (
+						<a className={`cursor-pointer text-xs underline ${message.replyCount ? '' : 'hidden group-hover:inline'}`} onClick={onThreadClick}>
+							&nbsp;{message.replyCount ? `${message.replyCount} ${message.replyCount === 1 ? 'reply' : 'replies'}` : 'reply'}
+						</a>
+					)}
+					<span className="hidden group-hover:inline text-xs">
+						&nbsp;<a className="cursor-pointer underline" onClick={onReactClick}>react</a>
+						{isOwn && (
+							<>
+								&nbsp;<a className="cursor-pointer underline" onClick={onEditClick}>edit</a>
+								&nbsp;<a className="cursor-pointer underline" onClick={onDeleteClick}>delete</a>
+							</>
+						)}
+					</span>
+				</>
+			)}
+
+			{!message.deletedAt && !_.isEmpty(message.attachments) && (
+				<div className="flex flex-col gap-1">
+					{message.attachments.map(attachment => (
+						<AttachmentItem key={attachment.attachmentID} attachment={attachment}/>
+					))}
+				</div>
+			)}
+
+			{!message.deletedAt && !_.isEmpty(message.previews) && (
+				<div className="flex flex-col gap-1">
+					{message.previews.map(preview => (
+						<PreviewItem key={preview.url} preview={preview}/>
+					))}
+				</div>
+			)}
+
+			{!_.isEmpty(message.reactions) && (
+				<div className="flex flex-row flex-wrap gap-1 text-xs">
+					{message.reactions.map(reaction => (
+						<a
+							key={reaction.emoji}
+							className={`cursor-pointer px-1 border ${reaction.userIDs.includes(currentUser.userID) ? 'border-indigo-700 font-bold' : 'border-gray-400'}`}
+							onClick={() => onReactionClick(reaction)}
+						>
+							{reaction.emoji} {reaction.count}
+						</a>
+					))}
+				</div>
+			)}
+
+			{threadOpen && <Thread parent={message} addChannel={addChannel}/>}
+		</div>
+	)
+}
+
+function AttachmentItem({ attachment }: { attachment: Attachment }) {
+	const url = `/api/v1/attachments/${attachment.attachmentID}`
+	if (attachment.thumbnail) {
+		return (
+			<a href={url} target="_blank" rel="noopener noreferrer" title={attachment.name}>
+				<img src={`${url}/thumbnail`} alt={attachment.name} className="max-w-full border border-gray-400"/>
+			</a>
+		)
+	}
+
+	return (
+		<a href={url} target="_blank" rel="noopener noreferrer" className="text-xs underline">
+			{attachment.name} ({Math.ceil(attachment.size / 1024)} KB)
+		</a>
+	)
+}
+
+function PreviewItem({ preview }: { preview: Preview }) {
+	return (
+		<a
+			href={preview.url}
+			target="_blank"
+			rel="nofollow noopener noreferrer"
+			className="flex flex-row gap-1 border-l-2 border-gray-400 pl-1 text-xs font-sans whitespace-normal"
+		>
+			{preview.image && <img src={preview.image} alt="" referrerPolicy="no-referrer" className="w-12 h-12 object-cover"/>}
+			<div className="min-w-0">
+				{preview.siteName && <p className="text-gray-500">{preview.siteName}</p>}
+				<p className="font-bold text-indigo-700">{preview.title || preview.url}</p>
+				{preview.description && <p className="truncate">{preview.description}</p>}
+			</div>
+		</a>
+	)
+}
+
+function Thread({ parent, addChannel }: {
+	parent: Message,
+	addChannel: { (channelID: string) },
+}) {
+	const replies = useAppSelector(state => _.filter(state.messages, message => message.parentID === parent.messageID))
+	const [reply, setReply] = useState('')
+
+	function onKeyDown(event) {
+		if (event.key === 'Enter' && reply !== '') {
+			event.preventDefault()
+			sessionSocket.send(parent.channelID, reply, parent.messageID)
+			setReply('')
+		}
+	}
+
+	return (
+		<div className="ml-4 pl-1 border-l border-gray-400">
+			{_.sortBy(replies, 'seq').map(message => (
+				<MessageItem key={message.messageID} message={message} addChannel={addChannel}/>
+			))}
+
+			<input
+				className="bg-white inset w-full px-1 outline-0 text-xs"
+				placeholder="Reply…"
+				value={reply}
+				onChange={e => setReply(e.target.value)}
+				onKeyDown={onKeyDown}
+			/>
+		</div>
 	)
 }
@@ -0,0 +1,70 @@
+import {Channel, Message, Notification, Unread} from "./model";
+
+// Mirrors event/schema.json, served at /api/v1/events/schema.json.
+export const EVENT_VERSION = 1
+
+export type MessageRef = {
+	messageID: string,
+	channelID: string,
+}
+
+export type Member = {
+	channelID: string,
+	userID: string,
+	role?: 'owner' | 'member',
+}
+
+export type Typing = {
+	channelID: string,
+	userID: string,
+}
+
+export type Presence = {
+	userID: string,
+	status: 'online' | 'away' | 'idle' | 'offline',
+	awayMessage?: string,
+}
+
+export type Refetch = {
+	channelID: string,
+	reason: string,
+}
+
+export type Ack = {
+	nonce?: string,
+	messageID: string,
+	seq: number,
+}
+
+export type ErrorPayload = {
+	message: string,
+	nonce?: string,
+}
+
+type Envelope<T extends string, P = undefined> = {
+	v: number,
+	id: string,
+	type: T,
+	ts: string,
+	channelID?: string,
+	payload: P,
+}
+
+export type Event =
+	| Envelope<'message.created', Message>
+	| Envelope<'message.updated', Message>
+	| Envelope<'message.deleted', MessageRef>
+	| Envelope<'channel.updated', Channel>
+	| Envelope<'member.joined', Member>
+	| Envelope<'member.left', Member>
+	| Envelope<'unread.updated', Unread>
+	| Envelope<'notification.created', Notification>
+	| Envelope<'notification.updated', Notification>
+	| Envelope<'typing.started', Typing>
+	| Envelope<'typing.stopped', Typing>
+	| Envelope<'presence.updated', Presence>
+	| Envelope<'refetch.required', Refetch>
+	| Envelope<'subscribed'>
+	| Envelope<'unsubscribed'>
+	| Envelope<'ack', Ack>
+	| Envelope<'error', ErrorPayload>
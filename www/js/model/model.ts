@@ -2,12 +2,18 @@
 export type User = {
 	userID: string,
 	screenname: string,
+	awayMessage: string,
 }
 
 export type Subscription = {
 	subscriptionID: string,
 	userID: string
-	channelID: string
+	channelID: string,
+	role: 'owner' | 'member',
+	joinedAt: string,
+	lastReadMessageID: string,
+	lastReadSeq: number,
+	muted: boolean,
 }
 
 export type Channel = {
@@ -16,10 +22,68 @@ export type Channel = {
 	private: boolean,
 }
 
+export type Unread = {
+	channelID: string,
+	lastReadMessageID: string,
+	unreadCount: number,
+	mentionCount: number,
+}
+
 export type Message = {
 	messageID: string,
 	body: string,
+	html?: string,
+	createdAt: string,
+	seq: number,
+	userID: string,
+	channelID: string,
+	autoReply: boolean,
+	editedAt?: string,
+	deletedAt?: string,
+	parentID?: string,
+	replyCount?: number,
+	lastReplyAt?: string,
+	mentions?: string[],
+	reactions?: Reaction[],
+	attachments?: Attachment[],
+	previews?: Preview[],
+}
+
+export type Notification = {
+	notificationID: string,
 	createdAt: string,
 	userID: string,
+	kind: 'mention',
+	actorID: string,
+	channelID: string,
+	messageID: string,
+	read: boolean,
+	readAt?: string,
+}
+
+export type Reaction = {
+	emoji: string,
+	count: number,
+	userIDs: string[],
+}
+
+export type Attachment = {
+	attachmentID: string,
+	userID: string,
 	channelID: string,
+	messageID: string,
+	name: string,
+	contentType: string,
+	size: number,
+	width?: number,
+	height?: number,
+	thumbnail: boolean,
+}
+
+export type Preview = {
+	url: string,
+	title: string,
+	description: string,
+	image: string,
+	siteName: string,
 }
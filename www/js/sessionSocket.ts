@@ -0,0 +1,264 @@
+import {useEffect, useRef} from "react";
+import * as _ from "lodash";
+import axios from "./axios";
+import {Event, EVENT_VERSION} from "./model/events";
+
+// TYPING_THROTTLE_MS is the least time between typing.start frames for a channel. The server expires typists it
+// hasn't heard from in a few seconds, so this has to stay under that.
+const TYPING_THROTTLE_MS = 2000
+
+// HEARTBEAT_MS is how often the socket tells the server whether the user is idle. IDLE_MS is how long without
+// input before they are.
+const HEARTBEAT_MS = 30 * 1000
+const IDLE_MS = 10 * 60 * 1000
+
+// MAX_FAILED_OPENS is how many times in a row the socket can fail to open before falling back to event streams,
+// for networks whose proxies break websockets.
+const MAX_FAILED_OPENS = 3
+
+type Frame = {
+	type: 'subscribe' | 'unsubscribe' | 'send' | 'typing.start' | 'typing.stop' | 'heartbeat',
+	channelID?: string,
+	body?: string,
+	idle?: boolean,
+	after?: string,
+	since?: string,
+	nonce?: string,
+	parentID?: string,
+	attachmentIDs?: string[],
+}
+
+type Listener = (event: Event) => void
+
+// SessionSocket is the one socket a session uses for every open chat and the channel list. Channel subscriptions
+// are reference counted and replayed whenever the socket reconnects, resuming from the last message each chat saw.
+// If the socket can't open at all, it falls back to an event stream per channel and sends over plain POSTs.
+class SessionSocket {
+	private socket: WebSocket | null = null
+	private failedOpens = 0
+	private streams: Map<string, EventSource> | null = null
+	private listeners = new Set<Listener>()
+	private channels = new Map<string, number>()
+	private lastSeen = new Map<string, () => string | undefined>()
+	private heardAt = new Map<string, string>()
+	private unacked = new Map<string, Frame>()
+	private typingSentAt = new Map<string, number>()
+	private heartbeat: ReturnType<typeof setInterval> | null = null
+	private lastActiveAt = Date.now()
+	private idle = false
+
+	constructor() {
+		const onActivity = () => {
+			this.lastActiveAt = Date.now()
+			if (this.idle) this.beat()
+		}
+
+		window.addEventListener('keydown', onActivity)
+		window.addEventListener('mousemove', _.throttle(onActivity, 1000))
+	}
+
+	listen(listener: Listener): () => void {
+		this.listeners.add(listener)
+		this.connect()
+
+		return () => {
+			this.listeners.delete(listener)
+			if (this.listeners.size === 0) this.disconnect()
+		}
+	}
+
+	// subscribe starts channel events. lastSeen gives the last message ID the caller has, so a reconnect can replay
+	// what it missed.
+	subscribe(channelID: string, lastSeen?: () => string | undefined): () => void {
+		const count = this.channels.get(channelID) ?? 0
+		this.channels.set(channelID, count + 1)
+		if (lastSeen) this.lastSeen.set(channelID, lastSeen)
+		if (count === 0 && this.isOpen()) this.write({ type: 'subscribe', channelID })
+		if (count === 0 && this.streams) this.openChannelStream(channelID)
+
+		return () => {
+			const count = this.channels.get(channelID) ?? 0
+			if (count > 1) {
+				this.channels.set(channelID, count - 1)
+				return
+			}
+
+			this.channels.delete(channelID)
+			this.lastSeen.delete(channelID)
+			this.heardAt.delete(channelID)
+			if (this.isOpen()) this.write({ type: 'unsubscribe', channelID })
+			if (this.streams) this.closeStream(channelID)
+		}
+	}
+
+	// send posts a message, as a reply in parentID's thread if given, with any attachments uploaded for it. It's
+	// held until the server acks it and sent again after a reconnect, which the nonce makes safe.
+	send(channelID: string, body: string, parentID?: string, attachmentIDs?: string[]) {
+		// The server stops typing on send
+		this.typingSentAt.delete(channelID)
+		const frame: Frame = { type: 'send', channelID, body, parentID, attachmentIDs, nonce: newNonce() }
+		this.unacked.set(frame.nonce, frame)
+		if (this.isOpen()) this.write(frame)
+		if (this.streams) this.post(frame)
+	}
+
+	// typing tells the channel's other members whether the user is typing. It's dropped if the socket is closed.
+	typing(channelID: string, typing: boolean) {
+		if (!this.isOpen()) return
+
+		const sentAt = this.typingSentAt.get(channelID)
+		if (typing) {
+			if (sentAt && Date.now() - sentAt < TYPING_THROTTLE_MS) return
+
+			this.typingSentAt.set(channelID, Date.now())
+			this.write({ type: 'typing.start', channelID })
+		} else if (sentAt) {
+			this.typingSentAt.delete(channelID)
+			this.write({ type: 'typing.stop', channelID })
+		}
+	}
+
+	private connect() {
+		if (this.socket || this.streams) return
+		if (this.failedOpens >= MAX_FAILED_OPENS) {
+			this.stream()
+			return
+		}
+
+		let opened = false
+		const protocol = location.protocol === 'https:' ? 'wss' : 'ws'
+		const socket = new WebSocket(`${protocol}://${location.host}/api/v1/socket`)
+
+		socket.onopen = () => {
+			console.log('session socket opened')
+			opened = true
+			this.failedOpens = 0
+			this.heartbeat = setInterval(() => this.beat(), HEARTBEAT_MS)
+			this.channels.forEach((_, channelID) => this.resubscribe(channelID))
+			this.unacked.forEach(frame => this.write(frame))
+		}
+
+		socket.onmessage = message => this.dispatch(JSON.parse(message.data) as Event)
+
+		socket.onclose = event => {
+			if (this.socket !== socket) return
+
+			console.log('server closed session socket', {event})
+			this.socket = null
+			if (!opened) this.failedOpens++
+			clearInterval(this.heartbeat)
+			setTimeout(() => { if (this.listeners.size > 0) this.connect() }, 1000)
+		}
+
+		this.socket = socket
+	}
+
+	// stream switches to event streams: one for the channel list, and one for each subscribed channel. EventSource
+	// reconnects on its own, resuming from the last message ID it saw.
+	private stream() {
+		console.log('falling back to event streams')
+		this.streams = new Map()
+		this.openStream('', '/api/v1/channels/chats/events')
+		this.channels.forEach((_, channelID) => this.openChannelStream(channelID))
+		this.unacked.forEach(frame => this.post(frame))
+	}
+
+	private openChannelStream(channelID: string) {
+		const after = this.lastSeen.get(channelID)?.()
+		const query = after ? `?after=${encodeURIComponent(after)}` : ''
+		this.openStream(channelID, `/api/v1/channels/${channelID}/messages/events${query}`)
+	}
+
+	private openStream(key: string, url: string) {
+		const stream = new EventSource(url)
+		stream.onmessage = message => this.dispatch(JSON.parse(message.data) as Event)
+		this.streams.set(key, stream)
+	}
+
+	private closeStream(key: string) {
+		this.streams.get(key)?.close()
+		this.streams.delete(key)
+	}
+
+	// post sends a message over HTTP, answering it with the same ack or error the socket would have.
+	private post(frame: Frame) {
+		const envelope = { v: EVENT_VERSION, id: frame.nonce, ts: new Date().toISOString(), channelID: frame.channelID }
+
+		axios.post(`/api/v1/channels/${frame.channelID}/messages`, { body: frame.body, nonce: frame.nonce, parentID: frame.parentID, attachmentIDs: frame.attachmentIDs })
+			.then(response => {
+				const message = response.data.message
+				this.dispatch({ ...envelope, type: 'ack', payload: { nonce: frame.nonce, messageID: message.messageID, seq: message.seq } })
+			})
+			.catch(error => {
+				// Without an answer the message stays unacked, and goes again when the session reconnects
+				if (!error.response) return
+
+				this.dispatch({ ...envelope, type: 'error', payload: { nonce: frame.nonce, message: error.response.data?.errors?.[0] ?? error.message } })
+			})
+	}
+
+	private dispatch(event: Event) {
+		if (event.v !== EVENT_VERSION) console.warn('unexpected event version', event)
+		if (event.type === 'error') console.error('session socket error', event)
+		if ((event.type === 'ack' || event.type === 'error') && event.payload.nonce) this.unacked.delete(event.payload.nonce)
+
+		// The server unsubscribes a channel on its own when its feed ends, so pick it back up where it left off
+		if (event.type === 'unsubscribed' && this.channels.has(event.channelID) && this.isOpen()) {
+			this.resubscribe(event.channelID)
+		}
+
+		// A resubscribe only replays changes after the last the channel's feed sent
+		if ((event.type === 'subscribed' || event.type.startsWith('message.')) && this.channels.has(event.channelID)) {
+			this.heardAt.set(event.channelID, event.ts)
+		}
+
+		this.listeners.forEach(listener => listener(event))
+	}
+
+	// resubscribe picks a channel back up from the last message the chat saw.
+	private resubscribe(channelID: string) {
+		this.write({ type: 'subscribe', channelID, after: this.lastSeen.get(channelID)?.(), since: this.heardAt.get(channelID) })
+	}
+
+	// beat tells the server whether the user is idle.
+	private beat() {
+		if (!this.isOpen()) return
+
+		this.idle = Date.now() - this.lastActiveAt > IDLE_MS
+		this.write({ type: 'heartbeat', idle: this.idle })
+	}
+
+	private disconnect() {
+		const socket = this.socket
+		this.socket = null
+		clearInterval(this.heartbeat)
+		if (socket) socket.close()
+
+		this.streams?.forEach(stream => stream.close())
+		this.streams = null
+	}
+
+	private isOpen(): boolean {
+		return this.socket?.readyState === WebSocket.OPEN
+	}
+
+	private write(frame: Frame) {
+		this.socket.send(JSON.stringify(frame))
+	}
+}
+
+function newNonce(): string {
+	if (crypto.randomUUID) return crypto.randomUUID()
+
+	return Array.from(crypto.getRandomValues(new Uint8Array(16)), byte => byte.toString(16).padStart(2, '0')).join('')
+}
+
+const sessionSocket = new SessionSocket()
+export default sessionSocket
+
+export function useSessionSocket(onEvent: Listener) {
+	const onEventRef = useRef(onEvent)
+	onEventRef.current = onEvent
+
+	useEffect(() => sessionSocket.listen(event => onEventRef.current(event)), [])
+}
@@ -1,5 +1,5 @@
 import { createAsyncThunk, createSlice } from "@reduxjs/toolkit";
-import { Channel } from "../model/model";
+import { Channel, Unread } from "../model/model";
 import axios from "../axios";
 import * as _ from "lodash";
 import {UserLookup} from "./usersSlice";
@@ -10,7 +10,7 @@ export const fetchChannels = createAsyncThunk(
 	'channels/fetchChannels',
 	async () => {
 		const response = await axios.get('/api/v1/channels')
-		return response.data.channels as ChannelLookup
+		return response.data as { channels: ChannelLookup, unreads: { [key: string]: Unread } }
 	}
 )
 
@@ -60,7 +60,7 @@ const channelsSlice = createSlice({
 	reducers: {},
 	extraReducers: builder => {
 		builder.addCase(fetchChannels.fulfilled, (state, action) => {
-			return action.payload
+			return action.payload.channels
 		})
 
 		builder.addCase(fetchChannel.fulfilled, (state, action) => {
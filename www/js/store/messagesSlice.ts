@@ -1,5 +1,5 @@
 import {createAsyncThunk, createSlice, PayloadAction} from "@reduxjs/toolkit";
-import {Message} from "../model/model";
+import {Attachment, Message} from "../model/model";
 import axios from "../axios";
 import * as _ from "lodash";
 
@@ -9,7 +9,59 @@ export const fetchMessages = createAsyncThunk(
 	'messages/fetchMessages',
 	async (channelID: string) => {
 		const response = await axios.get(`/api/v1/channels/${channelID}/messages`)
-		return response.data.messages as MessageLookup
+		return _.keyBy(response.data.messages as Message[], 'messageID') as MessageLookup
+	}
+)
+
+export const fetchReplies = createAsyncThunk(
+	'messages/fetchReplies',
+	async ({ channelID, messageID }: { channelID: string, messageID: string }) => {
+		const response = await axios.get(`/api/v1/channels/${channelID}/messages/${messageID}/replies`)
+		return _.keyBy(response.data.replies as Message[], 'messageID') as MessageLookup
+	}
+)
+
+export const editMessage = createAsyncThunk(
+	'messages/editMessage',
+	async ({ channelID, messageID, body }: { channelID: string, messageID: string, body: string }) => {
+		const response = await axios.patch(`/api/v1/channels/${channelID}/messages/${messageID}`, { body })
+		return response.data.message as Message
+	}
+)
+
+export const deleteMessage = createAsyncThunk(
+	'messages/deleteMessage',
+	async ({ channelID, messageID }: { channelID: string, messageID: string }) => {
+		const response = await axios.delete(`/api/v1/channels/${channelID}/messages/${messageID}`)
+		return response.data.message as Message
+	}
+)
+
+export const addReaction = createAsyncThunk(
+	'messages/addReaction',
+	async ({ channelID, messageID, emoji }: { channelID: string, messageID: string, emoji: string }) => {
+		const response = await axios.put(`/api/v1/channels/${channelID}/messages/${messageID}/reactions/${encodeURIComponent(emoji)}`)
+		return response.data.message as Message
+	}
+)
+
+export const removeReaction = createAsyncThunk(
+	'messages/removeReaction',
+	async ({ channelID, messageID, emoji }: { channelID: string, messageID: string, emoji: string }) => {
+		const response = await axios.delete(`/api/v1/channels/${channelID}/messages/${messageID}/reactions/${encodeURIComponent(emoji)}`)
+		return response.data.message as Message
+	}
+)
+
+// uploadAttachment uploads a file to send to a channel. It isn't posted until it's sent with a message.
+export const uploadAttachment = createAsyncThunk(
+	'messages/uploadAttachment',
+	async ({ channelID, file }: { channelID: string, file: File }) => {
+		const form = new FormData()
+		form.append('file', file)
+
+		const response = await axios.post(`/api/v1/channels/${channelID}/attachments`, form)
+		return response.data.attachment as Attachment
 	}
 )
 
@@ -19,16 +71,45 @@ const messagesSlice = createSlice({
 	reducers: {
 		receiveMessage: (state, action: PayloadAction<Message>) => {
 			const message = action.payload
-			return _.merge({}, state, { [message.messageID]: message })
-		}
+			return { ...state, [message.messageID]: message }
+		},
+		removeMessage: (state, action: PayloadAction<string>) => {
+			return _.omit(state, action.payload)
+		},
+		tombstoneMessage: (state, action: PayloadAction<{ messageID: string, deletedAt: string }>) => {
+			const message = state[action.payload.messageID]
+			if (!message) return state
+
+			return { ...state, [message.messageID]: { ...message, body: '', deletedAt: action.payload.deletedAt } }
+		},
 	},
 	extraReducers: builder => {
 		builder.addCase(fetchMessages.fulfilled, (state, action) => {
 			return _.merge({}, state, action.payload)
 		})
+
+		builder.addCase(fetchReplies.fulfilled, (state, action) => {
+			return _.merge({}, state, action.payload)
+		})
+
+		builder.addCase(editMessage.fulfilled, (state, action) => {
+			return { ...state, [action.payload.messageID]: action.payload }
+		})
+
+		builder.addCase(deleteMessage.fulfilled, (state, action) => {
+			return { ...state, [action.payload.messageID]: action.payload }
+		})
+
+		builder.addCase(addReaction.fulfilled, (state, action) => {
+			return { ...state, [action.payload.messageID]: action.payload }
+		})
+
+		builder.addCase(removeReaction.fulfilled, (state, action) => {
+			return { ...state, [action.payload.messageID]: action.payload }
+		})
 	}
 })
 
 export default messagesSlice
 
-export const { receiveMessage } = messagesSlice.actions
+export const { receiveMessage, removeMessage, tombstoneMessage } = messagesSlice.actions
@@ -0,0 +1,46 @@
+import {createAsyncThunk, createSlice, PayloadAction} from "@reduxjs/toolkit";
+import {Notification} from "../model/model";
+import axios from "../axios";
+import * as _ from "lodash";
+
+export type NotificationLookup = { [key: string]: Notification }
+
+export const fetchNotifications = createAsyncThunk(
+	'notifications/fetchNotifications',
+	async () => {
+		const response = await axios.get('/api/v1/notifications', { params: { unread: true } })
+		return _.keyBy(response.data.notifications as Notification[], 'notificationID') as NotificationLookup
+	}
+)
+
+export const readNotifications = createAsyncThunk(
+	'notifications/readNotifications',
+	async (notificationIDs: string[]) => {
+		const response = await axios.post('/api/v1/notifications/read', { notificationIDs })
+		return _.keyBy(response.data.notifications as Notification[], 'notificationID') as NotificationLookup
+	}
+)
+
+const notificationsSlice = createSlice({
+	name: 'notifications',
+	initialState: {} as NotificationLookup,
+	reducers: {
+		receiveNotification: (state, action: PayloadAction<Notification>) => {
+			const notification = action.payload
+			return _.assign({}, state, { [notification.notificationID]: notification })
+		}
+	},
+	extraReducers: builder => {
+		builder.addCase(fetchNotifications.fulfilled, (state, action) => {
+			return _.assign({}, state, action.payload)
+		})
+
+		builder.addCase(readNotifications.fulfilled, (state, action) => {
+			return _.assign({}, state, action.payload)
+		})
+	}
+})
+
+export default notificationsSlice
+
+export const { receiveNotification } = notificationsSlice.actions
@@ -0,0 +1,20 @@
+import {createSlice, PayloadAction} from "@reduxjs/toolkit";
+import {Presence} from "../model/events";
+import * as _ from "lodash";
+
+export type PresenceLookup = { [key: string]: Presence }
+
+const presencesSlice = createSlice({
+	name: 'presences',
+	initialState: {} as PresenceLookup,
+	reducers: {
+		receivePresence: (state, action: PayloadAction<Presence>) => {
+			const presence = action.payload
+			return _.assign({}, state, { [presence.userID]: presence })
+		}
+	},
+})
+
+export default presencesSlice
+
+export const { receivePresence } = presencesSlice.actions
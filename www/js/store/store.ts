@@ -5,6 +5,9 @@ import userSlice from './userSlice'
 import channelsSlice from "./channelsSlice";
 import usersSlice from "./usersSlice";
 import messagesSlice from "./messagesSlice";
+import unreadsSlice from "./unreadsSlice";
+import presencesSlice from "./presencesSlice";
+import notificationsSlice from "./notificationsSlice";
 
 const store = configureStore({
 	reducer: {
@@ -12,6 +15,9 @@ const store = configureStore({
 		users: usersSlice.reducer,
 		channels: channelsSlice.reducer,
 		messages: messagesSlice.reducer,
+		unreads: unreadsSlice.reducer,
+		presences: presencesSlice.reducer,
+		notifications: notificationsSlice.reducer,
 	},
 	middleware: (getDefaultMiddleware) => getDefaultMiddleware().concat(logger),
 })
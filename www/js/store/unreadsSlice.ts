@@ -0,0 +1,46 @@
+import {createAsyncThunk, createSlice, PayloadAction} from "@reduxjs/toolkit";
+import {Unread} from "../model/model";
+import axios from "../axios";
+import * as _ from "lodash";
+import {destroyChannel, fetchChannels} from "./channelsSlice";
+
+export type UnreadLookup = { [key: string]: Unread }
+
+export const markChannelRead = createAsyncThunk(
+	'unreads/markChannelRead',
+	async ({ channelID, messageID }: { channelID: string, messageID: string }) => {
+		const response = await axios.post(`/api/v1/channels/${channelID}/read`, { messageID })
+		return response.data.unread as Unread
+	}
+)
+
+const unreadsSlice = createSlice({
+	name: 'unreads',
+	initialState: {} as UnreadLookup,
+	reducers: {
+		receiveUnread: (state, action: PayloadAction<Unread>) => {
+			const unread = action.payload
+			return _.merge({}, state, { [unread.channelID]: unread })
+		}
+	},
+	extraReducers: builder => {
+		builder.addCase(fetchChannels.fulfilled, (state, action) => {
+			return action.payload.unreads
+		})
+
+		builder.addCase(markChannelRead.fulfilled, (state, action) => {
+			const unread = action.payload
+			return _.merge({}, state, { [unread.channelID]: unread })
+		})
+
+		builder.addCase(destroyChannel.fulfilled, (state, action) => {
+			const copy = _.merge({}, state)
+			delete copy[action.payload]
+			return copy
+		})
+	}
+})
+
+export default unreadsSlice
+
+export const { receiveUnread } = unreadsSlice.actions
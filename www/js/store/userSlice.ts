@@ -50,6 +50,14 @@ export const fetchCurrentUser = createAsyncThunk(
 	}
 )
 
+export const updateAway = createAsyncThunk(
+	'users/updateAway',
+	async (awayMessage: string) => {
+		const response = await axios.put('/api/v1/user/away', { awayMessage })
+		return response.data.user as User
+	}
+)
+
 type SliceState = { status: 'not checked', user: null }
 	| { status: 'checking', user: null }
 	| { status: 'checked', user?: User }
@@ -89,6 +97,10 @@ const userSlice = createSlice({
 		builder.addCase(fetchCurrentUser.rejected, (state, action) => {
 			state.status = 'checked'
 		})
+
+		builder.addCase(updateAway.fulfilled, (state, action) => {
+			state.user = action.payload
+		})
 	}
 })
 
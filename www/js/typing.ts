@@ -0,0 +1,43 @@
+import {useEffect, useRef, useState} from "react";
+import {useSessionSocket} from "./sessionSocket";
+
+// TYPIST_TIMEOUT_MS matches the server's, in case a typing.stopped goes missing.
+const TYPIST_TIMEOUT_MS = 6000
+
+// useTypists returns the IDs of the users typing in a channel.
+export function useTypists(channelID: string): string[] {
+	const [typists, setTypists] = useState<string[]>([])
+	const timers = useRef(new Map<string, ReturnType<typeof setTimeout>>())
+
+	function remove(userID: string) {
+		clearTimeout(timers.current.get(userID))
+		timers.current.delete(userID)
+		setTypists(typists => typists.filter(typist => typist !== userID))
+	}
+
+	useSessionSocket(event => {
+		if (event.channelID !== channelID) return
+
+		switch (event.type) {
+			case 'typing.started': {
+				const userID = event.payload.userID
+				clearTimeout(timers.current.get(userID))
+				timers.current.set(userID, setTimeout(() => remove(userID), TYPIST_TIMEOUT_MS))
+				setTypists(typists => typists.includes(userID) ? typists : [...typists, userID])
+				break
+			}
+
+			case 'typing.stopped':
+				remove(event.payload.userID)
+				break
+
+			case 'message.created':
+				remove(event.payload.userID)
+				break
+		}
+	})
+
+	useEffect(() => () => timers.current.forEach(timer => clearTimeout(timer)), [])
+
+	return typists
+}